@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// wilcoxonSignedRank performs the Wilcoxon signed-rank test on the paired differences diffs
+// with the normal approximation. It returns the number of nonzero differences and the z
+// score, which is positive if the differences tend to be positive.
+func wilcoxonSignedRank(diffs []float64) (int, float64) {
+	var nonzero []float64
+	for _, d := range diffs {
+		if d != 0 {
+			nonzero = append(nonzero, d)
+		}
+	}
+	n := len(nonzero)
+	if n == 0 {
+		return 0, 0
+	}
+	sort.Slice(nonzero, func(i, j int) bool { return math.Abs(nonzero[i]) < math.Abs(nonzero[j]) })
+
+	// ties share the average of their ranks
+	w := 0.0
+	tie_correction := 0.0
+	for i := 0; i < n; {
+		j := i
+		for j < n && math.Abs(nonzero[j]) == math.Abs(nonzero[i]) {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if nonzero[k] > 0 {
+				w += rank
+			}
+		}
+		t := float64(j - i)
+		tie_correction += t*t*t - t
+		i = j
+	}
+
+	fn := float64(n)
+	mean := fn * (fn + 1) / 4
+	variance := fn*(fn+1)*(2*fn+1)/24 - tie_correction/48
+	if variance <= 0 {
+		return n, 0
+	}
+	// continuity correction
+	d := w - mean
+	if d > 0 {
+		d = math.Max(d-0.5, 0)
+	} else {
+		d = math.Min(d+0.5, 0)
+	}
+	return n, d / math.Sqrt(variance)
+}
+
+// normalCdf returns the cumulative distribution function of the standard normal distribution.
+func normalCdf(z float64) float64 {
+	return math.Erfc(-z/math.Sqrt2) / 2
+}
+
+// SignificanceReport returns the paired tests of whether the second engine of c is faster
+// than the first one, in time and in nodes, over the positions solved by both.
+func (c *EngineComparison) SignificanceReport() string {
+	var sb strings.Builder
+	test := func(name string, value func(Result) float64) {
+		var diffs []float64
+		faster := 0
+		for j := range c.problems {
+			a, b := c.results[0][j], c.results[1][j]
+			if a.Err != nil || b.Err != nil || value(a) <= 0 || value(b) <= 0 {
+				continue
+			}
+			// the log ratio is positive if B is faster
+			diff := math.Log(value(a) / value(b))
+			diffs = append(diffs, diff)
+			if diff > 0 {
+				faster += 1
+			}
+		}
+		if len(diffs) == 0 {
+			return
+		}
+		sort.Float64s(diffs)
+		median := math.Exp(diffs[len(diffs)/2])
+		n, z := wilcoxonSignedRank(diffs)
+		p := 2 * (1 - normalCdf(math.Abs(z)))
+		fmt.Fprintf(&sb, "%s: B faster on %d/%d  median speedup %.2fx  Wilcoxon z = %.2f, p = %.3f  confidence that B is faster: %.1f%%",
+			name, faster, len(diffs), median, z, p, 100*normalCdf(z))
+		if n == 0 {
+			sb.WriteString("  (no difference)")
+		} else if n < 10 {
+			sb.WriteString("  (too few positions for the approximation)")
+		}
+		sb.WriteString("\n")
+	}
+	test("time", func(res Result) float64 { return res.Time.Seconds() })
+	test("nodes", func(res Result) float64 { return float64(res.Nodes) })
+
+	return sb.String()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// adaptiveWarmup is the number of solved positions needed before the time limit adapts.
+const adaptiveWarmup = 20
+
+// AdaptiveTimeLimit limits each position to a percentile of the solve times so far multiplied
+// by a factor, so that hopeless positions give up early while slow ones are not cut off.
+type AdaptiveTimeLimit struct {
+	percentile float64
+	factor     float64
+
+	mu    sync.Mutex
+	times []time.Duration
+	// sorted is set while times is in ascending order
+	sorted bool
+}
+
+func newAdaptiveTimeLimit(percentile float64, factor float64) *AdaptiveTimeLimit {
+	return &AdaptiveTimeLimit{percentile: percentile, factor: factor}
+}
+
+// Observe adds the solve time of res if the engine solved it.
+func (a *AdaptiveTimeLimit) Observe(res Result) {
+	if res.Err != nil || res.Cached || res.MirrorOf != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.times = append(a.times, res.Time)
+	a.sorted = false
+}
+
+// Limit returns the time limit (ms) of the next position, which is time_limit_ms until enough
+// positions are solved, and is never longer than time_limit_ms if it is set.
+func (a *AdaptiveTimeLimit) Limit(time_limit_ms int) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.times) < adaptiveWarmup {
+		return time_limit_ms
+	}
+
+	if !a.sorted {
+		slices.Sort(a.times)
+		a.sorted = true
+	}
+	limit_ms := max(int(float64(percentile(a.times, a.percentile).Milliseconds())*a.factor), 1)
+	if time_limit_ms > 0 && time_limit_ms < limit_ms {
+		return time_limit_ms
+	}
+	return limit_ms
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// setAffinity binds the process pid to cpus with sched_setaffinity. Threads created by the
+// process afterwards inherit the affinity.
+func setAffinity(pid int, cpus []int) error {
+	var mask [1024 / 64]uint64
+	for _, cpu := range cpus {
+		mask[cpu/64] |= 1 << (cpu % 64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(pid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask[0])))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// physicalCores returns the number of physical cores, counting the hyperthreads sharing a core
+// once.
+func physicalCores() (int, error) {
+	paths, err := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/topology/thread_siblings_list")
+	if err != nil || len(paths) == 0 {
+		return 0, fmt.Errorf("no CPU topology in /sys")
+	}
+	cores := make(map[string]bool)
+	for _, path := range paths {
+		siblings, err := os.ReadFile(path)
+		if err != nil {
+			return 0, err
+		}
+		cores[strings.TrimSpace(string(siblings))] = true
+	}
+	return len(cores), nil
+}
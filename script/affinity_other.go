@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package main
+
+import "errors"
+
+func setAffinity(pid int, cpus []int) error {
+	return errors.New("CPU affinity is not supported on this platform")
+}
+
+func physicalCores() (int, error) {
+	return 0, errors.New("the CPU topology is unknown on this platform")
+}
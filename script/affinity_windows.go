@@ -0,0 +1,61 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procSetProcessAffinityMask         = kernel32.NewProc("SetProcessAffinityMask")
+	procGetLogicalProcessorInformation = kernel32.NewProc("GetLogicalProcessorInformation")
+)
+
+// setAffinity binds the process pid to cpus with SetProcessAffinityMask. Only the first 64
+// processors, i.e. the first processor group, can be used.
+func setAffinity(pid int, cpus []int) error {
+	handle, err := syscall.OpenProcess(process_set_information|process_query_information, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(handle)
+
+	var mask uintptr
+	for _, cpu := range cpus {
+		if cpu < 64 {
+			mask |= 1 << cpu
+		}
+	}
+	if ret, _, err := procSetProcessAffinityMask.Call(uintptr(handle), mask); ret == 0 {
+		return err
+	}
+	return nil
+}
+
+// systemLogicalProcessorInformation is SYSTEM_LOGICAL_PROCESSOR_INFORMATION, whose last field
+// is a union of 16 bytes aligned as ULONGLONG.
+type systemLogicalProcessorInformation struct {
+	ProcessorMask uintptr
+	Relationship  uint32
+	Union         [2]uint64
+}
+
+// physicalCores returns the number of physical cores with GetLogicalProcessorInformation.
+func physicalCores() (int, error) {
+	const relation_processor_core = 0
+	var length uint32
+	procGetLogicalProcessorInformation.Call(0, uintptr(unsafe.Pointer(&length)))
+	if length == 0 {
+		return 0, syscall.EINVAL
+	}
+	infos := make([]systemLogicalProcessorInformation, int(length)/int(unsafe.Sizeof(systemLogicalProcessorInformation{}))+1)
+	if ret, _, err := procGetLogicalProcessorInformation.Call(uintptr(unsafe.Pointer(&infos[0])), uintptr(unsafe.Pointer(&length))); ret == 0 {
+		return 0, err
+	}
+	cores := 0
+	for _, info := range infos[:int(length)/int(unsafe.Sizeof(infos[0]))] {
+		if info.Relationship == relation_processor_core {
+			cores++
+		}
+	}
+	return cores, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Answer is the known solution of a position in an answer database.
+type Answer struct {
+	MateLen    int
+	NoMate     bool
+	Pv         []string
+	Collection string
+}
+
+type jsonAnswer struct {
+	Sfen       string   `json:"sfen"`
+	MateLen    int      `json:"mate_len"`
+	NoMate     bool     `json:"nomate"`
+	Pv         []string `json:"pv"`
+	Collection string   `json:"collection"`
+}
+
+type answerAccuracy struct {
+	total   int
+	correct int
+	same_pv int
+}
+
+// AnswerDB is a database of known answers, e.g. of published collections, keyed by
+// positionKey. It gives expectations to the problems without their own, and counts the
+// correct answers per collection.
+type AnswerDB struct {
+	answers  map[string]Answer
+	accuracy map[string]*answerAccuracy
+}
+
+// loadAnswerDB reads an answer database in JSON Lines, one answer per line such as
+// {"sfen": "...", "mate_len": 7, "pv": ["G*5b", ...], "collection": "..."}. The collection
+// defaults to the name of the file.
+func loadAnswerDB(path string) (*AnswerDB, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	default_collection := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	db := &AnswerDB{answers: make(map[string]Answer), accuracy: make(map[string]*answerAccuracy)}
+	scanner := bufio.NewScanner(file)
+	for line_no := 1; scanner.Scan(); line_no++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var record jsonAnswer
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line_no, err)
+		}
+		problem, err := parseProblem(record.Sfen)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line_no, err)
+		}
+		answer := Answer{MateLen: record.MateLen, NoMate: record.NoMate, Pv: record.Pv, Collection: record.Collection}
+		if answer.MateLen == 0 {
+			answer.MateLen = len(answer.Pv)
+		}
+		if answer.Collection == "" {
+			answer.Collection = default_collection
+		}
+		db.answers[positionKey(problem)] = answer
+	}
+
+	return db, scanner.Err()
+}
+
+// Apply sets the known answer of problem as its expectation unless it already has one.
+func (db *AnswerDB) Apply(problem Problem) Problem {
+	if db == nil || problem.HasExpectation() {
+		return problem
+	}
+	answer, ok := db.answers[positionKey(problem)]
+	if !ok {
+		return problem
+	}
+
+	problem.NoMate = answer.NoMate
+	if !answer.NoMate {
+		problem.MateLen = answer.MateLen
+		if len(answer.Pv) > 0 {
+			problem.FirstMove = answer.Pv[0]
+		}
+	}
+	return problem
+}
+
+// Add counts res into the accuracy of the collection of its position.
+func (db *AnswerDB) Add(res Result) {
+	answer, ok := db.answers[positionKey(res.Problem)]
+	if !ok || res.MirrorOf != nil {
+		return
+	}
+	accuracy, ok := db.accuracy[answer.Collection]
+	if !ok {
+		accuracy = &answerAccuracy{}
+		db.accuracy[answer.Collection] = accuracy
+	}
+
+	accuracy.total += 1
+	switch {
+	case answer.NoMate:
+		if errors.Is(res.Err, errNoMate) {
+			accuracy.correct += 1
+		}
+	case res.Err == nil && len(res.Pv) == answer.MateLen:
+		accuracy.correct += 1
+		if sameSolution(res.Problem, res.Pv, answer.Pv) {
+			accuracy.same_pv += 1
+		}
+	}
+}
+
+// AccuracyTable returns the accuracy of the answers per collection, or "" if no position has
+// a known answer.
+func (db *AnswerDB) AccuracyTable() string {
+	if len(db.accuracy) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(db.accuracy))
+	width := len("collection")
+	for name := range db.accuracy {
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-*s  %13s  %8s  %7s\n", width, "collection", "correct/total", "accuracy", "same pv")
+	for _, name := range names {
+		a := db.accuracy[name]
+		fmt.Fprintf(&sb, "%-*s  %13s  %7.1f%%  %7d\n", width, name,
+			fmt.Sprintf("%d/%d", a.correct, a.total), 100*float64(a.correct)/float64(a.total), a.same_pv)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// baselineMinSlowdown is the minimum increase of the solve time regarded as a slowdown, so that
+// fluctuations of very short searches are not reported.
+const baselineMinSlowdown = 100 * time.Millisecond
+
+type baselineEntry struct {
+	solved   bool
+	mate_len int
+	pv       []string
+	time     time.Duration
+}
+
+// loadBaseline reads a results file written with --out-format json or csv, keyed by
+// positionKey.
+func loadBaseline(path string) (map[string]baselineEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return loadJsonBaseline(bytes.NewReader(data))
+	}
+	return loadCsvBaseline(bytes.NewReader(data))
+}
+
+func loadJsonBaseline(r io.Reader) (map[string]baselineEntry, error) {
+	entries := make(map[string]baselineEntry)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for line_no := 1; scanner.Scan(); line_no++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var record jsonResult
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line_no, err)
+		}
+		entry := baselineEntry{solved: record.Status == "solved", pv: record.Pv, time: time.Duration(record.TimeMs) * time.Millisecond}
+		if record.MateLen != nil {
+			entry.mate_len = *record.MateLen
+		}
+		entries[positionKey(Problem{Sfen: record.Sfen, Moves: record.Moves})] = entry
+	}
+
+	return entries, scanner.Err()
+}
+
+func loadCsvBaseline(r io.Reader) (map[string]baselineEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty results file")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	for _, name := range []string{"position", "status", "time_ms", "mate_len"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("no %q column", name)
+		}
+	}
+
+	entries := make(map[string]baselineEntry)
+	for i, record := range records[1:] {
+		field := func(name string) string {
+			if column := columns[name]; column < len(record) {
+				return record[column]
+			}
+			return ""
+		}
+		problem, err := parseProblem(field("position"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+2, err)
+		}
+		time_ms, _ := strconv.ParseInt(field("time_ms"), 10, 64)
+		mate_len, _ := strconv.Atoi(field("mate_len"))
+		entries[positionKey(problem)] = baselineEntry{
+			solved:   field("status") == "solved",
+			mate_len: mate_len,
+			pv:       strings.Fields(field("pv")),
+			time:     time.Duration(time_ms) * time.Millisecond,
+		}
+	}
+
+	return entries, nil
+}
+
+// Comparison compares the results of a run with a baseline run, e.g. the previous nightly
+// benchmark, and collects the positions whose results changed.
+type Comparison struct {
+	baseline  map[string]baselineEntry
+	slowdown  float64
+	compared  int
+	lost      []string
+	gained    []string
+	mate_lens []string
+	solutions []string
+	slower    []string
+}
+
+func newComparison(baseline map[string]baselineEntry, slowdown float64) *Comparison {
+	return &Comparison{baseline: baseline, slowdown: slowdown}
+}
+
+func (c *Comparison) Add(res Result) {
+	entry, ok := c.baseline[positionKey(res.Problem)]
+	if !ok || res.MirrorOf != nil {
+		return
+	}
+	c.compared += 1
+
+	position := res.Problem.String()
+	solved := res.Err == nil
+	switch {
+	case entry.solved && !solved:
+		c.lost = append(c.lost, fmt.Sprintf("%v: %v", res.Err, position))
+	case !entry.solved && solved:
+		c.gained = append(c.gained, fmt.Sprintf("mate %d: %v", len(res.Pv), position))
+	case solved && entry.mate_len != len(res.Pv):
+		c.mate_lens = append(c.mate_lens, fmt.Sprintf("mate %d -> %d: %v", entry.mate_len, len(res.Pv), position))
+	case solved && len(entry.pv) > 0 && !sameSolution(res.Problem, entry.pv, res.Pv):
+		c.solutions = append(c.solutions, fmt.Sprintf("%s -> %s: %v", strings.Join(entry.pv, " "), strings.Join(res.Pv, " "), position))
+	}
+
+	if c.slowdown > 0 && !res.Cached && solved && entry.solved &&
+		res.Time-entry.time >= baselineMinSlowdown && float64(res.Time) > float64(entry.time)*c.slowdown {
+		c.slower = append(c.slower, fmt.Sprintf("%.2fs -> %.2fs: %v", entry.time.Seconds(), res.Time.Seconds(), position))
+	}
+}
+
+func (c *Comparison) String() string {
+	var sb strings.Builder
+	section := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		sort.Strings(lines)
+		fmt.Fprintf(&sb, "%s:\n", title)
+		for _, line := range lines {
+			fmt.Fprintf(&sb, "  %s\n", line)
+		}
+	}
+	section("solved -> unsolved", c.lost)
+	section("unsolved -> solved", c.gained)
+	section("mate length changed", c.mate_lens)
+	section("solution changed", c.solutions)
+	section(fmt.Sprintf("slower than %.1fx", c.slowdown), c.slower)
+	fmt.Fprintf(&sb, "baseline: compared %d  solved->unsolved: %d  unsolved->solved: %d  mate length changed: %d  solution changed: %d  slower: %d\n",
+		c.compared, len(c.lost), len(c.gained), len(c.mate_lens), len(c.solutions), len(c.slower))
+
+	return sb.String()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+)
+
+// totalPieces is the number of the pieces of each type in hand in a game.
+var totalPieces = map[shogi.PieceType]int{
+	shogi.Rook: 2, shogi.Bishop: 2, shogi.Gold: 4, shogi.Silver: 4, shogi.Knight: 4, shogi.Lance: 4, shogi.Pawn: 18,
+}
+
+// boardMove is a move with the piece which moves, for writing it in notations which name the
+// moving piece.
+type boardMove struct {
+	shogi.Move
+	Piece      shogi.PieceType // the moving piece before promotion, or the dropped piece
+	Color      shogi.Color
+	CanPromote bool
+}
+
+// describeMove returns m, which is played in pos, with the piece which moves.
+func describeMove(pos *shogi.Position, m shogi.Move) boardMove {
+	bm := boardMove{Move: m, Piece: m.Drop, Color: pos.SideToMove(), CanPromote: pos.CanPromote(m)}
+	if !m.IsDrop() {
+		bm.Piece = pos.Piece(m.From).Type
+	}
+
+	return bm
+}
+
+// playMove plays a USI move in pos. It checks that the move is consistent with the pieces but
+// does not check the legality of the move.
+func playMove(pos *shogi.Position, usi string) (boardMove, error) {
+	m, err := pos.ParseMove(usi)
+	if err != nil {
+		return boardMove{}, err
+	}
+	bm := describeMove(pos, m)
+	pos.Do(m)
+
+	return bm, nil
+}
+
+// diagram is a position read from a diagram such as BOD or CSA, whose defender may hold all
+// the pieces left.
+type diagram struct {
+	position  *shogi.Position
+	gote_rest bool
+}
+
+func newDiagram() diagram {
+	return diagram{position: shogi.NewPosition()}
+}
+
+// addHand adds count pieces of type t to the hand of color.
+func (d *diagram) addHand(color shogi.Color, t shogi.PieceType, count int) {
+	d.position.SetHand(color, t, d.position.Hand(color, t)+count)
+}
+
+// Sfen returns the SFEN of the diagram. If gote_rest is set, the pieces neither on the board nor
+// in the hand of sente are given to gote.
+func (d *diagram) Sfen() (string, error) {
+	if d.gote_rest {
+		for _, t := range shogi.HandTypes {
+			rest := totalPieces[t] - d.position.Hand(shogi.Black, t)
+			for sq := shogi.Square(0); sq < 81; sq++ {
+				if d.position.Piece(sq).Type.Unpromoted() == t {
+					rest--
+				}
+			}
+			if rest < 0 {
+				return "", fmt.Errorf("too many pieces: %v", t)
+			}
+			d.position.SetHand(shogi.White, t, rest)
+		}
+	}
+
+	return d.position.Sfen(), nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const resultCacheSchema = `
+CREATE TABLE IF NOT EXISTS cache (
+	key     TEXT PRIMARY KEY,
+	error   TEXT,
+	pv       TEXT,
+	time_ms  INTEGER NOT NULL,
+	nodes    INTEGER,
+	nps      INTEGER,
+	hashfull INTEGER
+);
+`
+
+var cacheableErrors = []error{errNoMate, errNoPv, errNoMateMoves, errTimeout, errTimeLimit, errNodesLimit}
+
+func defaultCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(dir, "KomoringHeights", "mate-cache.sqlite")
+}
+
+// engineID identifies the engine binary by the hash of its contents so that rebuilt
+// engines never share cached results. For a command with arguments, e.g. a wrapper such as
+// taskset, the command itself and every file named by its arguments are hashed as well.
+func engineID(command string) string {
+	args, err := splitCommand(command)
+	if err != nil || len(args) == 1 {
+		path, err := exec.LookPath(command)
+		if err != nil {
+			return command
+		}
+		if id, err := hashFile(path); err == nil {
+			return id
+		}
+		return command
+	}
+
+	hash := sha256.New()
+	io.WriteString(hash, command)
+	for i, arg := range args {
+		path := arg
+		if i == 0 {
+			if path, err = exec.LookPath(arg); err != nil {
+				continue
+			}
+		}
+		if stat, err := os.Stat(path); err == nil && stat.Mode().IsRegular() {
+			if id, err := hashFile(path); err == nil {
+				io.WriteString(hash, id)
+			}
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// hashFile returns the SHA-256 of the contents of the file path.
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ResultCache stores results keyed by the normalized position, the engine and the options
+// affecting the result, so that known results need not be solved again.
+type ResultCache struct {
+	db        *sql.DB
+	engine_id string
+	// options are the engine options without their own flags, which the key includes as well
+	options string
+	// files is the digest of the files named by the engine options, e.g. evaluation files, with
+	// their sizes and modification times
+	files string
+}
+
+// cacheOptions returns the engine options of op merged with the defaults declared by the engine
+// and sorted by name, except for those in the key with their own flags.
+func cacheOptions(op Options, declared []string) string {
+	var options []string
+	for _, option := range engineOptions(op.EngineOptions, declared) {
+		if _, ok := optionFlags[option.Name]; !ok {
+			options = append(options, option.Name+"="+option.Value)
+		}
+	}
+	sort.Strings(options)
+	return strings.Join(options, ",")
+}
+
+// fileOptions are the engine options naming files which results depend on. EvalDir names a
+// directory of evaluation files and BookFile names a file in BookDir.
+var fileOptions = []string{"EvalDir", "BookFile"}
+
+// cacheFiles returns the digest of the paths, the sizes and the modification times of the files
+// named by the file options of op, relative to the working directory of the first worker, so that
+// replacing e.g. an evaluation file in --engine-dir changes the key. It is "" if there are none.
+func cacheFiles(op Options) string {
+	values := make(map[string]string)
+	for _, option := range op.EngineOptions {
+		values[option.Name] = expandWorker(option.Value, 0)
+	}
+	dir := expandWorker(op.EngineDir, 0)
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+
+	var files []string
+	add := func(path string, info fs.FileInfo) {
+		files = append(files, fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano()))
+	}
+	for _, name := range fileOptions {
+		value, ok := values[name]
+		if !ok || value == "" {
+			continue
+		}
+		path := resolve(value)
+		if name == "BookFile" {
+			book_dir, ok := values["BookDir"]
+			if !ok {
+				book_dir = "book"
+			}
+			path = filepath.Join(resolve(book_dir), value)
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !stat.IsDir() {
+			add(path, stat)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() {
+				continue
+			}
+			if info, err := entry.Info(); err == nil {
+				add(filepath.Join(path, entry.Name()), info)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return ""
+	}
+
+	sort.Strings(files)
+	hash := sha256.Sum256([]byte(strings.Join(files, ",")))
+	return hex.EncodeToString(hash[:])
+}
+
+// openResultCache opens the cache at path for the results of the engine engine_id, which declares
+// the options declared.
+func openResultCache(path string, engine_id string, op Options, declared []string) (*ResultCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(resultCacheSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := addMissingColumns(db, "cache", metricColumns); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &ResultCache{db: db, engine_id: engine_id, options: cacheOptions(op, declared), files: cacheFiles(op)}, nil
+}
+
+// key returns the key of problem solved with op in time_limit_ms, the time limit actually given
+// to the engine.
+func (c *ResultCache) key(op Options, problem Problem, time_limit_ms int) string {
+	hash_size := op.HashSize
+	if problem.HashSize != nil {
+		hash_size = *problem.HashSize
+	}
+	depth_limit := op.DepthLimit
+	if problem.DepthLimit != nil {
+		depth_limit = *problem.DepthLimit
+	}
+
+	key := fmt.Sprintf("%s|%s|hash=%d|post=%d|depth=%d|time=%d",
+		c.engine_id, positionKey(problem), hash_size, op.PostSearchCount, depth_limit, time_limit_ms)
+	if op.NodesLimit > 0 {
+		key += fmt.Sprintf("|nodes=%d", op.NodesLimit)
+	}
+	if c.options != "" {
+		key += "|options=" + c.options
+	}
+	if c.files != "" {
+		key += "|files=" + c.files
+	}
+	return key
+}
+
+func (c *ResultCache) Lookup(op Options, problem Problem, time_limit_ms int) (Result, bool) {
+	var err_text, pv sql.NullString
+	var time_ms int64
+	var nodes, nps, hashfull sql.NullInt64
+	err := c.db.QueryRow(`SELECT error, pv, time_ms, nodes, nps, hashfull FROM cache WHERE key = ?`,
+		c.key(op, problem, time_limit_ms)).Scan(&err_text, &pv, &time_ms, &nodes, &nps, &hashfull)
+	if err != nil {
+		return Result{}, false
+	}
+
+	res := Result{
+		Problem:   problem,
+		Time:      time.Duration(time_ms) * time.Millisecond,
+		Cached:    true,
+		Nodes:     nodes.Int64,
+		Nps:       nps.Int64,
+		Hashfull:  int(hashfull.Int64),
+		TimeLimit: time_limit_ms,
+	}
+	if err_text.Valid {
+		res.Err = errors.New(err_text.String)
+		for _, e := range cacheableErrors {
+			if e.Error() == err_text.String {
+				res.Err = e
+			}
+		}
+	} else {
+		res.Pv = strings.Fields(pv.String)
+	}
+
+	return res, true
+}
+
+func (c *ResultCache) Store(op Options, res Result) error {
+	// retries solve the position with a larger hash and time than the key says
+	if res.Cached || res.MirrorOf != nil || res.Attempt > 1 || res.Retries > 0 {
+		return nil
+	}
+	// the portfolio solves the position with other configurations than the key says, and a
+	// failure cached under the key would keep the portfolio from trying it again
+	if res.Portfolio != "" || len(op.Portfolio) > 0 && survives(res) {
+		return nil
+	}
+	// the adaptive time limit depends on the solve times of the other positions in the run, so
+	// running out of it says little about the position and the same limit rarely comes again
+	if op.Adaptive != nil && res.TimeLimit != op.TimeLimit && errors.Is(res.Err, errTimeLimit) {
+		return nil
+	}
+
+	var err_text, pv sql.NullString
+	if res.Err != nil {
+		cacheable := false
+		for _, e := range cacheableErrors {
+			cacheable = cacheable || errors.Is(res.Err, e)
+		}
+		if !cacheable {
+			return nil
+		}
+		err_text = sql.NullString{String: res.Err.Error(), Valid: true}
+	} else {
+		pv = sql.NullString{String: strings.Join(res.Pv, " "), Valid: true}
+	}
+
+	_, err := c.db.Exec(`
+		INSERT OR REPLACE INTO cache (key, error, pv, time_ms, nodes, nps, hashfull)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		c.key(op, res.Problem, res.TimeLimit), err_text, pv, res.Time.Milliseconds(), res.Nodes, res.Nps, res.Hashfull)
+
+	return err
+}
+
+func (c *ResultCache) Close() error {
+	return c.db.Close()
+}
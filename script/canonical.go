@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+)
+
+// sameSolution returns true if a and b are the same solution of problem, different only in
+// trivial ways:
+//
+//   - The pieces of interpositions captured at once may differ, since any piece can be
+//     interposed there.
+//   - The defender may play in another order as long as the moves transpose to the same
+//     position, while the attacker plays the same moves.
+//
+// Both are replayed from the position of problem to see whether they transpose. If either
+// cannot be replayed, only identical PVs are the same.
+func sameSolution(problem Problem, a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	identical := true
+	for i := range a {
+		identical = identical && a[i] == b[i]
+	}
+	if identical {
+		return true
+	}
+
+	pos, err := problemPosition(problem)
+	if err != nil {
+		return false
+	}
+	attacker := pos.SideToMove()
+	pos_a, pos_b := *pos, *pos
+	// extra[t] is the number of pieces of type t which the attacker holds more in b than in a
+	// after capturing interpositions of different pieces, and the defender holds less
+	extra := make(map[shogi.PieceType]int)
+	diverged := false
+	for i := range a {
+		move_a, err := pos_a.ParseMove(a[i])
+		if err != nil || pos_a.CheckLegal(move_a) != nil {
+			return false
+		}
+		move_b, err := pos_b.ParseMove(b[i])
+		if err != nil || pos_b.CheckLegal(move_b) != nil {
+			return false
+		}
+
+		// only the defender may play in another order
+		if i%2 == 0 && a[i] != b[i] {
+			return false
+		}
+		if !diverged && a[i] != b[i] {
+			captured := i+1 < len(a) && a[i+1] == b[i+1] && len(a[i+1]) >= 4 && a[i+1][2:4] == move_a.To.String()
+			if i%2 == 1 && captured && move_a.IsDrop() && move_b.IsDrop() && move_a.To == move_b.To {
+				// interpositions of different pieces captured by the same move
+				extra[move_b.Drop]++
+				extra[move_a.Drop]--
+			} else {
+				diverged = true
+			}
+		}
+		pos_a.Do(move_a)
+		pos_b.Do(move_b)
+		if diverged && transposed(&pos_a, &pos_b, attacker, extra) {
+			diverged = false
+		}
+	}
+	return !diverged
+}
+
+// transposed returns true if a and b have the same pieces on the board and the same side to
+// move, and the attacker holds extra pieces more in b than in a, which the defender holds less.
+func transposed(a *shogi.Position, b *shogi.Position, attacker shogi.Color, extra map[shogi.PieceType]int) bool {
+	if a.SideToMove() != b.SideToMove() {
+		return false
+	}
+	for sq := shogi.Square(0); sq < 81; sq++ {
+		if a.Piece(sq) != b.Piece(sq) {
+			return false
+		}
+	}
+	for _, t := range shogi.HandTypes {
+		if b.Hand(attacker, t)-a.Hand(attacker, t) != extra[t] ||
+			b.Hand(attacker.Opponent(), t)-a.Hand(attacker.Opponent(), t) != -extra[t] {
+			return false
+		}
+	}
+	return true
+}
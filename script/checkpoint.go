@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func positionKey(problem Problem) string {
+	sfen, err := normalizeSfen(problem.Sfen)
+	if err != nil {
+		return problem.Position()
+	}
+
+	return problemKey(sfen, problem.Moves)
+}
+
+// categoryErrors are the errors of the categories of Result.Category, by which the results in
+// a checkpoint are restored.
+var categoryErrors = map[string]error{
+	"nomate":              errNoMate,
+	"no_pv":               errNoPv,
+	"no_mate_moves":       errNoMateMoves,
+	"timeout":             errTimeout,
+	"time_limit":          errTimeLimit,
+	"nodes_limit":         errNodesLimit,
+	"illegal_pv":          errIllegalPv,
+	"not_mate":            errNotMate,
+	"pawn_drop":           errPawnDrop,
+	"inconsistent_mate":   errInconsistentMate,
+	"repetition":          errRepetition,
+	"non_optimal_defense": errNonOptimalDefense,
+	"engine_error":        errCrashed,
+}
+
+// restoredError is an error restored from its message and its category.
+type restoredError struct {
+	message  string
+	category error
+}
+
+func (e restoredError) Error() string {
+	return e.message
+}
+
+func (e restoredError) Unwrap() error {
+	return e.category
+}
+
+// checkpointRecord is the result of a finished position in the checkpoint file.
+type checkpointRecord struct {
+	Category string   `json:"category,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Pv       []string `json:"pv,omitempty"`
+	TimeMs   int64    `json:"time_ms"`
+	Nodes    int64    `json:"nodes"`
+	Nps      int64    `json:"nps"`
+	Hashfull int      `json:"hashfull"`
+	Score    string   `json:"score,omitempty"`
+	// the analyses of the result, recorded in the same form as the JSON results
+	Surplus string          `json:"surplus,omitempty"`
+	Cooks   []jsonCook      `json:"cooks,omitempty"`
+	Mudaai  []jsonMudaai    `json:"mudaai,omitempty"`
+	Cross   *jsonCrossCheck `json:"cross_check,omitempty"`
+}
+
+// Result returns the result of problem recorded in r.
+func (r checkpointRecord) Result(problem Problem) Result {
+	res := Result{
+		Problem:  problem,
+		Pv:       r.Pv,
+		Time:     time.Duration(r.TimeMs) * time.Millisecond,
+		Nodes:    r.Nodes,
+		Nps:      r.Nps,
+		Hashfull: r.Hashfull,
+		Score:    r.Score,
+		Surplus:  r.Surplus,
+		Resumed:  true,
+	}
+	for _, cook := range r.Cooks {
+		res.Cooks = append(res.Cooks, Cook{Move: cook.Move, MateLen: cook.MateLen})
+	}
+	for _, mudaai := range r.Mudaai {
+		res.Interpositions = append(res.Interpositions, Interposition{Ply: mudaai.Ply, Move: mudaai.Move, Kind: mudaai.Kind})
+	}
+	if r.Cross != nil {
+		res.CrossCheck = &CrossCheck{Outcome: r.Cross.Outcome, Pv: r.Cross.Pv}
+	}
+	if r.Error != "" {
+		category, ok := categoryErrors[r.Category]
+		switch {
+		case !ok:
+			res.Err = errors.New(r.Error)
+		case category.Error() == r.Error:
+			res.Err = category
+		default:
+			res.Err = restoredError{message: r.Error, category: category}
+		}
+	}
+	return verifyResult(res)
+}
+
+// checkpointQueueKey is the key of the lines of the state of the queue in the checkpoint file.
+const checkpointQueueKey = "#queue"
+
+// checkpointQueue is the state of the queue of positions, which is written into the checkpoint
+// file whenever it changes. The last one is used on resume.
+type checkpointQueue struct {
+	// Read is the number of positions read from the input
+	Read int `json:"read"`
+	// InFlight are the keys of the positions handed, or being handed, to the engines and not
+	// finished yet
+	InFlight []string `json:"in_flight,omitempty"`
+	// Seed is the seed of --sample
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// Checkpoint records finished positions into a file, one key per line followed by a tab and
+// the result in JSON, so that an interrupted run can be resumed by skipping them. The state of
+// the queue is recorded as well: the positions being solved at the interruption are solved
+// first on resume, followed by the positions left in the order of the input, while the results
+// of the finished ones are restored. Lines of a key alone, written by older versions, are
+// skipped without the results.
+//
+// Only the hashes of the keys of the finished positions and the offsets of their lines are kept
+// in memory, and the results are read from the file when the positions are met again.
+type Checkpoint struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	// finished are the offsets of the lines of the finished positions in the file read on
+	// resume, or -1 for the lines without the results
+	finished map[dedupKey]int64
+	previous *os.File
+	skipped  int
+	restored []Result
+	done     chan struct{}
+	// queue is the state of the queue of this run, and resumed is that of the previous run
+	queue     checkpointQueue
+	in_flight map[string]int
+	dirty     bool
+	resumed   checkpointQueue
+}
+
+func newCheckpoint(path string, resume bool, interval time.Duration) (*Checkpoint, error) {
+	c := &Checkpoint{finished: make(map[dedupKey]int64), done: make(chan struct{}), in_flight: make(map[string]int)}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		file, err := os.Open(path)
+		if err == nil {
+			if err := c.load(file); err != nil {
+				file.Close()
+				return nil, err
+			}
+			// the lines read are never rewritten as the file is only appended to
+			c.previous = file
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		if c.previous != nil {
+			c.previous.Close()
+		}
+		return nil, err
+	}
+	c.file = file
+	c.writer = bufio.NewWriter(file)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.Flush()
+			case <-c.done:
+				return
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+// load reads the finished positions and the state of the queue from file.
+func (c *Checkpoint) load(file *os.File) error {
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == io.EOF && line == "" {
+			return nil
+		}
+		line_offset := offset
+		offset += int64(len(line))
+
+		key, data, has_result := strings.Cut(strings.TrimRight(line, "\r\n"), "\t")
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+		if key == checkpointQueueKey {
+			var queue checkpointQueue
+			if json.Unmarshal([]byte(data), &queue) == nil {
+				c.resumed = queue
+			}
+			continue
+		}
+		if !has_result {
+			c.finished[hashProblemKey(key)] = -1
+			continue
+		}
+		if json.Unmarshal([]byte(data), &checkpointRecord{}) != nil {
+			// a line cut off by a crash
+			continue
+		}
+		c.finished[hashProblemKey(key)] = line_offset
+	}
+}
+
+// record reads the result in the line at offset of the file read on resume.
+func (c *Checkpoint) record(offset int64) (checkpointRecord, error) {
+	var record checkpointRecord
+	reader := bufio.NewReader(io.NewSectionReader(c.previous, offset, 1<<62))
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return record, err
+	}
+	_, data, _ := strings.Cut(strings.TrimRight(line, "\r\n"), "\t")
+	err = json.Unmarshal([]byte(data), &record)
+	return record, err
+}
+
+// Finished reports whether problem has been finished in a previous run. Its result is kept
+// to be taken by Restored if recorded.
+func (c *Checkpoint) Finished(problem Problem) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	offset, ok := c.finished[hashProblemKey(positionKey(problem))]
+	if !ok {
+		return false
+	}
+	if offset < 0 {
+		c.skipped++
+		return true
+	}
+	record, err := c.record(offset)
+	if err != nil {
+		// solved again if the file has been modified since it was read
+		return false
+	}
+	c.restored = append(c.restored, record.Result(problem))
+	return true
+}
+
+// Restored returns the results restored by Finished since the last call.
+func (c *Checkpoint) Restored() []Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	restored := c.restored
+	c.restored = nil
+	return restored
+}
+
+// Resumed returns the state of the queue recorded by the previous run.
+func (c *Checkpoint) Resumed() checkpointQueue {
+	return c.resumed
+}
+
+// InFlightFirst moves the positions being solved at the interruption of the previous run to the
+// front of problems, keeping the order otherwise.
+func (c *Checkpoint) InFlightFirst(problems []Problem) []Problem {
+	in_flight := make(map[string]bool)
+	for _, key := range c.resumed.InFlight {
+		in_flight[key] = true
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		return in_flight[positionKey(problems[i])] && !in_flight[positionKey(problems[j])]
+	})
+	return problems
+}
+
+// SetRead records that read positions have been read from the input.
+func (c *Checkpoint) SetRead(read int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queue.Read = read
+	c.dirty = true
+}
+
+// SetSeed records the seed of --sample so that the same positions are sampled on resume.
+func (c *Checkpoint) SetSeed(seed int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queue.Seed = seed
+	c.dirty = true
+}
+
+// Fed records that problem is handed to the engines.
+func (c *Checkpoint) Fed(problem Problem) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.in_flight[positionKey(problem)]++
+	c.dirty = true
+}
+
+// Add records res as finished. Positions skipped at the end of the time of the run are left
+// pending to be solved on resume.
+func (c *Checkpoint) Add(res Result) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := positionKey(res.Problem)
+	if n, ok := c.in_flight[key]; ok {
+		if n > 1 {
+			c.in_flight[key]--
+		} else {
+			delete(c.in_flight, key)
+		}
+		c.dirty = true
+	}
+	if errors.Is(res.Err, errSkipped) {
+		return
+	}
+
+	record := checkpointRecord{
+		Pv:       res.Pv,
+		TimeMs:   res.Time.Milliseconds(),
+		Nodes:    res.Nodes,
+		Nps:      res.Nps,
+		Hashfull: res.Hashfull,
+		Score:    res.Score,
+		Surplus:  res.Surplus,
+	}
+	for _, cook := range res.Cooks {
+		record.Cooks = append(record.Cooks, jsonCook{Move: cook.Move, MateLen: cook.MateLen})
+	}
+	for _, interposition := range res.Interpositions {
+		record.Mudaai = append(record.Mudaai, jsonMudaai{Ply: interposition.Ply, Move: interposition.Move, Kind: interposition.Kind})
+	}
+	if res.CrossCheck != nil {
+		record.Cross = &jsonCrossCheck{Outcome: res.CrossCheck.Outcome, Pv: res.CrossCheck.Pv}
+	}
+	if res.Err != nil {
+		record.Category = res.Category()
+		record.Error = res.Err.Error()
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	c.writer.WriteString(key)
+	c.writer.WriteString("\t")
+	c.writer.Write(data)
+	c.writer.WriteString("\n")
+}
+
+func (c *Checkpoint) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dirty {
+		c.queue.InFlight = c.queue.InFlight[:0]
+		for key := range c.in_flight {
+			c.queue.InFlight = append(c.queue.InFlight, key)
+		}
+		sort.Strings(c.queue.InFlight)
+		if data, err := json.Marshal(c.queue); err == nil {
+			c.writer.WriteString(checkpointQueueKey)
+			c.writer.WriteString("\t")
+			c.writer.Write(data)
+			c.writer.WriteString("\n")
+		}
+		c.dirty = false
+	}
+	if err := c.writer.Flush(); err != nil {
+		return err
+	}
+
+	return c.file.Sync()
+}
+
+func (c *Checkpoint) Close() error {
+	close(c.done)
+	err := c.Flush()
+	if cerr := c.file.Close(); err == nil {
+		err = cerr
+	}
+	if c.previous != nil {
+		c.previous.Close()
+	}
+
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import "os"
+
+const (
+	colorNone    = ""
+	colorRed     = "\x1b[31m"
+	colorYellow  = "\x1b[33m"
+	colorMagenta = "\x1b[35m"
+	colorReset   = "\x1b[0m"
+)
+
+// useColor returns true if the output to the terminal should be colored. Colors are disabled
+// by --no-color, the NO_COLOR environment variable or stdout being redirected.
+func useColor(no_color bool) bool {
+	if no_color || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+
+	stat, err := os.Stdout.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// resultColor returns the color of the line of an unexpected result res.
+func resultColor(res Result) string {
+	switch {
+	case res.Err == nil:
+		return colorMagenta
+	case res.Category() == "timeout" || res.Category() == "time_limit":
+		return colorYellow
+	default:
+		return colorRed
+	}
+}
+
+func colorize(color string, text string) string {
+	if color == colorNone {
+		return text
+	}
+
+	return color + text + colorReset
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// splitCommand splits the engine command into its arguments like a shell, so that wrappers
+// such as "taskset -c 0 ./KomoringHeights" and engines taking arguments can be used. A
+// command naming an executable as a whole, e.g. a path containing spaces, is not split.
+func splitCommand(command string) ([]string, error) {
+	if _, err := exec.LookPath(command); err == nil {
+		return []string{command}, nil
+	}
+
+	var args []string
+	var arg strings.Builder
+	in_arg := false
+	var quote rune
+	escaped := false
+	for _, c := range command {
+		switch {
+		case escaped:
+			arg.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			in_arg = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(c)
+		case c == '"' || c == '\'':
+			quote = c
+			in_arg = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if in_arg {
+				args = append(args, arg.String())
+				arg.Reset()
+				in_arg = false
+			}
+		default:
+			arg.WriteRune(c)
+			in_arg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote in the engine command: %s", command)
+	}
+	if in_arg {
+		args = append(args, arg.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty engine command")
+	}
+
+	return args, nil
+}
+
+// quoteCommand joins args into a command which splitCommand splits into args again.
+func quoteCommand(args []string) string {
+	if len(args) == 1 {
+		return args[0]
+	}
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\") {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// absCommandPath returns the absolute path of the command path if it is a relative path such as
+// "./KomoringHeights", so that it can be started in another working directory.
+func absCommandPath(path string) (string, error) {
+	if filepath.IsAbs(path) || filepath.Base(path) == path {
+		return path, nil
+	}
+	return filepath.Abs(path)
+}
+
+// expandWorker replaces "{worker}" in s with the number of the worker.
+func expandWorker(s string, worker int) string {
+	return strings.ReplaceAll(s, "{worker}", strconv.Itoa(worker))
+}
+
+// workerCpus returns the n CPUs of the worker, assigning distinct CPUs to the workers in order
+// while there are enough of them.
+func workerCpus(worker int, n int, num_cpu int) []int {
+	cpus := make([]int, n)
+	for i := range cpus {
+		cpus[i] = (worker*n + i) % num_cpu
+	}
+	return cpus
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// solveAll solves problems with command on op.Process workers and returns the results in the
+// order of problems.
+func solveAll(command string, op Options, problems []Problem) []Result {
+	queue := newScheduler()
+	result_chan := make(chan Result)
+	monitor := newMonitor(op.Process)
+	var wg sync.WaitGroup
+	for i := 0; i < op.Process; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			solve(i, command, op, nil, monitor, nil, nil, queue, result_chan)
+		}(i)
+	}
+	go func() {
+		for _, problem := range problems {
+			queue.Feed(problem)
+		}
+		queue.Close()
+		wg.Wait()
+		close(result_chan)
+	}()
+
+	// the same position may be solved more than once with --no-dedup
+	results := make([]Result, len(problems))
+	pending := make(map[string][]int)
+	for i, problem := range problems {
+		key := problemLabel(problem)
+		pending[key] = append(pending[key], i)
+	}
+	for res := range result_chan {
+		key := problemLabel(res.Problem)
+		if indices := pending[key]; len(indices) > 0 {
+			results[indices[0]] = res
+			pending[key] = indices[1:]
+		}
+	}
+
+	return results
+}
+
+// EngineComparison is the results of the same problems solved by several engines.
+type EngineComparison struct {
+	commands []string
+	problems []Problem
+	// results[i][j] is the result of problems[j] solved by commands[i], the run with the
+	// median time if solved more than once
+	results [][]Result
+	// runs[i][k][j] is the result of problems[j] in the k-th run of commands[i]
+	runs [][][]Result
+}
+
+// compareEngines solves problems with each of commands in turn, with the options ops[i] for
+// commands[i]. Every problem is solved repeat times by each engine, alternating the engines
+// so that changes in the load of the machine affect them alike.
+func compareEngines(commands []string, ops []Options, problems []Problem, repeat int) *EngineComparison {
+	c := &EngineComparison{commands: commands, problems: problems}
+	c.runs = make([][][]Result, len(commands))
+	for k := 0; k < repeat; k++ {
+		for i, command := range commands {
+			slog.Info("solving with the engine", "engine", i+1, "command", command, "run", k+1)
+			start := time.Now()
+			c.runs[i] = append(c.runs[i], solveAll(command, ops[i], problems))
+			slog.Info("finished", "engine", i+1, "run", k+1, "time", time.Since(start).Round(time.Millisecond))
+		}
+	}
+
+	c.results = make([][]Result, len(commands))
+	for i := range commands {
+		c.results[i] = make([]Result, len(problems))
+		for j := range problems {
+			runs := make([]Result, repeat)
+			for k := range runs {
+				runs[k] = c.runs[i][k][j]
+			}
+			sort.Slice(runs, func(a, b int) bool { return runs[a].Time < runs[b].Time })
+			c.results[i][j] = runs[len(runs)/2]
+		}
+	}
+	return c
+}
+
+// agree returns true if all engines give the same outcome for problems[j], and the same
+// solution if it is a mate.
+func (c *EngineComparison) agree(j int) bool {
+	first := c.results[0][j]
+	for _, results := range c.results[1:] {
+		res := results[j]
+		if outcome(res) != outcome(first) {
+			return false
+		}
+		if res.Err == nil && !sameSolution(res.Problem, first.Pv, res.Pv) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareOutcome(res Result) string {
+	if res.Err != nil {
+		return res.Category()
+	}
+	return fmt.Sprintf("mate %d", len(res.Pv))
+}
+
+// String returns the side-by-side table of the results followed by the totals per engine.
+func (c *EngineComparison) String() string {
+	var sb strings.Builder
+	for i, command := range c.commands {
+		fmt.Fprintf(&sb, "#%d: %s\n", i+1, command)
+	}
+	sb.WriteString("\n")
+
+	width := len("position")
+	for _, problem := range c.problems {
+		if label := problemLabel(problem); len(label) > width {
+			width = len(label)
+		}
+	}
+	fmt.Fprintf(&sb, "%-*s", width, "position")
+	for i := range c.commands {
+		fmt.Fprintf(&sb, "  %18s  %9s  %10s", fmt.Sprintf("#%d", i+1), "time", "nodes")
+	}
+	sb.WriteString("  agree\n")
+
+	agreed := 0
+	for j, problem := range c.problems {
+		fmt.Fprintf(&sb, "%-*s", width, problemLabel(problem))
+		for i := range c.commands {
+			res := c.results[i][j]
+			fmt.Fprintf(&sb, "  %18s  %8.2fs  %10d", compareOutcome(res), res.Time.Seconds(), res.Nodes)
+		}
+		agree := "no"
+		if c.agree(j) {
+			agree = "yes"
+			agreed += 1
+		}
+		fmt.Fprintf(&sb, "  %s\n", agree)
+	}
+	sb.WriteString("\n")
+
+	for i := range c.commands {
+		solved := 0
+		var total time.Duration
+		var nodes int64
+		for _, res := range c.results[i] {
+			if res.Err == nil {
+				solved += 1
+			}
+			total += res.Time
+			nodes += res.Nodes
+		}
+		fmt.Fprintf(&sb, "#%d  solved/total: %d/%d  time: %.2fs  nodes: %d\n", i+1, solved, len(c.problems), total.Seconds(), nodes)
+	}
+	fmt.Fprintf(&sb, "agree: %d/%d\n", agreed, len(c.problems))
+
+	return sb.String()
+}
+
+// WriteCsv writes the table of the results in CSV, one row per position. If appending is set,
+// w already contains rows and the header is omitted.
+func (c *EngineComparison) WriteCsv(w io.Writer, appending bool) error {
+	writer := csv.NewWriter(w)
+	header := []string{"position"}
+	for i := range c.commands {
+		prefix := fmt.Sprintf("engine%d_", i+1)
+		header = append(header, prefix+"status", prefix+"mate_len", prefix+"time_ms", prefix+"nodes", prefix+"pv")
+	}
+	header = append(header, "agree")
+	if !appending {
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+	}
+
+	for j, problem := range c.problems {
+		record := []string{problem.String()}
+		for i := range c.commands {
+			res := c.results[i][j]
+			mate_len := ""
+			if res.Err == nil {
+				mate_len = strconv.Itoa(len(res.Pv))
+			}
+			record = append(record, res.Status(), mate_len, strconv.FormatInt(res.Time.Milliseconds(), 10),
+				strconv.FormatInt(res.Nodes, 10), strings.Join(res.Pv, " "))
+		}
+		record = append(record, strconv.FormatBool(c.agree(j)))
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeEngineComparison writes c in CSV if format is "csv", or the text report otherwise,
+// appending to path if appending is set.
+func writeEngineComparison(path string, format string, c *EngineComparison, report string, appending bool) error {
+	file, err := openBufferedFile(path, appending)
+	if err != nil {
+		return err
+	}
+
+	size, err := file.Size()
+	if err == nil && format == "csv" {
+		err = c.WriteCsv(file, size > 0)
+	} else if err == nil {
+		_, err = io.WriteString(file, report)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+)
+
+// Config is a configuration file in a subset of TOML. Its keys are the names of the flags
+// (with "-" or "_"), and "engine" and "inputs" give the solver command and the input files.
+// Tables named [profile.NAME] hold named profiles which override the top-level values:
+//
+//	engine = "./KomoringHeights-by-clang"
+//	hash = 1024
+//	option = ["Threads=4"]
+//
+//	[profile.fast]
+//	time-limit = 1000
+//
+//	[profile.regression]
+//	baseline = "nightly.json"
+//	inputs = ["problems/*.sfen"]
+type Config struct {
+	values   map[string][]string
+	profiles map[string]map[string][]string
+}
+
+// loadConfig reads the configuration file path.
+func loadConfig(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config := &Config{values: make(map[string][]string), profiles: make(map[string]map[string][]string)}
+	table := config.values
+	scanner := bufio.NewScanner(file)
+	for line_no := 1; scanner.Scan(); line_no++ {
+		line := strings.TrimSpace(stripConfigComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			name, ok := strings.CutSuffix(strings.TrimPrefix(line, "["), "]")
+			name = strings.TrimSpace(name)
+			profile, is_profile := strings.CutPrefix(name, "profile.")
+			if !ok || !is_profile || profile == "" {
+				return nil, fmt.Errorf("%s:%d: unknown table %q (expected [profile.NAME])", path, line_no, line)
+			}
+			if _, ok := config.profiles[profile]; ok {
+				return nil, fmt.Errorf("%s:%d: duplicate profile %q", path, line_no, profile)
+			}
+			table = make(map[string][]string)
+			config.profiles[profile] = table
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, line_no)
+		}
+		key = strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		values, err := parseConfigValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", path, line_no, key, err)
+		}
+		if key != "engine" && key != "inputs" && flag.Lookup(key) == nil {
+			return nil, fmt.Errorf("%s:%d: unknown option %q", path, line_no, key)
+		}
+		table[key] = values
+	}
+
+	return config, scanner.Err()
+}
+
+// stripConfigComment removes a comment starting with "#" outside of strings.
+func stripConfigComment(line string) string {
+	if i := indexOutsideStrings(line, '#'); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// indexOutsideStrings returns the index of the first c in s which is not quoted, or -1.
+func indexOutsideStrings(s string, c byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		case quote == 0 && (s[i] == '"' || s[i] == '\''):
+			quote = s[i]
+		case quote == 0 && s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
+
+// parseConfigValue parses a string, a number, a boolean or a one-line array of them into
+// the arguments of a flag.
+func parseConfigValue(value string) ([]string, error) {
+	if inner, ok := strings.CutPrefix(value, "["); ok {
+		inner, ok = strings.CutSuffix(inner, "]")
+		if !ok {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		var values []string
+		for _, element := range splitConfigArray(inner) {
+			if element = strings.TrimSpace(element); element == "" {
+				continue
+			}
+			scalar, err := parseConfigScalar(element)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, scalar)
+		}
+		return values, nil
+	}
+
+	scalar, err := parseConfigScalar(value)
+	if err != nil {
+		return nil, err
+	}
+	return []string{scalar}, nil
+}
+
+func splitConfigArray(inner string) []string {
+	var elements []string
+	for {
+		i := indexOutsideStrings(inner, ',')
+		if i < 0 {
+			return append(elements, inner)
+		}
+		elements = append(elements, inner[:i])
+		inner = inner[i+1:]
+	}
+}
+
+func parseConfigScalar(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "\""):
+		return strconv.Unquote(value)
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return value[1 : len(value)-1], nil
+	case value == "true" || value == "false":
+		return value, nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64); err != nil {
+		return "", fmt.Errorf("invalid value %s", value)
+	}
+	return strings.ReplaceAll(value, "_", ""), nil
+}
+
+// Apply sets the flags not given on the command line to the top-level values and then to the
+// values of profile, if any. It returns the engine command and the input files.
+func (c *Config) Apply(profile string) (engine string, inputs []string, err error) {
+	tables := []map[string][]string{c.values}
+	if profile != "" {
+		table, ok := c.profiles[profile]
+		if !ok {
+			names := make([]string, 0, len(c.profiles))
+			for name := range c.profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return "", nil, fmt.Errorf("unknown profile %q (available: %s)", profile, strings.Join(names, ", "))
+		}
+		tables = append(tables, table)
+	}
+
+	values := make(map[string][]string)
+	for _, table := range tables {
+		for key, value := range table {
+			values[key] = value
+		}
+	}
+
+	for key, value := range values {
+		switch key {
+		case "engine":
+			if len(value) != 1 {
+				return "", nil, fmt.Errorf("engine must be a string")
+			}
+			engine = value[0]
+			continue
+		case "inputs":
+			inputs = value
+			continue
+		}
+
+		if flag.Lookup(key).Changed {
+			continue
+		}
+		for _, v := range value {
+			if err := flag.Set(key, v); err != nil {
+				return "", nil, fmt.Errorf("%s: %v", key, err)
+			}
+		}
+	}
+
+	return engine, inputs, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Cook is a first move of the attacker other than the solution which also leads to a mate
+// within the length of the solution (余詰).
+type Cook struct {
+	Move    string
+	MateLen int
+}
+
+func (c Cook) String() string {
+	return fmt.Sprintf("%s:%d", c.Move, c.MateLen)
+}
+
+func formatCooks(cooks []Cook) string {
+	strs := make([]string, len(cooks))
+	for i, cook := range cooks {
+		strs[i] = cook.String()
+	}
+	return strings.Join(strs, " ")
+}
+
+func mirrorCooks(cooks []Cook) []Cook {
+	var mirrored []Cook
+	for _, cook := range cooks {
+		mirrored = append(mirrored, Cook{Move: mirrorMove(cook.Move), MateLen: cook.MateLen})
+	}
+	return mirrored
+}
+
+// FindCooks searches for cooks of the solution pv of problem. Each check other than the first
+// move of pv is played with "position ... moves", and the engine is asked whether the defender
+// is mated, searching for at most time_limit_ms. Checks whose search times out are not cooks.
+func (ep *EngineProcess) FindCooks(problem Problem, pv []string, time_limit_ms int) ([]Cook, error) {
+	pos, err := problemPosition(problem)
+	if err != nil {
+		return nil, err
+	}
+
+	// the root of each search is the defender to move after a check
+	fmt.Fprintln(ep.stdin, "setoption name RootIsAndNodeIfChecked value true")
+	defer ep.RestoreOption("RootIsAndNodeIfChecked")
+
+	var cooks []Cook
+	for _, m := range pos.LegalMoves() {
+		usi := m.String()
+		if usi == pv[0] {
+			continue
+		}
+		next := *pos
+		next.Do(m)
+		if !next.InCheck() {
+			continue
+		}
+		if next.IsCheckmate() {
+			cooks = append(cooks, Cook{Move: usi, MateLen: 1})
+			continue
+		}
+		if len(pv) < 3 {
+			continue
+		}
+
+		alternative := problem
+		alternative.Moves = append(append([]string(nil), problem.Moves...), usi)
+		res := ep.Solve(alternative, time_limit_ms)
+		switch {
+		case res.Err == nil:
+			if mate_len := 1 + len(res.Pv); mate_len <= len(pv) {
+				cooks = append(cooks, Cook{Move: usi, MateLen: mate_len})
+			}
+		case errors.Is(res.Err, errAborted), res.Category() == "engine_error":
+			return cooks, res.Err
+		}
+	}
+
+	return cooks, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// CrossCheck is the answer of the verification engine which disagrees with a result.
+type CrossCheck struct {
+	Outcome string
+	Pv      []string
+}
+
+func (c *CrossCheck) String() string {
+	if c == nil {
+		return ""
+	}
+	if len(c.Pv) == 0 {
+		return c.Outcome
+	}
+	return c.Outcome + " " + strings.Join(c.Pv, " ")
+}
+
+// definite returns true if res is an answer which can be compared between engines, i.e. a
+// verified mate or nomate.
+func definite(res Result) bool {
+	return res.Err == nil || errors.Is(res.Err, errNoMate)
+}
+
+// crossCheck solves the problem of res with the verification engine ep and returns its answer
+// if it disagrees with res. Results which are not definite on either side are not compared.
+func (ep *EngineProcess) crossCheck(res Result, time_limit_ms int) (*CrossCheck, error) {
+	if !definite(res) {
+		return nil, nil
+	}
+
+	other := ep.Solve(res.Problem, time_limit_ms)
+	other.Problem = res.Problem
+	other = verifyResult(other)
+	switch {
+	case errors.Is(other.Err, errAborted), other.Category() == "engine_error":
+		return nil, other.Err
+	case !definite(other) || outcome(other) == outcome(res):
+		return nil, nil
+	}
+
+	return &CrossCheck{Outcome: outcome(other), Pv: other.Pv}, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+)
+
+var csaPieces = map[string]shogi.PieceType{
+	"OU": shogi.King, "HI": shogi.Rook, "KA": shogi.Bishop, "KI": shogi.Gold, "GI": shogi.Silver,
+	"KE": shogi.Knight, "KY": shogi.Lance, "FU": shogi.Pawn, "RY": shogi.Dragon, "UM": shogi.Horse,
+	"NG": shogi.ProSilver, "NK": shogi.ProKnight, "NY": shogi.ProLance, "TO": shogi.ProPawn,
+}
+
+type csaBoard struct {
+	diagram
+	has_position bool
+}
+
+func newCsaBoard() *csaBoard {
+	return &csaBoard{diagram: newDiagram()}
+}
+
+func csaPiece(color byte, code string) (shogi.Piece, error) {
+	t, ok := csaPieces[code]
+	if !ok {
+		return shogi.NoPiece, fmt.Errorf("invalid piece: %s", code)
+	}
+
+	return shogi.Piece{Type: t, Color: csaColorOf(color)}, nil
+}
+
+func csaSquare(file byte, rank byte) (shogi.Square, error) {
+	if file < '1' || file > '9' || rank < '1' || rank > '9' {
+		return shogi.NoSquare, fmt.Errorf("invalid square: %c%c", file, rank)
+	}
+
+	return shogi.NewSquare(int(file-'0'), int(rank-'0')), nil
+}
+
+func (b *csaBoard) setHirate(line string) error {
+	start, err := shogi.ParseSfen(shogi.StartSfen)
+	if err != nil {
+		return err
+	}
+	for sq := shogi.Square(0); sq < 81; sq++ {
+		b.position.SetPiece(sq, start.Piece(sq))
+	}
+
+	removals := line[2:]
+	for len(removals) >= 4 {
+		sq, err := csaSquare(removals[0], removals[1])
+		if err != nil {
+			return err
+		}
+		b.position.SetPiece(sq, shogi.NoPiece)
+		removals = removals[4:]
+	}
+	b.has_position = true
+
+	return nil
+}
+
+func (b *csaBoard) parseRow(line string) error {
+	rank := int(line[1] - '0')
+	cells := line[2:]
+	if len(cells) < 27 {
+		cells += strings.Repeat(" ", 27-len(cells))
+	}
+	for col := 0; col < 9; col++ {
+		if len(cells) < 3 {
+			return fmt.Errorf("a board row must have 9 squares: %s", line)
+		}
+		cell := cells[:3]
+		cells = cells[3:]
+		if strings.TrimSpace(cell) == "*" {
+			continue
+		}
+
+		piece, err := csaPiece(cell[0], cell[1:])
+		if err != nil {
+			return err
+		}
+		b.position.SetPiece(shogi.NewSquare(9-col, rank), piece)
+	}
+	b.has_position = true
+
+	return nil
+}
+
+func (b *csaBoard) parsePieces(line string) error {
+	color := line[1]
+	pieces := line[2:]
+	for len(pieces) >= 4 {
+		square, code := pieces[:2], pieces[2:4]
+		pieces = pieces[4:]
+
+		if square == "00" {
+			if code == "AL" {
+				if color == '+' {
+					return fmt.Errorf("only the defender can hold the rest pieces: %s", line)
+				}
+				b.gote_rest = true
+				continue
+			}
+			piece, err := csaPiece(color, code)
+			if err != nil {
+				return err
+			}
+			if piece.Type == shogi.King {
+				return fmt.Errorf("invalid hand piece: %s", code)
+			}
+			b.addHand(piece.Color, piece.Type.Unpromoted(), 1)
+			continue
+		}
+
+		sq, err := csaSquare(square[0], square[1])
+		if err != nil {
+			return err
+		}
+		piece, err := csaPiece(color, code)
+		if err != nil {
+			return err
+		}
+		b.position.SetPiece(sq, piece)
+	}
+	b.has_position = true
+
+	return nil
+}
+
+func csaColorOf(color byte) shogi.Color {
+	if color == '-' {
+		return shogi.White
+	}
+
+	return shogi.Black
+}
+
+// scanCsa reads CSA records from r and passes their initial positions to emit.
+// Multiple records separated by "/" lines are supported.
+func scanCsa(r io.Reader, emit func(Problem)) error {
+	scanner := bufio.NewScanner(r)
+	line_no := 0
+	board := newCsaBoard()
+	in_moves := false
+
+	flush := func() error {
+		if board.has_position {
+			sfen, err := board.Sfen()
+			if err != nil {
+				return err
+			}
+			emit(Problem{Sfen: sfen})
+		}
+		board = newCsaBoard()
+		in_moves = false
+		return nil
+	}
+
+	for scanner.Scan() {
+		line_no++
+		for _, stmt := range strings.Split(strings.TrimRight(scanner.Text(), "\r"), ",") {
+			line := strings.TrimPrefix(stmt, "\ufeff")
+			if line == "" || line[0] == '\'' || (in_moves && line != "/") {
+				continue
+			}
+
+			var err error
+			switch {
+			case line == "/":
+				err = flush()
+			case line == "+" || line == "-":
+				board.position.SetSideToMove(csaColorOf(line[0]))
+				in_moves = true
+			case strings.HasPrefix(line, "PI"):
+				err = board.setHirate(line)
+			case len(line) >= 2 && line[0] == 'P' && line[1] >= '1' && line[1] <= '9':
+				err = board.parseRow(line)
+			case strings.HasPrefix(line, "P+") || strings.HasPrefix(line, "P-"):
+				err = board.parsePieces(line)
+			}
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line_no, err)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return fmt.Errorf("line %d: %v", line_no, err)
+	}
+
+	return nil
+}
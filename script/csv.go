@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// scanCsvProblems reads problems from CSV records. Each record consists of an SFEN,
+// the expected mate length (or "nomate") and the expected first move. If the first record is a header
+// (e.g. "sfen,mate_len,first_move,id,tags"), columns are matched by name instead. Tags are
+// separated by ';' or spaces.
+func scanCsvProblems(r io.Reader, emit func(Problem)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+
+	columns := map[string]int{"sfen": 0, "mate_len": 1, "first_move": 2}
+	first := true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line_no, _ := reader.FieldPos(0)
+
+		if first {
+			first = false
+			if len(record) > 0 && strings.EqualFold(strings.TrimSpace(record[0]), "sfen") {
+				columns = make(map[string]int)
+				for i, name := range record {
+					columns[strings.ToLower(strings.TrimSpace(name))] = i
+				}
+				continue
+			}
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		problem, err := parseProblem(field("sfen"))
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line_no, err)
+		}
+		if mate_len := field("mate_len"); mate_len == "nomate" {
+			problem.NoMate = true
+		} else if mate_len != "" {
+			problem.MateLen, err = strconv.Atoi(mate_len)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid mate length: %s", line_no, mate_len)
+			}
+		}
+		problem.FirstMove = field("first_move")
+		problem.ID = field("id")
+		problem.Tags = strings.FieldsFunc(field("tags"), func(r rune) bool { return r == ';' || r == ' ' })
+		emit(problem)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// problemDBSchema is the schema of problem databases. Each row of "problems" is a problem
+// to solve, where "sfen" may be either an SFEN or a "position ... moves ..." line, and
+// "mate_len" and "first_move" are optional expected answers. The outcome of the latest run
+// of each problem is written back into "results", and problems which already have a
+// "solved" result are skipped so that a large corpus can be solved across multiple runs.
+const problemDBSchema = `
+CREATE TABLE IF NOT EXISTS problems (
+	id         INTEGER PRIMARY KEY,
+	sfen       TEXT NOT NULL,
+	mate_len   INTEGER,
+	first_move TEXT
+);
+CREATE TABLE IF NOT EXISTS results (
+	problem_id INTEGER PRIMARY KEY REFERENCES problems(id),
+	status     TEXT NOT NULL,
+	error      TEXT,
+	mate_len   INTEGER,
+	pv         TEXT,
+	time_ms    INTEGER NOT NULL,
+	nodes      INTEGER,
+	nps        INTEGER,
+	hashfull   INTEGER,
+	updated_at TEXT NOT NULL
+);
+`
+
+// metricColumns are added to tables created before the search statistics were recorded.
+var metricColumns = [][2]string{{"nodes", "INTEGER"}, {"nps", "INTEGER"}, {"hashfull", "INTEGER"}}
+
+// addMissingColumns adds columns which table does not have yet.
+func addMissingColumns(db *sql.DB, table string, columns [][2]string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var cid, not_null, pk int
+		var name, typ string
+		var default_value sql.NullString
+		if err := rows.Scan(&cid, &name, &typ, &not_null, &default_value, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		if existing[column[0]] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column[0], column[1])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isProblemDB(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".sqlite", ".sqlite3", ".db":
+		return true
+	default:
+		return false
+	}
+}
+
+func openProblemDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(problemDBSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := addMissingColumns(db, "results", metricColumns); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func scanProblemDB(path string, done <-chan struct{}, emit func(Problem)) error {
+	db, err := openProblemDB(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT p.id, p.sfen, p.mate_len, p.first_move FROM problems p
+		LEFT JOIN results r ON r.problem_id = p.id
+		WHERE r.status IS NULL OR r.status != 'solved'
+		ORDER BY p.id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		select {
+		case <-done:
+			return errStopped
+		default:
+		}
+
+		var id int64
+		var sfen string
+		var mate_len sql.NullInt64
+		var first_move sql.NullString
+		if err := rows.Scan(&id, &sfen, &mate_len, &first_move); err != nil {
+			return err
+		}
+
+		problem, err := parseProblem(sfen)
+		if err != nil {
+			return fmt.Errorf("id %d: %v", id, err)
+		}
+		problem.ID = fmt.Sprint(id)
+		problem.MateLen = int(mate_len.Int64)
+		problem.FirstMove = first_move.String
+		problem.db_path = path
+		problem.db_id = id
+		emit(problem)
+	}
+
+	return rows.Err()
+}
+
+type ProblemDBWriter struct {
+	dbs map[string]*sql.DB
+}
+
+func newProblemDBWriter() *ProblemDBWriter {
+	return &ProblemDBWriter{dbs: make(map[string]*sql.DB)}
+}
+
+func (w *ProblemDBWriter) Store(res Result) error {
+	problem := res.Problem
+	if problem.db_path == "" {
+		return nil
+	}
+
+	db, ok := w.dbs[problem.db_path]
+	if !ok {
+		var err error
+		db, err = openProblemDB(problem.db_path)
+		if err != nil {
+			return err
+		}
+		w.dbs[problem.db_path] = db
+	}
+
+	var err_text, pv sql.NullString
+	var mate_len sql.NullInt64
+	if res.Err != nil {
+		err_text = sql.NullString{String: res.Err.Error(), Valid: true}
+	} else {
+		pv = sql.NullString{String: strings.Join(res.Pv, " "), Valid: true}
+		mate_len = sql.NullInt64{Int64: int64(len(res.Pv)), Valid: true}
+	}
+
+	_, err := db.Exec(`
+		INSERT OR REPLACE INTO results
+			(problem_id, status, error, mate_len, pv, time_ms, nodes, nps, hashfull, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		problem.db_id, res.Status(), err_text, mate_len, pv, res.Time.Milliseconds(),
+		res.Nodes, res.Nps, res.Hashfull, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("%s: %v", problem.db_path, err)
+	}
+
+	return nil
+}
+
+func (w *ProblemDBWriter) Close() {
+	for _, db := range w.dbs {
+		db.Close()
+	}
+}
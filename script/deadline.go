@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// parseDeadline parses the time given by --deadline: RFC 3339, "2006-01-02 15:04" in the local
+// time zone, or "15:04" for the next such time from now.
+func parseDeadline(text string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", text, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("15:04", text, time.Local); err == nil {
+		at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, time.Local)
+		if !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid deadline %q (expected RFC 3339, \"YYYY-MM-DD hh:mm\" or \"hh:mm\")", text)
+}
+
+// Deadline shares the time left until a deadline among the positions left, so that the run
+// finishes by then with as many positions solved as possible.
+type Deadline struct {
+	at      time.Time
+	workers int
+
+	mu        sync.Mutex
+	remaining int
+}
+
+func newDeadline(at time.Time, workers int, positions int) *Deadline {
+	return &Deadline{at: at, workers: workers, remaining: positions}
+}
+
+// Take returns the time limit (ms) of the next position: its share of the time left, or
+// time_limit_ms if it is shorter.
+func (d *Deadline) Take(time_limit_ms int) int {
+	d.mu.Lock()
+	left := d.remaining
+	if d.remaining > 1 {
+		d.remaining--
+	}
+	d.mu.Unlock()
+
+	// each worker solves left/workers positions of the rest on average
+	share := time.Until(d.at) * time.Duration(d.workers) / time.Duration(max(left, d.workers))
+	share_ms := max(int(share.Milliseconds()), 1)
+	if time_limit_ms > 0 && time_limit_ms < share_ms {
+		return time_limit_ms
+	}
+	return share_ms
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+)
+
+// normalizeSfen returns the canonical form of sfen, i.e. hand pieces in the standard order
+// and the move counter reset to 1.
+func normalizeSfen(sfen string) (string, error) {
+	pos, err := shogi.ParseSfen(sfen)
+	if err != nil {
+		return "", err
+	}
+
+	return sfenWithoutPly(pos) + " 1", nil
+}
+
+func mirrorSquare(file byte) byte {
+	if file >= '1' && file <= '9' {
+		return '9' - file + '1'
+	}
+
+	return file
+}
+
+// mirrorMove returns the left-right mirror image of a USI move.
+func mirrorMove(move string) string {
+	b := []byte(move)
+	if len(b) >= 4 {
+		if b[1] != '*' {
+			b[0] = mirrorSquare(b[0])
+		}
+		b[2] = mirrorSquare(b[2])
+	}
+
+	return string(b)
+}
+
+func mirrorMoves(moves []string) []string {
+	mirrored := make([]string, len(moves))
+	for i, move := range moves {
+		mirrored[i] = mirrorMove(move)
+	}
+
+	return mirrored
+}
+
+func mirrorSfen(sfen string) (string, error) {
+	pos, err := shogi.ParseSfen(sfen)
+	if err != nil {
+		return "", err
+	}
+	mirrored := *pos
+	for sq := shogi.Square(0); sq < 81; sq++ {
+		mirrored.SetPiece(shogi.NewSquare(10-sq.File(), sq.Rank()), pos.Piece(sq))
+	}
+
+	return mirrored.Sfen(), nil
+}
+
+func problemKey(sfen string, moves []string) string {
+	if len(moves) == 0 {
+		return sfen
+	}
+
+	return sfen + " moves " + strings.Join(moves, " ")
+}
+
+// streamResolved is the number of results kept with --stream to resolve the mirrored aliases
+// added after their original problems.
+const streamResolved = 1 << 16
+
+// dedupKey is the 128-bit hash of the key of a problem (see problemKey), which is kept instead
+// of the key so that the memory per problem is small and fixed for huge streamed inputs.
+type dedupKey [16]byte
+
+func hashProblemKey(key string) dedupKey {
+	hash := fnv.New128a()
+	hash.Write([]byte(key))
+	var k dedupKey
+	hash.Sum(k[:0])
+	return k
+}
+
+// Deduplicator filters out problems which are identical to (or the left-right mirror image of)
+// a problem which has already been added. Mirrored problems are kept as aliases of the
+// original one and are resolved with its result.
+//
+// If the number of results kept is limited, the results resolved the longest ago are forgotten
+// by Flush, and the mirror images of their problems are solved as new problems.
+type Deduplicator struct {
+	mu sync.Mutex
+	// seen is true for the problems whose results are forgotten
+	seen     map[dedupKey]bool
+	aliases  map[dedupKey][]Problem
+	resolved map[dedupKey]Result
+	// order is the keys of resolved in the order of resolution if limit > 0
+	order    []dedupKey
+	limit    int
+	removed  int
+	mirrored int
+}
+
+// newDeduplicator returns a Deduplicator keeping at most limit results (0: no limit).
+func newDeduplicator(limit int) *Deduplicator {
+	return &Deduplicator{
+		seen:     make(map[dedupKey]bool),
+		aliases:  make(map[dedupKey][]Problem),
+		resolved: make(map[dedupKey]Result),
+		limit:    limit,
+	}
+}
+
+// Add reports whether problem is seen for the first time. Problems whose SFEN cannot be
+// normalized are never regarded as duplicates.
+func (d *Deduplicator) Add(problem Problem) bool {
+	sfen, err := normalizeSfen(problem.Sfen)
+	if err != nil {
+		return true
+	}
+	key := hashProblemKey(problemKey(sfen, problem.Moves))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.seen[key]; ok {
+		d.removed++
+		return false
+	}
+
+	if mirror_sfen, err := mirrorSfen(sfen); err == nil {
+		mirror_key := hashProblemKey(problemKey(mirror_sfen, mirrorMoves(problem.Moves)))
+		if forgotten, ok := d.seen[mirror_key]; ok && !forgotten && mirror_key != key {
+			d.aliases[mirror_key] = append(d.aliases[mirror_key], problem)
+			d.mirrored++
+			return false
+		}
+	}
+	d.seen[key] = false
+
+	return true
+}
+
+// AliasCount returns the number of mirrored aliases of problem.
+func (d *Deduplicator) AliasCount(problem Problem) int {
+	sfen, err := normalizeSfen(problem.Sfen)
+	if err != nil {
+		return 0
+	}
+	key := hashProblemKey(problemKey(sfen, problem.Moves))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.aliases[key])
+}
+
+func mirrorResult(res Result, alias Problem) Result {
+	mirrored := res
+	mirrored.Problem = alias
+	mirrored.Pv = mirrorMoves(res.Pv)
+	mirrored.Cooks = mirrorCooks(res.Cooks)
+	mirrored.Interpositions = mirrorInterpositions(res.Interpositions)
+	if res.CrossCheck != nil {
+		mirrored.CrossCheck = &CrossCheck{Outcome: res.CrossCheck.Outcome, Pv: mirrorMoves(res.CrossCheck.Pv)}
+	}
+	mirrored.MirrorOf = &res.Problem
+
+	return mirrored
+}
+
+// Resolve records the result of an original problem and returns the results of its
+// mirrored aliases added so far.
+func (d *Deduplicator) Resolve(res Result) []Result {
+	sfen, err := normalizeSfen(res.Problem.Sfen)
+	if err != nil {
+		return nil
+	}
+	key := hashProblemKey(problemKey(sfen, res.Problem.Moves))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.resolved[key] = res
+	if d.limit > 0 {
+		d.order = append(d.order, key)
+	}
+
+	var results []Result
+	for _, alias := range d.aliases[key] {
+		results = append(results, mirrorResult(res, alias))
+	}
+	delete(d.aliases, key)
+
+	return results
+}
+
+// Flush returns the results of mirrored aliases added after their original problems were
+// resolved, and then forgets the results over the limit.
+func (d *Deduplicator) Flush() []Result {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var results []Result
+	for key, aliases := range d.aliases {
+		res, ok := d.resolved[key]
+		if !ok {
+			continue
+		}
+		for _, alias := range aliases {
+			results = append(results, mirrorResult(res, alias))
+		}
+		delete(d.aliases, key)
+	}
+	for d.limit > 0 && len(d.order) > d.limit {
+		key := d.order[0]
+		d.order = d.order[1:]
+		delete(d.resolved, key)
+		d.seen[key] = true
+	}
+
+	return results
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+)
+
+var errNonOptimalDefense = errors.New("non-optimal defense")
+
+// CheckDefense looks for defender moves in pv which shorten the mate, i.e. the suicidal moves
+// like 3九玉 of the Ambergris report. At most max_moves other legal moves of the defender are
+// played at each ply, moves of pieces on the board before drops, and the engine is asked for the
+// mate length after each, searching for at most time_limit_ms. If the defender escapes, or
+// survives at least margin plies longer with another move, errNonOptimalDefense is returned. The
+// margin absorbs the mates found after the alternatives which are longer than the shortest ones.
+// Moves whose search does not finish are skipped.
+func (ep *EngineProcess) CheckDefense(problem Problem, pv []string, time_limit_ms int, margin int, max_moves int) error {
+	pos, err := problemPosition(problem)
+	if err != nil {
+		return err
+	}
+
+	for i, usi := range pv {
+		m, err := pos.ParseMove(usi)
+		if err != nil {
+			return err
+		}
+		if i%2 == 0 {
+			pos.Do(m)
+			continue
+		}
+
+		// the mate length after the defender move of pv, including the move itself
+		remaining := len(pv) - i
+		var alternatives, drops []shogi.Move
+		for _, alternative := range pos.LegalMoves() {
+			switch {
+			case alternative.String() == usi:
+			case alternative.IsDrop():
+				drops = append(drops, alternative)
+			default:
+				alternatives = append(alternatives, alternative)
+			}
+		}
+		alternatives = append(alternatives, drops...)
+		if len(alternatives) > max_moves {
+			alternatives = alternatives[:max_moves]
+		}
+		for _, alternative := range alternatives {
+			position := problem
+			position.Moves = append(append(append([]string(nil), problem.Moves...), pv[:i]...), alternative.String())
+			res := ep.Solve(position, time_limit_ms)
+			switch {
+			case res.Err == nil:
+				if 1+len(res.Pv) >= remaining+margin {
+					return fmt.Errorf("%w: move %d: %s shortens the mate to %d, %s holds for %d", errNonOptimalDefense,
+						i+1, usi, remaining, alternative, 1+len(res.Pv))
+				}
+			case errors.Is(res.Err, errNoMate):
+				return fmt.Errorf("%w: move %d: %s allows the mate, %s escapes", errNonOptimalDefense, i+1, usi, alternative)
+			case errors.Is(res.Err, errAborted), res.Category() == "engine_error":
+				return res.Err
+			}
+		}
+		pos.Do(m)
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// EngineOption is a USI option passed to the engine with "setoption".
+type EngineOption struct {
+	Name  string
+	Value string
+}
+
+// defaultEngineOptions are set before the options given with --option, which override them,
+// if the engine declares them.
+var defaultEngineOptions = []EngineOption{
+	{Name: "RootIsAndNodeIfChecked", Value: "false"},
+	{Name: "PvInterval", Value: "0"},
+	{Name: "YozumePrintLevel", Value: "0"},
+}
+
+// optionFlags are the options which have their own flags.
+var optionFlags = map[string]string{
+	"USI_Hash":        "--hash",
+	"PostSearchCount": "--post-search-count",
+	"DepthLimit":      "--mate-limit",
+	"NodesLimit":      "--nodes-limit",
+}
+
+// parseEngineOptions parses the arguments of --option such as "Threads=4".
+func parseEngineOptions(args []string) ([]EngineOption, error) {
+	var options []EngineOption
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid option %q (expected Name=Value)", arg)
+		}
+		if flag, ok := optionFlags[name]; ok {
+			return nil, fmt.Errorf("use %s to set %s", flag, name)
+		}
+		options = append(options, EngineOption{Name: name, Value: strings.TrimSpace(value)})
+	}
+	return options, nil
+}
+
+// hangOptions returns options with PvInterval set to a quarter of hang_timeout seconds unless
+// given, since the engine prints nothing while searching with PvInterval=0 and the hang detector
+// relies on its periodic info lines. A PvInterval of 0 or longer than the timeout is an error.
+func hangOptions(options []EngineOption, hang_timeout int) ([]EngineOption, error) {
+	for _, option := range options {
+		if option.Name != "PvInterval" {
+			continue
+		}
+		pv_interval, err := strconv.Atoi(option.Value)
+		if err != nil || pv_interval <= 0 || pv_interval >= hang_timeout*1000 {
+			return nil, fmt.Errorf("--hang-timeout %d requires PvInterval between 1 and %d ms so that the engine prints info lines while searching", hang_timeout, hang_timeout*1000-1)
+		}
+		return options, nil
+	}
+	return append(options, EngineOption{Name: "PvInterval", Value: strconv.Itoa(hang_timeout * 1000 / 4)}), nil
+}
+
+// engineOptions returns the defaultEngineOptions in declared overridden and followed by options.
+// declared is the names of the options declared by the engine.
+func engineOptions(options []EngineOption, declared []string) []EngineOption {
+	var merged []EngineOption
+	for _, option := range defaultEngineOptions {
+		for _, name := range declared {
+			if name == option.Name {
+				merged = append(merged, option)
+				break
+			}
+		}
+	}
+	for _, option := range options {
+		overridden := false
+		for i := range merged {
+			if merged[i].Name == option.Name {
+				merged[i].Value = option.Value
+				overridden = true
+			}
+		}
+		if !overridden {
+			merged = append(merged, option)
+		}
+	}
+	return merged
+}
+
+// checkEngineOptions returns the problems of the options which are not declared by the
+// engine, suggesting declared options with similar names. The default options are not
+// checked since engines other than KomoringHeights may not have them.
+func checkEngineOptions(declared []string, options []EngineOption) []string {
+	if len(declared) == 0 {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	for _, name := range declared {
+		known[name] = true
+	}
+	names := []string{"USI_Hash", "PostSearchCount", "DepthLimit"}
+	for _, option := range options {
+		names = append(names, option.Name)
+	}
+
+	var problems []string
+	for _, name := range names {
+		if known[name] {
+			continue
+		}
+		problem := fmt.Sprintf("the engine does not declare the option %q", name)
+		if suggestion := closestOption(name, declared); suggestion != "" {
+			problem += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+		problems = append(problems, problem)
+	}
+	return problems
+}
+
+// closestOption returns the name in declared most similar to name, or "" if none is close.
+func closestOption(name string, declared []string) string {
+	best, best_distance := "", len(name)/3+1
+	for _, candidate := range declared {
+		distance := editDistance(strings.ToLower(name), strings.ToLower(candidate))
+		if distance < best_distance {
+			best, best_distance = candidate, distance
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = prev[j-1] + cost
+			if prev[j]+1 < curr[j] {
+				curr[j] = prev[j] + 1
+			}
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// expandWorkerOptions replaces "{worker}" in the values of options with the number of the
+// worker, e.g. to give each engine its own log file.
+func expandWorkerOptions(options []EngineOption, worker int) []EngineOption {
+	expanded := make([]EngineOption, len(options))
+	for i, option := range options {
+		expanded[i] = EngineOption{Name: option.Name, Value: expandWorker(option.Value, worker)}
+	}
+	return expanded
+}
+
+// parseNewGame parses the argument of --new-game into the number of positions between each
+// usinewgame, where 0 means never.
+func parseNewGame(arg string) (int, error) {
+	switch arg {
+	case "always":
+		return 1, nil
+	case "never":
+		return 0, nil
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid --new-game %q (expected always, never or a positive number)", arg)
+	}
+	return n, nil
+}
+
+// resolveHashSize returns the hash size in MB of each engine given by --hash. For "auto", the
+// fraction of the physical memory is divided among the processes. Otherwise, it warns if the
+// hash of all processes exceeds the physical memory.
+func resolveHashSize(arg string, process int, fraction float64) (int, error) {
+	total, total_err := totalMemory()
+	if arg != "auto" {
+		hash_size, err := strconv.Atoi(arg)
+		if err != nil || hash_size < 1 {
+			return 0, fmt.Errorf("invalid --hash %q (expected a size in MB or auto)", arg)
+		}
+		if total_err == nil && int64(hash_size)*int64(process)<<20 > total {
+			slog.Warn("the hash of all processes exceeds the physical memory",
+				"hash_mb", hash_size, "process", process, "memory_mb", total>>20)
+		}
+		return hash_size, nil
+	}
+
+	if total_err != nil {
+		return 0, fmt.Errorf("--hash auto: failed to get the physical memory: %v", total_err)
+	}
+	if fraction <= 0 || fraction > 1 {
+		return 0, fmt.Errorf("invalid --hash-fraction %v (expected 0 < fraction <= 1)", fraction)
+	}
+	hash_size := int(float64(total>>20) * fraction / float64(process))
+	if hash_size < 1 {
+		hash_size = 1
+	}
+	slog.Info("hash size", "hash_mb", hash_size, "process", process, "memory_mb", total>>20)
+	return hash_size, nil
+}
+
+// resolveProcess returns the number of processes given by --process. For "auto", it is the
+// number of physical cores minus one for the harness, divided by the Threads option if given.
+func resolveProcess(arg string, options []EngineOption) (int, error) {
+	if arg != "auto" {
+		process, err := strconv.Atoi(arg)
+		if err != nil || process < 1 {
+			return 0, fmt.Errorf("invalid --process %q (expected a positive number or auto)", arg)
+		}
+		return process, nil
+	}
+
+	cores, err := physicalCores()
+	if err != nil {
+		slog.Debug("failed to count the physical cores", "error", err)
+		cores = runtime.NumCPU()
+	}
+	threads := 1
+	for _, option := range options {
+		if option.Name != "Threads" {
+			continue
+		}
+		if n, err := strconv.Atoi(option.Value); err == nil && n > 0 {
+			threads = n
+		}
+	}
+	process := (cores - 1) / threads
+	if process < 1 {
+		process = 1
+	}
+	slog.Info("number of processes", "process", process, "cores", cores, "threads", threads)
+	return process, nil
+}
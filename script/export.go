@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+const (
+	zenkakuDigits = "１２３４５６７８９"
+	kanjiDigits   = "一二三四五六七八九"
+)
+
+var kifPieceNames = map[shogi.PieceType]string{
+	shogi.King: "玉", shogi.Rook: "飛", shogi.Bishop: "角", shogi.Gold: "金", shogi.Silver: "銀",
+	shogi.Knight: "桂", shogi.Lance: "香", shogi.Pawn: "歩", shogi.Dragon: "龍", shogi.Horse: "馬",
+	shogi.ProSilver: "成銀", shogi.ProKnight: "成桂", shogi.ProLance: "成香", shogi.ProPawn: "と",
+}
+
+var bodSquareNames = map[shogi.PieceType]string{
+	shogi.King: "玉", shogi.Rook: "飛", shogi.Bishop: "角", shogi.Gold: "金", shogi.Silver: "銀",
+	shogi.Knight: "桂", shogi.Lance: "香", shogi.Pawn: "歩", shogi.Dragon: "龍", shogi.Horse: "馬",
+	shogi.ProSilver: "全", shogi.ProKnight: "圭", shogi.ProLance: "杏", shogi.ProPawn: "と",
+}
+
+func nthRune(s string, n int) string {
+	return string([]rune(s)[n])
+}
+
+func formatKanjiNumber(n int) string {
+	switch {
+	case n < 10:
+		return nthRune(kanjiDigits, n-1)
+	case n == 10:
+		return "十"
+	default:
+		return "十" + nthRune(kanjiDigits, n%10-1)
+	}
+}
+
+// solutionFileName returns the file name for the solution of problem, which is derived from
+// its ID, or the hash of its position if it has no ID.
+func solutionFileName(problem Problem, ext string) string {
+	if problem.ID != "" {
+		name := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+				return '_'
+			}
+			return r
+		}, problem.ID)
+		return name + ext
+	}
+
+	hash := sha256.Sum256([]byte(problemKey(problem.Sfen, problem.Moves)))
+	return hex.EncodeToString(hash[:8]) + ext
+}
+
+func formatBodHand(label string, pos *shogi.Position, color shogi.Color) string {
+	var items []string
+	for _, t := range shogi.HandTypes {
+		switch count := pos.Hand(color, t); {
+		case count == 1:
+			items = append(items, kifPieceNames[t])
+		case count > 1:
+			items = append(items, kifPieceNames[t]+formatKanjiNumber(count))
+		}
+	}
+	if len(items) == 0 {
+		return label + "：なし"
+	}
+
+	return label + "：" + strings.Join(items, "　")
+}
+
+// writeBod writes pos as a BOD diagram, which can be read by scanProblems.
+func writeBod(w io.Writer, pos *shogi.Position) {
+	fmt.Fprintln(w, formatBodHand("後手の持駒", pos, shogi.White))
+	fmt.Fprintln(w, "  ９ ８ ７ ６ ５ ４ ３ ２ １")
+	fmt.Fprintln(w, "+---------------------------+")
+	for rank := 1; rank <= 9; rank++ {
+		var sb strings.Builder
+		sb.WriteString("|")
+		for file := 9; file >= 1; file-- {
+			switch piece := pos.Piece(shogi.NewSquare(file, rank)); {
+			case piece.IsEmpty():
+				sb.WriteString(" ・")
+			case piece.Color == shogi.White:
+				sb.WriteString("v" + bodSquareNames[piece.Type])
+			default:
+				sb.WriteString(" " + bodSquareNames[piece.Type])
+			}
+		}
+		sb.WriteString("|" + nthRune(kanjiDigits, rank-1))
+		fmt.Fprintln(w, sb.String())
+	}
+	fmt.Fprintln(w, "+---------------------------+")
+	fmt.Fprintln(w, formatBodHand("先手の持駒", pos, shogi.Black))
+	if pos.SideToMove() == shogi.White {
+		fmt.Fprintln(w, "後手番")
+	}
+}
+
+func kifSquare(sq shogi.Square) string {
+	return nthRune(zenkakuDigits, sq.File()-1) + nthRune(kanjiDigits, sq.Rank()-1)
+}
+
+// kifMove formats m in the KIF notation, e.g. "２三銀不成(34)". same is set if m moves to
+// the square of the previous move.
+func kifMove(m boardMove, same bool) string {
+	var sb strings.Builder
+	if same {
+		sb.WriteString("同　")
+	} else {
+		sb.WriteString(kifSquare(m.To))
+	}
+	sb.WriteString(kifPieceNames[m.Piece])
+	switch {
+	case m.IsDrop():
+		sb.WriteString("打")
+	case m.Promote:
+		sb.WriteString("成")
+	case m.CanPromote:
+		sb.WriteString("不成")
+	}
+	if !m.IsDrop() {
+		fmt.Fprintf(&sb, "(%d%d)", m.From.File(), m.From.Rank())
+	}
+
+	return sb.String()
+}
+
+// writeSolutionFile creates the file for the solution of problem in dir and writes its
+// contents with write. If shift_jis is set, the contents are encoded in Shift_JIS as
+// readers of KIF and KI2 files expect.
+func writeSolutionFile(dir string, problem Problem, ext string, shift_jis bool, write func(w io.Writer) error) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(filepath.Join(dir, solutionFileName(problem, ext)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var encoder *transform.Writer
+	var w *bufio.Writer
+	if shift_jis {
+		encoder = transform.NewWriter(file, japanese.ShiftJIS.NewEncoder())
+		w = bufio.NewWriter(encoder)
+	} else {
+		w = bufio.NewWriter(file)
+	}
+	if err := write(w); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if encoder != nil {
+		if err := encoder.Close(); err != nil {
+			return err
+		}
+	}
+	return file.Close()
+}
+
+// writeKif writes the solution of res into a KIF file in dir. The initial position is
+// written as a BOD diagram.
+func writeKif(dir string, res Result) error {
+	problem := res.Problem
+	pos, err := problemPosition(problem)
+	if err != nil {
+		return err
+	}
+
+	return writeSolutionFile(dir, problem, ".kif", true, func(w io.Writer) error {
+		fmt.Fprintln(w, "# KIF形式棋譜ファイル")
+		if problem.ID != "" {
+			fmt.Fprintln(w, "表題："+problem.ID)
+		}
+		writeBod(w, pos)
+		fmt.Fprintln(w, "手数----指手---------消費時間--")
+
+		prev := shogi.NoSquare
+		for i, move := range res.Pv {
+			m, err := playMove(pos, move)
+			if err != nil {
+				return fmt.Errorf("%v: %v", err, problem)
+			}
+			fmt.Fprintf(w, "%4d %s\n", i+1, kifMove(m, m.To == prev))
+			prev = m.To
+		}
+		fmt.Fprintf(w, "まで%d手で詰み\n", len(res.Pv))
+		return nil
+	})
+}
+
+// writeKi2 writes the solution of res into a KI2 file in dir, whose moves are written in the
+// Japanese notation.
+func writeKi2(dir string, res Result) error {
+	const movesPerLine = 6
+
+	problem := res.Problem
+	pos, err := problemPosition(problem)
+	if err != nil {
+		return err
+	}
+	moves, err := japaneseMoves(problem, res.Pv)
+	if err != nil {
+		return err
+	}
+
+	return writeSolutionFile(dir, problem, ".ki2", true, func(w io.Writer) error {
+		if problem.ID != "" {
+			fmt.Fprintln(w, "表題："+problem.ID)
+		}
+		writeBod(w, pos)
+		for i := 0; i < len(moves); i += movesPerLine {
+			end := i + movesPerLine
+			if end > len(moves) {
+				end = len(moves)
+			}
+			fmt.Fprintln(w, strings.Join(moves[i:end], " "))
+		}
+		fmt.Fprintf(w, "まで%d手で詰み\n", len(res.Pv))
+		return nil
+	})
+}
+
+var csaPieceCodes = func() map[shogi.PieceType]string {
+	codes := make(map[shogi.PieceType]string)
+	for code, t := range csaPieces {
+		codes[t] = code
+	}
+	return codes
+}()
+
+func csaColor(color shogi.Color) string {
+	if color == shogi.White {
+		return "-"
+	}
+
+	return "+"
+}
+
+// writeCsaPosition writes pos in the CSA format.
+func writeCsaPosition(w io.Writer, pos *shogi.Position) {
+	for rank := 1; rank <= 9; rank++ {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "P%d", rank)
+		for file := 9; file >= 1; file-- {
+			piece := pos.Piece(shogi.NewSquare(file, rank))
+			if piece.IsEmpty() {
+				sb.WriteString(" * ")
+				continue
+			}
+			sb.WriteString(csaColor(piece.Color) + csaPieceCodes[piece.Type])
+		}
+		fmt.Fprintln(w, sb.String())
+	}
+	for _, color := range []shogi.Color{shogi.Black, shogi.White} {
+		var sb strings.Builder
+		for _, t := range shogi.HandTypes {
+			for i := 0; i < pos.Hand(color, t); i++ {
+				sb.WriteString("00" + csaPieceCodes[t])
+			}
+		}
+		if sb.Len() > 0 {
+			fmt.Fprintf(w, "P%s%s\n", csaColor(color), sb.String())
+		}
+	}
+	fmt.Fprintln(w, csaColor(pos.SideToMove()))
+}
+
+// csaMove formats m in the CSA notation, e.g. "+3423NG".
+func csaMove(m boardMove) string {
+	from := "00"
+	if !m.IsDrop() {
+		from = fmt.Sprintf("%d%d", m.From.File(), m.From.Rank())
+	}
+	piece := m.Piece
+	if m.Promote {
+		piece = piece.Promoted()
+	}
+
+	return fmt.Sprintf("%s%s%d%d%s", csaColor(m.Color), from, m.To.File(), m.To.Rank(), csaPieceCodes[piece])
+}
+
+// writeCsa writes the solution of res into a CSA file in dir.
+func writeCsa(dir string, res Result) error {
+	problem := res.Problem
+	pos, err := problemPosition(problem)
+	if err != nil {
+		return err
+	}
+
+	return writeSolutionFile(dir, problem, ".csa", false, func(w io.Writer) error {
+		fmt.Fprintln(w, "V2.2")
+		if problem.ID != "" {
+			fmt.Fprintln(w, "$EVENT:"+problem.ID)
+		}
+		writeCsaPosition(w, pos)
+		for _, move := range res.Pv {
+			m, err := playMove(pos, move)
+			if err != nil {
+				return fmt.Errorf("%v: %v", err, problem)
+			}
+			fmt.Fprintln(w, csaMove(m))
+		}
+		_, err := io.WriteString(w, "%TSUMI\n")
+		return err
+	})
+}
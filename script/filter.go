@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+)
+
+const filterHelp = `select positions by predicates (repeatable; all must hold).
+A predicate is "<var><op><int>" with op in <,<=,==,!=,>=,> or "<var>=<lo>..<hi>", or a
+boolean "rest"/"!rest" (the defender holds all remaining pieces in hand). Variables:
+  pieces    pieces on the board (including kings)
+  attacker  attacker's pieces on the board
+  defender  defender's pieces on the board (including the king)
+  hand      attacker's pieces in hand
+  R,B,G,S,N,L,P  pieces of the type on the board or in the attacker's hand`
+
+var filterVars = []string{"pieces", "attacker", "defender", "hand", "R", "B", "G", "S", "N", "L", "P"}
+
+type positionStats struct {
+	vars map[string]int
+	rest bool
+}
+
+func newPositionStats(sfen string) (positionStats, error) {
+	pos, err := shogi.ParseSfen(sfen)
+	if err != nil {
+		return positionStats{}, err
+	}
+	attacker := pos.SideToMove()
+
+	vars := make(map[string]int)
+	total := 0
+	for sq := shogi.Square(0); sq < 81; sq++ {
+		piece := pos.Piece(sq)
+		if piece.IsEmpty() {
+			continue
+		}
+
+		vars["pieces"]++
+		if piece.Color == attacker {
+			vars["attacker"]++
+		} else {
+			vars["defender"]++
+		}
+		if piece.Type != shogi.King {
+			vars[piece.Type.Unpromoted().String()]++
+			total++
+		}
+	}
+	for _, color := range []shogi.Color{shogi.Black, shogi.White} {
+		for _, t := range shogi.HandTypes {
+			count := pos.Hand(color, t)
+			if count == 0 {
+				continue
+			}
+			if color == attacker {
+				vars["hand"] += count
+				vars[t.String()] += count
+			}
+			total += count
+		}
+	}
+
+	return positionStats{vars: vars, rest: total == 38}, nil
+}
+
+type predicate func(stats positionStats) bool
+
+var predicatePattern = regexp.MustCompile(`^(\w+)\s*(<=|>=|==|!=|<|>|=)\s*(\d+)(?:\.\.(\d+))?$`)
+
+func parsePredicate(expr string) (predicate, error) {
+	expr = strings.TrimSpace(expr)
+	switch expr {
+	case "rest":
+		return func(stats positionStats) bool { return stats.rest }, nil
+	case "!rest":
+		return func(stats positionStats) bool { return !stats.rest }, nil
+	}
+
+	m := predicatePattern.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("invalid filter: %q", expr)
+	}
+	name, op := m[1], m[2]
+	known := false
+	for _, v := range filterVars {
+		known = known || v == name
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown variable %q in filter: %q", name, expr)
+	}
+	value, _ := strconv.Atoi(m[3])
+	if m[4] != "" {
+		if op != "=" {
+			return nil, fmt.Errorf("a range must be specified with '=': %q", expr)
+		}
+		high, _ := strconv.Atoi(m[4])
+		return func(stats positionStats) bool {
+			return value <= stats.vars[name] && stats.vars[name] <= high
+		}, nil
+	}
+
+	compare := map[string]func(a, b int) bool{
+		"<":  func(a, b int) bool { return a < b },
+		"<=": func(a, b int) bool { return a <= b },
+		"==": func(a, b int) bool { return a == b },
+		"=":  func(a, b int) bool { return a == b },
+		"!=": func(a, b int) bool { return a != b },
+		">=": func(a, b int) bool { return a >= b },
+		">":  func(a, b int) bool { return a > b },
+	}[op]
+
+	return func(stats positionStats) bool { return compare(stats.vars[name], value) }, nil
+}
+
+type Filter struct {
+	predicates []predicate
+	filtered   int
+}
+
+// newFilter parses filter expressions. Each expression may contain several predicates
+// separated by commas.
+func newFilter(exprs []string) (*Filter, error) {
+	f := &Filter{}
+	for _, expr := range exprs {
+		for _, item := range strings.Split(expr, ",") {
+			if strings.TrimSpace(item) == "" {
+				continue
+			}
+			pred, err := parsePredicate(item)
+			if err != nil {
+				return nil, err
+			}
+			f.predicates = append(f.predicates, pred)
+		}
+	}
+
+	return f, nil
+}
+
+// Match reports whether the initial position of problem satisfies all predicates.
+func (f *Filter) Match(problem Problem) bool {
+	if len(f.predicates) == 0 {
+		return true
+	}
+
+	stats, err := newPositionStats(problem.Sfen)
+	if err != nil {
+		f.filtered++
+		return false
+	}
+	for _, pred := range f.predicates {
+		if !pred(stats) {
+			f.filtered++
+			return false
+		}
+	}
+
+	return true
+}
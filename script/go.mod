@@ -0,0 +1,17 @@
+module github.com/komori-n/KomoringHeights/script
+
+go 1.25.0
+
+require (
+	github.com/mattn/go-sqlite3 v1.14.33
+	github.com/schollz/progressbar/v3 v3.19.1
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/text v0.40.0
+)
+
+require (
+	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
+	github.com/rivo/uniseg v0.4.7 // indirect
+	golang.org/x/sys v0.46.0 // indirect
+	golang.org/x/term v0.44.0 // indirect
+)
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+type htmlRow struct {
+	ID       string
+	Position string
+	Status   string
+	Category string
+	Detail   string
+	TimeMs   int64
+	Nodes    int64
+	MateLen  string
+	Pv       string
+	Surplus  string
+	Cooks    string
+	Mudaai   string
+}
+
+// htmlResultWriter collects results and writes a standalone HTML report on Close.
+type htmlResultWriter struct {
+	w      io.Writer
+	start  time.Time
+	info   RunInfo
+	rows   []htmlRow
+	times  []time.Duration
+	solved int
+}
+
+func (w *htmlResultWriter) Write(res Result) error {
+	problem := res.Problem
+	row := htmlRow{
+		ID:       problem.ID,
+		Position: problemKey(problem.Sfen, problem.Moves),
+		Status:   res.Status(),
+		Category: res.Category(),
+		TimeMs:   res.Time.Milliseconds(),
+		Nodes:    res.Nodes,
+	}
+	if res.Err != nil {
+		row.Detail = res.Err.Error()
+	} else {
+		w.solved++
+		row.MateLen = fmt.Sprint(len(res.Pv))
+		row.Pv = strings.Join(res.Pv, " ")
+		row.Surplus = res.Surplus
+		row.Cooks = formatCooks(res.Cooks)
+		row.Mudaai = formatInterpositions(res.Interpositions)
+		if problem.HasExpectation() {
+			row.Detail = problem.CheckAnswer(res.Pv)
+		}
+	}
+	w.rows = append(w.rows, row)
+	w.times = append(w.times, res.Time)
+
+	return nil
+}
+
+func (w *htmlResultWriter) Close() error {
+	return htmlReportTemplate.Execute(w.w, map[string]interface{}{
+		"Start":     w.start.Format("2006-01-02 15:04:05"),
+		"Info":      w.info.String(),
+		"Elapsed":   fmt.Sprintf("%.2f", time.Since(w.start).Seconds()),
+		"Total":     len(w.rows),
+		"Solved":    w.solved,
+		"Rows":      w.rows,
+		"Histogram": template.HTML(histogramSvg(timeHistogram(w.times))),
+	})
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mate results {{.Start}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; font-size: 90%; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; }
+th { background: #eee; cursor: pointer; user-select: none; }
+td.num { text-align: right; }
+td.pos, td.pv { font-family: monospace; }
+tr.failed { background: #fdd; }
+tr.mismatch { background: #fdf; }
+</style>
+</head>
+<body>
+<h1>mate results</h1>
+<p>started at {{.Start}}, {{.Elapsed}} sec, solved/total: {{.Solved}}/{{.Total}}</p>
+<p><code>{{.Info}}</code></p>
+<h2>solve time</h2>
+{{.Histogram}}
+<h2>positions</h2>
+<p><input id="filter" type="search" placeholder="filter" size="40"></p>
+<table id="results">
+<thead><tr>
+<th>id</th><th>position</th><th>status</th><th>category</th><th>detail</th>
+<th data-num>time (ms)</th><th data-num>nodes</th><th data-num>mate</th><th>pv</th><th>surplus</th><th>cooks</th><th>mudaai</th>
+</tr></thead>
+<tbody>
+{{- range .Rows}}
+<tr class="{{.Status}}{{if and (eq .Status "solved") .Detail}} mismatch{{end}}">
+<td>{{.ID}}</td><td class="pos">{{.Position}}</td><td>{{.Status}}</td><td>{{.Category}}</td><td>{{.Detail}}</td>
+<td class="num">{{.TimeMs}}</td><td class="num">{{.Nodes}}</td><td class="num">{{.MateLen}}</td><td class="pv">{{.Pv}}</td><td>{{.Surplus}}</td><td class="pv">{{.Cooks}}</td><td class="pv">{{.Mudaai}}</td>
+</tr>
+{{- end}}
+</tbody>
+</table>
+<script>
+const table = document.getElementById("results");
+const body = table.tBodies[0];
+table.querySelectorAll("th").forEach((th, col) => {
+  let asc = true;
+  th.addEventListener("click", () => {
+    const num = th.hasAttribute("data-num");
+    const key = (row) => row.cells[col].textContent;
+    const rows = Array.from(body.rows);
+    rows.sort((a, b) => {
+      const x = key(a), y = key(b);
+      const c = num ? (Number(x) || 0) - (Number(y) || 0) : x.localeCompare(y);
+      return asc ? c : -c;
+    });
+    asc = !asc;
+    rows.forEach((row) => body.appendChild(row));
+  });
+});
+document.getElementById("filter").addEventListener("input", (e) => {
+  const words = e.target.value.toLowerCase().split(/\s+/).filter((w) => w);
+  Array.from(body.rows).forEach((row) => {
+    const text = row.textContent.toLowerCase();
+    row.style.display = words.every((w) => text.includes(w)) ? "" : "none";
+  });
+});
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+var problemFileExts = []string{".sfen", ".jsonl", ".ndjson", ".csv", ".kif", ".kifu", ".ki2", ".ki2u", ".bod", ".csa"}
+
+func isProblemFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range problemFileExts {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+func expandInputs(patterns []string) ([]string, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		if isURL(pattern) {
+			paths = append(paths, pattern)
+			continue
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no such file: %s", pattern)
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				paths = append(paths, match)
+				continue
+			}
+
+			err = filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.IsDir() && (isProblemFile(path) || isArchiveFile(path)) {
+					paths = append(paths, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return paths, nil
+}
+
+func isKifuFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".kif", ".kifu", ".ki2", ".ki2u", ".bod":
+		return true
+	default:
+		return false
+	}
+}
+
+func isCsaFile(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".csa"
+}
+
+// errStopped is the error of reading the input after its reader is stopped.
+var errStopped = errors.New("stopped reading the input")
+
+// doneReader is a reader which fails with errStopped once done is closed.
+type doneReader struct {
+	r    io.Reader
+	done <-chan struct{}
+}
+
+func (r doneReader) Read(p []byte) (int, error) {
+	select {
+	case <-r.done:
+		return 0, errStopped
+	default:
+	}
+
+	return r.r.Read(p)
+}
+
+// readInput reads the positions in r, which is named name, until done is closed (nil: never).
+func readInput(name string, r io.Reader, done <-chan struct{}, emit func(Problem)) error {
+	r = doneReader{r: r, done: done}
+	if strings.ToLower(filepath.Ext(name)) == ".csv" {
+		return scanCsvProblems(r, emit)
+	}
+	if !isKifuFile(name) && !isCsaFile(name) {
+		return scanProblems(r, false, emit)
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !utf8.Valid(data) {
+		data, err = japanese.ShiftJIS.NewDecoder().Bytes(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if isCsaFile(name) {
+		return scanCsa(doneReader{r: bytes.NewReader(data), done: done}, emit)
+	}
+	count := 0
+	err = scanProblems(doneReader{r: bytes.NewReader(data), done: done}, true, func(problem Problem) {
+		count++
+		emit(problem)
+	})
+	if err == nil && count == 0 {
+		err = fmt.Errorf("no positions found")
+	}
+	return err
+}
+
+func isArchiveFile(path string) bool {
+	lower := strings.ToLower(path)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func scanZip(path string, done <-chan struct{}, emit func(Problem)) error {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() || !isProblemFile(f.Name) {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		err = readInput(f.Name, r, done, emit)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func scanTar(name string, r io.Reader, done <-chan struct{}, emit func(Problem)) error {
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg || !isProblemFile(header.Name) {
+			continue
+		}
+
+		err = readInput(header.Name, archive, done, emit)
+		if err != nil {
+			return fmt.Errorf("%s: %v", header.Name, err)
+		}
+	}
+}
+
+func scanURL(rawurl string, done <-chan struct{}, emit func(Problem)) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Get(rawurl)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	name := u.Path
+	switch {
+	case strings.ToLower(filepath.Ext(name)) == ".zip":
+		tmp, err := os.CreateTemp("", "mate-*.zip")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+
+		_, err = io.Copy(tmp, resp.Body)
+		tmp.Close()
+		if err != nil {
+			return err
+		}
+		return scanZip(tmp.Name(), done, emit)
+	case isArchiveFile(name):
+		return scanTar(name, resp.Body, done, emit)
+	default:
+		return readInput(name, resp.Body, done, emit)
+	}
+}
+
+// scanFile reads the positions in path, which may be a URL, a problem database or an archive,
+// until done is closed (nil: never).
+func scanFile(path string, done <-chan struct{}, emit func(Problem)) error {
+	if isURL(path) {
+		return scanURL(path, done, emit)
+	}
+	if isProblemDB(path) {
+		return scanProblemDB(path, done, emit)
+	}
+	if strings.ToLower(filepath.Ext(path)) == ".zip" {
+		return scanZip(path, done, emit)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if isArchiveFile(path) {
+		return scanTar(path, file, done, emit)
+	}
+	return readInput(path, file, done, emit)
+}
+
+func readProblems(paths []string) ([]Problem, error) {
+	var problems []Problem
+	for _, path := range paths {
+		err := scanFile(path, nil, func(problem Problem) {
+			problem.Source = path
+			problems = append(problems, problem)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	return problems, nil
+}
+
+// streamBuffer is the number of positions read ahead of the engines with --stream.
+const streamBuffer = 1024
+
+// streamProblems reads the problems in paths one by one into the returned channel so that huge
+// inputs are never held in memory at once. Reading waits while streamBuffer positions are left
+// unsolved. The channel is closed at the end, and then the error of reading, if any, is sent.
+// Once done is closed, reading stops and the rest of the problems are dropped.
+func streamProblems(paths []string, done <-chan struct{}) (<-chan Problem, <-chan error) {
+	problems := make(chan Problem, streamBuffer)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(problems)
+		for _, path := range paths {
+			err := scanFile(path, done, func(problem Problem) {
+				problem.Source = path
+				select {
+				case problems <- problem:
+				case <-done:
+				}
+			})
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err != nil {
+				errs <- fmt.Errorf("%s: %v", path, err)
+				return
+			}
+		}
+	}()
+	return problems, errs
+}
+
+// countProblems returns the number of problems in paths. URLs are not counted since they would
+// be downloaded twice.
+func countProblems(paths []string) (int, error) {
+	count := 0
+	for _, path := range paths {
+		if isURL(path) {
+			return 0, fmt.Errorf("%s: cannot count the problems without downloading them", path)
+		}
+		if err := scanFile(path, nil, func(Problem) { count++ }); err != nil {
+			return 0, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// runInteractive solves positions entered at a prompt one by one with a single engine
+// process kept alive between queries. Ctrl-C stops the current search.
+func runInteractive(command string, op Options) error {
+	process, err := newEngineProcess(command)
+	if err != nil {
+		return err
+	}
+	if err := process.SetOption(op); err != nil {
+		return err
+	}
+	if err := process.Ready(); err != nil {
+		return err
+	}
+
+	var solving atomic.Bool
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	go func() {
+		for range interrupt {
+			if !solving.Load() {
+				fmt.Println()
+				os.Exit(0)
+			}
+			fmt.Fprintln(process.stdin, "stop")
+		}
+	}()
+
+	scanner := bufio.NewScanner(os.Stdin)
+	prompt := func() {
+		fmt.Print("> ")
+	}
+	readProblem := func(line string) ([]Problem, error) {
+		var problems []Problem
+		emit := func(problem Problem) {
+			problems = append(problems, problem)
+		}
+
+		if !isBodLine(line) {
+			return problems, scanProblems(strings.NewReader(line), false, emit)
+		}
+
+		block := []string{line}
+		for scanner.Scan() {
+			next := scanner.Text()
+			if strings.TrimSpace(next) == "" || !isBodLine(next) {
+				break
+			}
+			block = append(block, next)
+		}
+		return problems, scanProblems(strings.NewReader(strings.Join(block, "\n")), true, emit)
+	}
+
+	fmt.Println("enter an SFEN, a \"position\" command or a BOD diagram followed by an empty line (Ctrl-D to quit)")
+	prompt()
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			prompt()
+			continue
+		}
+
+		problems, err := readProblem(line)
+		if err != nil {
+			fmt.Println("error:", err)
+		}
+		for _, problem := range problems {
+			if !op.NoValidate {
+				if err := validateProblem(problem); err != nil {
+					fmt.Printf("invalid position: %v\n", err)
+					continue
+				}
+			}
+			if err := process.ApplyProblemOptions(op, problem); err != nil {
+				return err
+			}
+
+			fmt.Println(problem)
+			solving.Store(true)
+			start := time.Now()
+			res := process.Solve(problem, op.TimeLimit)
+			elapsed := time.Since(start)
+			solving.Store(false)
+			res.Problem = problem
+			res = verifyResult(res)
+
+			if res.Err != nil {
+				fmt.Printf("%v  (%.2f sec)\n", res.Err, elapsed.Seconds())
+				continue
+			}
+			fmt.Printf("checkmate %s\n", strings.Join(res.Pv, " "))
+			if moves, err := japaneseMoves(problem, res.Pv); err == nil {
+				fmt.Println(strings.Join(moves, " "))
+			}
+			fmt.Printf("mate %d  (%.2f sec)\n", len(res.Pv), elapsed.Seconds())
+		}
+		prompt()
+	}
+	fmt.Println()
+
+	return scanner.Err()
+}
+
+// runSingle solves a position streaming all engine output to the terminal.
+func runSingle(command string, op Options, sfen string) error {
+	problem, err := parseProblem(sfen)
+	if err != nil {
+		return err
+	}
+	if !op.NoValidate {
+		if err := validateProblem(problem); err != nil {
+			return fmt.Errorf("invalid position: %v", err)
+		}
+	}
+
+	process, err := newEngineProcess(command)
+	if err != nil {
+		return err
+	}
+	if err := process.SetOption(op); err != nil {
+		return err
+	}
+	if err := process.Ready(); err != nil {
+		return err
+	}
+	if err := process.ApplyProblemOptions(op, problem); err != nil {
+		return err
+	}
+
+	process.on_line = func(line string) {
+		fmt.Println(line)
+	}
+	start := time.Now()
+	res := process.Solve(problem, op.TimeLimit)
+	elapsed := time.Since(start)
+	res.Problem = problem
+	res = verifyResult(res)
+	if res.Err != nil {
+		return fmt.Errorf("%v  (%.2f sec)", res.Err, elapsed.Seconds())
+	}
+	fmt.Printf("mate %d  (%.2f sec)\n", len(res.Pv), elapsed.Seconds())
+
+	return nil
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+)
+
+var bodBoardPieces = map[rune]shogi.PieceType{
+	'玉': shogi.King, '王': shogi.King, '飛': shogi.Rook, '角': shogi.Bishop, '金': shogi.Gold,
+	'銀': shogi.Silver, '桂': shogi.Knight, '香': shogi.Lance, '歩': shogi.Pawn, '龍': shogi.Dragon,
+	'竜': shogi.Dragon, '馬': shogi.Horse, '全': shogi.ProSilver, '圭': shogi.ProKnight, '杏': shogi.ProLance,
+	'と': shogi.ProPawn,
+}
+
+var bodHandPieces = map[rune]shogi.PieceType{
+	'飛': shogi.Rook, '角': shogi.Bishop, '金': shogi.Gold, '銀': shogi.Silver, '桂': shogi.Knight,
+	'香': shogi.Lance, '歩': shogi.Pawn,
+}
+
+func parseKanjiNumber(s string) (int, error) {
+	const digits = "一二三四五六七八九"
+
+	n := 0
+	current := 0
+	for _, r := range s {
+		switch {
+		case r == '十':
+			if current == 0 {
+				current = 1
+			}
+			n += current * 10
+			current = 0
+		case strings.ContainsRune(digits, r):
+			current = strings.IndexRune(digits, r)/len("一") + 1
+		default:
+			return 0, fmt.Errorf("invalid number: %s", s)
+		}
+	}
+
+	return n + current, nil
+}
+
+type bodBoard struct {
+	diagram
+	rows        int
+	bottom_seen bool
+}
+
+func newBodBoard() *bodBoard {
+	return &bodBoard{diagram: newDiagram()}
+}
+
+func isBodLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	for _, prefix := range []string{
+		"先手の持駒", "後手の持駒", "下手の持駒", "上手の持駒", "手数＝", "手数=",
+		"先手番", "後手番", "下手番", "上手番", "９ ８ ７", "+---", "|",
+	} {
+		if strings.HasPrefix(trimmed, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *bodBoard) addLine(line string) error {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(trimmed, "先手の持駒"), strings.HasPrefix(trimmed, "下手の持駒"):
+		return b.parseHand(shogi.Black, trimmed)
+	case strings.HasPrefix(trimmed, "後手の持駒"), strings.HasPrefix(trimmed, "上手の持駒"):
+		return b.parseHand(shogi.White, trimmed)
+	case strings.HasPrefix(trimmed, "後手番"), strings.HasPrefix(trimmed, "上手番"):
+		b.position.SetSideToMove(shogi.White)
+	case strings.HasPrefix(trimmed, "先手番"), strings.HasPrefix(trimmed, "下手番"):
+		b.position.SetSideToMove(shogi.Black)
+	case strings.HasPrefix(trimmed, "+---"):
+		if b.rows > 0 {
+			b.bottom_seen = true
+		}
+	case strings.HasPrefix(trimmed, "|"):
+		return b.parseRow(trimmed)
+	}
+
+	return nil
+}
+
+func (b *bodBoard) parseHand(color shogi.Color, line string) error {
+	text := line
+	if i := strings.IndexAny(text, "：:"); i >= 0 {
+		text = text[i:]
+		text = strings.TrimLeft(text, "：:")
+	}
+	text = strings.TrimSpace(text)
+	if text == "" || text == "なし" {
+		return nil
+	}
+	if strings.HasPrefix(text, "残り") {
+		if color == shogi.Black {
+			return fmt.Errorf("only the defender can hold the rest pieces: %s", line)
+		}
+		b.gote_rest = true
+		return nil
+	}
+
+	for _, token := range strings.FieldsFunc(text, func(r rune) bool { return r == ' ' || r == '　' }) {
+		runes := []rune(token)
+		piece, ok := bodHandPieces[runes[0]]
+		if !ok {
+			return fmt.Errorf("invalid hand piece: %s", token)
+		}
+		count := 1
+		if len(runes) > 1 {
+			var err error
+			count, err = parseKanjiNumber(string(runes[1:]))
+			if err != nil {
+				return err
+			}
+		}
+		b.addHand(color, piece, count)
+	}
+
+	return nil
+}
+
+func (b *bodBoard) parseRow(line string) error {
+	if b.rows >= 9 {
+		return fmt.Errorf("too many board rows: %s", line)
+	}
+
+	inner := strings.TrimPrefix(line, "|")
+	if i := strings.Index(inner, "|"); i >= 0 {
+		inner = inner[:i]
+	}
+
+	cells := 0
+	gote := false
+	for _, r := range inner {
+		switch r {
+		case ' ', '　':
+			continue
+		case 'v', 'V':
+			gote = true
+			continue
+		case '・':
+		default:
+			t, ok := bodBoardPieces[r]
+			if !ok {
+				return fmt.Errorf("invalid board piece %q: %s", r, line)
+			}
+			piece := shogi.Piece{Type: t, Color: shogi.Black}
+			if gote {
+				piece.Color = shogi.White
+			}
+			if cells < 9 {
+				b.position.SetPiece(shogi.NewSquare(9-cells, b.rows+1), piece)
+			}
+		}
+		cells++
+		gote = false
+	}
+	if cells != 9 {
+		return fmt.Errorf("a board row must have 9 squares: %s", line)
+	}
+	b.rows++
+
+	return nil
+}
+
+func (b *bodBoard) Sfen() (string, error) {
+	if b.rows != 9 {
+		return "", fmt.Errorf("a board must have 9 rows, got %d", b.rows)
+	}
+
+	return b.diagram.Sfen()
+}
+
+// kifuRecord is the position of a diagram, or the initial position if the record has none,
+// followed by the moves of the main line of a KIF or KI2 record.
+type kifuRecord struct {
+	sfen     string
+	diagram  bool
+	position *shogi.Position
+	moves    []string
+	// ended is set at the end of the main line, e.g. "投了" or the first "変化"
+	ended bool
+}
+
+func newKifuRecord(sfen string, diagram bool) (*kifuRecord, error) {
+	pos, err := shogi.ParseSfen(sfen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kifuRecord{sfen: sfen, diagram: diagram, position: pos}, nil
+}
+
+// normalizeKifuMove removes the marks of the side to move, the spaces and "不成" from a move in
+// the KIF or KI2 notation and unifies the names of the pieces.
+func normalizeKifuMove(text string) string {
+	text = strings.NewReplacer("▲", "", "△", "", "☗", "", "☖", "", " ", "", "　", "", "不成", "").Replace(text)
+	return strings.NewReplacer("竜", "龍", "王", "玉", "全", "成銀", "圭", "成桂", "杏", "成香").Replace(text)
+}
+
+// Play parses a move in the KIF or KI2 notation and plays it. The move is compared with every
+// legal move written in both notations, so that the disambiguation of KI2 moves need not be
+// parsed.
+func (r *kifuRecord) Play(text string) error {
+	text = normalizeKifuMove(text)
+	prev := shogi.NoSquare
+	if len(r.moves) > 0 {
+		prev, _ = shogi.ParseSquare(r.moves[len(r.moves)-1][2:4])
+	}
+
+	var matches []shogi.Move
+	for _, m := range r.position.LegalMoves() {
+		bm := describeMove(r.position, m)
+
+		// some writers omit "同" or write "打" for drops without rivals
+		var notations []string
+		for _, same := range []bool{m.To == prev, false} {
+			notations = append(notations, kifMove(bm, same), japaneseMove(r.position, bm, same))
+		}
+		for _, notation := range notations {
+			notation = normalizeKifuMove(notation)
+			if text == notation || m.IsDrop() && text == notation+"打" {
+				matches = append(matches, m)
+				break
+			}
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return fmt.Errorf("illegal move: %s", text)
+	case len(matches) > 1:
+		return fmt.Errorf("ambiguous move: %s", text)
+	}
+
+	r.position.Do(matches[0])
+	r.moves = append(r.moves, matches[0].String())
+	return nil
+}
+
+// Problem returns the position reached by the moves. If the moves lead from a diagram to
+// checkmate, they are regarded as the solution and the diagram is returned instead.
+func (r *kifuRecord) Problem() Problem {
+	if len(r.moves) == 0 || r.diagram && r.position.IsCheckmate() {
+		return Problem{Sfen: r.sfen}
+	}
+
+	return Problem{Sfen: r.sfen, Moves: r.moves}
+}
+
+// kifuMoves returns the moves of a line of the move list of a KIF or KI2 record, and whether the
+// line belongs to the move list. A KIF line has a single move after its number, or a word
+// ending the game such as "投了", for which an empty move is returned.
+func kifuMoves(line string) ([]string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" {
+		return nil, false
+	}
+	if strings.ContainsRune("▲△☗☖", []rune(trimmed)[0]) {
+		return strings.FieldsFunc(trimmed, func(r rune) bool { return strings.ContainsRune("▲△☗☖", r) }), true
+	}
+	if trimmed[0] < '0' || trimmed[0] > '9' {
+		return nil, false
+	}
+
+	rest := strings.TrimLeft(strings.TrimLeft(trimmed, "0123456789"), " 　")
+	if strings.HasPrefix(rest, "同") {
+		rest = "同" + strings.TrimLeft(strings.TrimPrefix(rest, "同"), " 　")
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "同") && !strings.ContainsRune(zenkakuDigits, []rune(fields[0])[0]) {
+		return []string{""}, true
+	}
+	return fields[:1], true
+}
+
+// scanProblems reads positions from r and passes each of them to emit.
+// BOD blocks are converted into SFEN. Lines starting with '{' are regarded as JSON records
+// (see jsonProblem), and other lines are regarded as SFEN or "position" lines unless
+// kifu_only is set. If kifu_only is set, the moves of KIF and KI2 records are played from the
+// preceding diagram, or from the initial position if there is none (see kifuRecord), and other
+// lines such as headers are ignored.
+func scanProblems(r io.Reader, kifu_only bool, emit func(Problem)) error {
+	scanner := bufio.NewScanner(r)
+	line_no := 0
+
+	var record *kifuRecord
+	// handicap is the "手合割" of the record. A record without a diagram is started from the
+	// initial position at its first move unless it names another handicap.
+	handicap := ""
+	emitRecord := func() {
+		if record != nil {
+			emit(record.Problem())
+			record = nil
+		}
+	}
+	playKifu := func(line string) error {
+		for _, prefix := range []string{"手合割：", "手合割:"} {
+			if strings.HasPrefix(line, prefix) {
+				handicap = strings.TrimSpace(strings.TrimPrefix(line, prefix))
+				return nil
+			}
+		}
+		if strings.HasPrefix(line, "変化") {
+			if record != nil {
+				record.ended = true
+			}
+			return nil
+		}
+
+		moves, ok := kifuMoves(line)
+		if !ok {
+			return nil
+		}
+		if record == nil {
+			if handicap != "" && handicap != "平手" {
+				return fmt.Errorf("unsupported handicap without a diagram: %s", handicap)
+			}
+			var err error
+			if record, err = newKifuRecord("startpos", false); err != nil {
+				return err
+			}
+		}
+		for _, move := range moves {
+			if move == "" {
+				record.ended = true
+			}
+			if record.ended || strings.TrimSpace(move) == "" {
+				continue
+			}
+			if err := record.Play(move); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var board *bodBoard
+	board_line := 0
+	flush := func() error {
+		if board == nil {
+			return nil
+		}
+		if board.rows == 0 {
+			board = nil
+			return nil
+		}
+		sfen, err := board.Sfen()
+		board = nil
+		if err != nil {
+			return fmt.Errorf("line %d: %v", board_line, err)
+		}
+		if !kifu_only {
+			emit(Problem{Sfen: sfen})
+			return nil
+		}
+		emitRecord()
+		if record, err = newKifuRecord(sfen, true); err != nil {
+			return fmt.Errorf("line %d: %v", board_line, err)
+		}
+		return nil
+	}
+
+	for scanner.Scan() {
+		line_no++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		line = strings.TrimPrefix(line, "\ufeff")
+
+		if isBodLine(line) {
+			trimmed := strings.TrimSpace(line)
+			if board != nil && board.bottom_seen &&
+				(strings.HasPrefix(trimmed, "後手の持駒") || strings.HasPrefix(trimmed, "上手の持駒")) {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			if board == nil {
+				board = newBodBoard()
+				board_line = line_no
+			}
+			if err := board.addLine(line); err != nil {
+				return fmt.Errorf("line %d: %v", line_no, err)
+			}
+			continue
+		}
+
+		if err := flush(); err != nil {
+			return err
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if kifu_only {
+			if err := playKifu(trimmed); err != nil {
+				return fmt.Errorf("line %d: %v", line_no, err)
+			}
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		var problem Problem
+		var err error
+		if strings.HasPrefix(trimmed, "{") {
+			problem, err = parseJsonProblem(trimmed)
+		} else {
+			problem, err = parseProblem(trimmed)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line_no, err)
+		}
+		emit(problem)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+	emitRecord()
+	return nil
+}
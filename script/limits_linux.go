@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// setPriority sets the nice value of the process pid. Threads created by the process
+// afterwards inherit it.
+func setPriority(pid int, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, nice)
+}
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+var (
+	engineCgroups atomic.Int64
+	fallbackOnce  sync.Once
+)
+
+// checkLimits checks that the limits can be applied to the engines. The CPU time can be limited
+// only with cgroup, which must be a cgroup v2 delegated to the user, e.g. with
+// systemd-run --user --scope -p Delegate=yes, with the memory and cpu controllers enabled for its
+// children. The harness neither moves itself into cgroup nor enables the controllers.
+func checkLimits(cgroup string, limit_mb int, cpus float64) error {
+	if cgroup == "" {
+		if cpus > 0 {
+			return errors.New("--cpu-limit requires --cgroup")
+		}
+		return nil
+	}
+
+	subtree, err := os.ReadFile(filepath.Join(cgroupPath(cgroup), "cgroup.subtree_control"))
+	if err != nil {
+		return fmt.Errorf("--cgroup %s is not a cgroup v2: %v", cgroup, err)
+	}
+	for _, controller := range []string{"memory", "cpu"} {
+		if !slices.Contains(strings.Fields(string(subtree)), controller) {
+			return fmt.Errorf("--cgroup %s does not enable the %s controller for its children (write \"+memory +cpu\" into its cgroup.subtree_control)", cgroup, controller)
+		}
+	}
+	return nil
+}
+
+// cgroupPath returns the directory of cgroup, which is relative to cgroupRoot unless absolute.
+func cgroupPath(cgroup string) string {
+	if filepath.IsAbs(cgroup) {
+		return cgroup
+	}
+	return filepath.Join(cgroupRoot, cgroup)
+}
+
+// newEngineCgroup creates a cgroup under parent with the limits of the memory (memory.max) and
+// the CPU time (cpu.max).
+func newEngineCgroup(parent string, limit_mb int, cpus float64) (string, error) {
+	dir := filepath.Join(cgroupPath(parent), fmt.Sprintf("engine-%d-%d", os.Getpid(), engineCgroups.Add(1)))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+	write := func(name string, value string) error {
+		return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+	}
+	if limit_mb > 0 {
+		if err := write("memory.max", strconv.FormatInt(int64(limit_mb)<<20, 10)); err != nil {
+			os.Remove(dir)
+			return "", err
+		}
+		// swapping the rest out would let the engine exceed the limit slowly; no swap is allowed
+		write("memory.swap.max", "0")
+	}
+	if cpus > 0 {
+		const period = 100000
+		if err := write("cpu.max", fmt.Sprintf("%d %d", int64(cpus*period), period)); err != nil {
+			os.Remove(dir)
+			return "", err
+		}
+	}
+	return dir, nil
+}
+
+// startLimited starts cmd with its memory limited to limits.memory_mb MB and its CPU time to
+// limits.cpus CPUs, either of which is unlimited if 0, so that a runaway engine fails alone
+// instead of exhausting the host. With limits.cgroup, the process starts in a cgroup of its own,
+// which is removed once the process exits. Otherwise, the memory is limited with the address
+// space (RLIMIT_AS) right after the start, which counts memory reserved but never used as well.
+func startLimited(cmd *exec.Cmd, limits engineLimits) error {
+	if limits.memory_mb == 0 && limits.cpus == 0 {
+		return cmd.Start()
+	}
+	if limits.cgroup == "" {
+		return startAddressLimited(cmd, limits.memory_mb)
+	}
+
+	dir, err := newEngineCgroup(limits.cgroup, limits.memory_mb, limits.cpus)
+	if err != nil {
+		return err
+	}
+	fd, err := syscall.Open(dir, syscall.O_DIRECTORY|syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		os.Remove(dir)
+		return err
+	}
+	defer syscall.Close(fd)
+	cmd.SysProcAttr = &syscall.SysProcAttr{UseCgroupFD: true, CgroupFD: fd}
+	if err := cmd.Start(); err != nil {
+		os.Remove(dir)
+		return err
+	}
+
+	go func() {
+		for {
+			time.Sleep(time.Second)
+			events, err := os.ReadFile(filepath.Join(dir, "cgroup.events"))
+			if err != nil || strings.Contains(string(events), "populated 0") {
+				os.Remove(dir)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// startAddressLimited starts cmd and limits its address space to limit_mb MB.
+func startAddressLimited(cmd *exec.Cmd, limit_mb int) error {
+	fallbackOnce.Do(func() {
+		slog.Warn("limiting the address space of the engines instead of their memory since --cgroup is not given")
+	})
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	limit := syscall.Rlimit{Cur: uint64(limit_mb) << 20, Max: uint64(limit_mb) << 20}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(cmd.Process.Pid), syscall.RLIMIT_AS,
+		uintptr(unsafe.Pointer(&limit)), 0, 0, 0)
+	if errno != 0 {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errno
+	}
+	return nil
+}
+
+// processRss returns the resident memory of the process pid in bytes.
+func processRss(pid int) (int64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "VmRSS:"); ok {
+			kb, err := strconv.ParseInt(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "kB")), 10, 64)
+			return kb << 10, err
+		}
+	}
+	return 0, fmt.Errorf("no VmRSS in /proc/%d/status", pid)
+}
+
+// totalMemory returns the physical memory in bytes.
+func totalMemory() (int64, error) {
+	var info syscall.Sysinfo_t
+	if err := syscall.Sysinfo(&info); err != nil {
+		return 0, err
+	}
+	return int64(info.Totalram) * int64(info.Unit), nil
+}
@@ -0,0 +1,35 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+func setPriority(pid int, nice int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, nice)
+}
+
+func checkLimits(cgroup string, limit_mb int, cpus float64) error {
+	if cgroup != "" || limit_mb > 0 || cpus > 0 {
+		return errors.New("memory and CPU limits are not supported on this platform")
+	}
+	return nil
+}
+
+func startLimited(cmd *exec.Cmd, limits engineLimits) error {
+	if err := checkLimits(limits.cgroup, limits.memory_mb, limits.cpus); err != nil {
+		return err
+	}
+	return cmd.Start()
+}
+
+func processRss(pid int) (int64, error) {
+	return 0, errors.New("memory monitoring is not supported on this platform")
+}
+
+func totalMemory() (int64, error) {
+	return 0, errors.New("the physical memory is unknown on this platform")
+}
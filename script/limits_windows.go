@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procSetPriorityClass         = kernel32.NewProc("SetPriorityClass")
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procGetProcessMemoryInfo     = syscall.NewLazyDLL("psapi.dll").NewProc("GetProcessMemoryInfo")
+	procGlobalMemoryStatusEx     = kernel32.NewProc("GlobalMemoryStatusEx")
+)
+
+const (
+	process_set_quota         = 0x0100
+	process_terminate         = 0x0001
+	process_set_information   = 0x0200
+	process_query_information = 0x0400
+	process_vm_read           = 0x0010
+
+	above_normal_priority_class = 0x8000
+	below_normal_priority_class = 0x4000
+	idle_priority_class         = 0x40
+
+	job_object_extended_limit_information   = 9
+	job_object_cpu_rate_control_information = 15
+	job_object_limit_process_memory         = 0x100
+	job_object_cpu_rate_control_enable      = 0x1
+	job_object_cpu_rate_control_hard_cap    = 0x4
+)
+
+type jobObjectExtendedLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+	IoInfo                  [6]uint64
+	ProcessMemoryLimit      uintptr
+	JobMemoryLimit          uintptr
+	PeakProcessMemoryUsed   uintptr
+	PeakJobMemoryUsed       uintptr
+}
+
+type jobObjectCpuRateControlInformation struct {
+	ControlFlags uint32
+	// CpuRate is the share of the CPU time of all processors in 1/10000
+	CpuRate uint32
+}
+
+// setPriority sets the priority class of the process pid corresponding to the nice value:
+// idle for 10 or more, below normal for positive values and above normal for negative ones.
+func setPriority(pid int, nice int) error {
+	class := above_normal_priority_class
+	switch {
+	case nice >= 10:
+		class = idle_priority_class
+	case nice > 0:
+		class = below_normal_priority_class
+	case nice == 0:
+		return nil
+	}
+
+	handle, err := syscall.OpenProcess(process_set_information, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(handle)
+	if ret, _, err := procSetPriorityClass.Call(uintptr(handle), uintptr(class)); ret == 0 {
+		return err
+	}
+	return nil
+}
+
+// setLimits limits the committed memory of the process pid to limit_mb MB and its CPU time to
+// cpus CPUs, either of which is unlimited if 0, by assigning it to a job object.
+func setLimits(pid int, limit_mb int, cpus float64) error {
+	job, _, err := procCreateJobObjectW.Call(0, 0)
+	if job == 0 {
+		return err
+	}
+	// the job lives while the process is assigned to it
+	defer syscall.CloseHandle(syscall.Handle(job))
+
+	if limit_mb > 0 {
+		info := jobObjectExtendedLimitInformation{
+			LimitFlags:         job_object_limit_process_memory,
+			ProcessMemoryLimit: uintptr(limit_mb) << 20,
+		}
+		if ret, _, err := procSetInformationJobObject.Call(job, job_object_extended_limit_information,
+			uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info)); ret == 0 {
+			return err
+		}
+	}
+	if cpus > 0 {
+		info := jobObjectCpuRateControlInformation{
+			ControlFlags: job_object_cpu_rate_control_enable | job_object_cpu_rate_control_hard_cap,
+			CpuRate:      uint32(min(max(cpus/float64(runtime.NumCPU())*10000, 1), 10000)),
+		}
+		if ret, _, err := procSetInformationJobObject.Call(job, job_object_cpu_rate_control_information,
+			uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info)); ret == 0 {
+			return err
+		}
+	}
+
+	handle, err := syscall.OpenProcess(process_set_quota|process_terminate, false, uint32(pid))
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(handle)
+	if ret, _, err := procAssignProcessToJobObject.Call(job, uintptr(handle)); ret == 0 {
+		return err
+	}
+	return nil
+}
+
+// checkLimits checks that the limits can be applied to the engines, which are assigned to job
+// objects instead of cgroups on Windows.
+func checkLimits(cgroup string, limit_mb int, cpus float64) error {
+	if cgroup != "" {
+		return errors.New("--cgroup is supported only on Linux")
+	}
+	return nil
+}
+
+// startLimited starts cmd and applies limits to it with setLimits right after the start.
+func startLimited(cmd *exec.Cmd, limits engineLimits) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if limits.memory_mb == 0 && limits.cpus == 0 {
+		return nil
+	}
+	if err := setLimits(cmd.Process.Pid, limits.memory_mb, limits.cpus); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	return nil
+}
+
+type processMemoryCounters struct {
+	Cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// processRss returns the working set of the process pid in bytes.
+func processRss(pid int) (int64, error) {
+	handle, err := syscall.OpenProcess(process_query_information|process_vm_read, false, uint32(pid))
+	if err != nil {
+		return 0, err
+	}
+	defer syscall.CloseHandle(handle)
+
+	var counters processMemoryCounters
+	counters.Cb = uint32(unsafe.Sizeof(counters))
+	if ret, _, err := procGetProcessMemoryInfo.Call(uintptr(handle), uintptr(unsafe.Pointer(&counters)), uintptr(counters.Cb)); ret == 0 {
+		return 0, err
+	}
+	return int64(counters.WorkingSetSize), nil
+}
+
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
+}
+
+// totalMemory returns the physical memory in bytes.
+func totalMemory() (int64, error) {
+	var status memoryStatusEx
+	status.Length = uint32(unsafe.Sizeof(status))
+	if ret, _, err := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&status))); ret == 0 {
+		return 0, err
+	}
+	return int64(status.TotalPhys), nil
+}
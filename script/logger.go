@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// carriageReturnWriter starts each record at the beginning of the line so that log messages
+// overwrite the progress bar instead of being appended to it.
+type carriageReturnWriter struct {
+	w io.Writer
+}
+
+func (c carriageReturnWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(c.w, "\r"); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
+
+// setupLogger writes log messages of level or above into stderr. Carriage returns are
+// written only while the progress bar is shown.
+func setupLogger(level string, over_bar bool) error {
+	l, ok := logLevels[level]
+	if !ok {
+		return fmt.Errorf("unknown log level: %s", level)
+	}
+
+	var w io.Writer = os.Stderr
+	if over_bar {
+		w = carriageReturnWriter{w}
+	}
+	handler := slog.NewTextHandler(w, &slog.HandlerOptions{Level: l})
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// problemLabel identifies problem in log messages by its ID, or its position if it has none.
+func problemLabel(problem Problem) string {
+	if problem.ID != "" {
+		return problem.ID
+	}
+
+	return problemKey(problem.Sfen, problem.Moves)
+}
@@ -1,56 +1,416 @@
+// Command mate solves the tsume shogi positions in INPUT with the engine ENGINE over USI and
+// reports the results. Run it in this directory with
+//
+//	go run . [flags] ENGINE [INPUT...]
 package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/rand"
 	"os"
 	"os/exec"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/schollz/progressbar"
-
 	flag "github.com/spf13/pflag"
 )
 
 type Options struct {
+	Hash            string
+	HashFraction    float64
 	HashSize        int
 	PostSearchCount int
 	DepthLimit      int
 	TimeLimit       int
+	NodesLimit      int64
+	PortfolioArgs   []string
+	Portfolio       []PortfolioConfig
+	PortfolioLimit  int
+	AdaptiveFactor  float64
+	AdaptivePercent float64
+	Adaptive        *AdaptiveTimeLimit
+	TotalTimeLimit  int
+	DeadlineArg     string
+	Deadline        *Deadline
 	OutFile         string
+	Append          bool
+	ProcessArg      string
 	Process         int
+	NoDedup         bool
+	Filters         []string
+	Sample          int
+	Seed            int64
+	Checkpoint      string
+	CheckpointEvery int
+	Resume          bool
+	Cache           string
+	NoCache         bool
+	Watch           string
+	Interactive     bool
+	Sfen            string
+	NoValidate      bool
+	OutFormat       string
+	LogDir          string
+	LogFailuresOnly bool
+	LogLevel        string
+	Histogram       bool
+	HistogramSvg    string
+	FailOnUnsolved  bool
+	MinSolveRate    float64
+	FailOnMismatch  bool
+	FailFast        bool
+	Quiet           bool
+	Progress        string
+	NoColor         bool
+	ResultsDB       string
+	Labels          map[string]string
+	Cook            bool
+	CookTimeLimit   int
+	CheckMateCount  bool
+	MateCountLimit  int
+	MudaaiLimit     int
+	HashSweep       []int
+	PostSearchSweep []int
+	SweepUnexpected bool
+	VerifyEngine    string
+	Baseline        string
+	Slowdown        float64
+	ReproduceDir    string
+	ReproduceHand   bool
+	RetryNoPv       int
+	Retry           int
+	RetryFactor     float64
+	CheckDefense    bool
+	DefenseLimit    int
+	DefenseMargin   int
+	DefenseMoves    int
+	Answers         string
+	MaxRestarts     int
+	HangTimeout     int
+	StopGrace       int
+	OptionArgs      []string
+	EngineOptions   []EngineOption
+	StrictOptions   bool
+	EngineDir       string
+	Compare         []string
+	Race            string
+	RaceOptionArgs  []string
+	Repeat          int
+	Interleave      bool
+	NewGame         string
+	NewGameEvery    int
+	Warmup          string
+	WarmupProblems  []Problem
+	PinCpus         int
+	Order           string
+	TwoPhase        bool
+	Stream          bool
+	Split           bool
+	SecondHash      int
+	SecondTimeLimit int
+	Difficulty      string
+	Nice            int
+	MemoryLimit     int
+	CpuLimit        float64
+	Cgroup          string
+	MaxRss          int
+	Engine          string
+	Inputs          []string
+	KifDir          string
+	CsaDir          string
+	Ki2Dir          string
 }
 
 func parseOptions() Options {
-	hash_size := flag.IntP("hash", "h", 64, "the size of hash (MB)")
+	hash_size := flag.StringP("hash", "h", "64", "the size of hash (MB), or \"auto\" to share --hash-fraction of the physical memory among the processes")
+	hash_fraction := flag.Float64("hash-fraction", 0.5, "the fraction of the physical memory used for the hash of all processes with --hash auto")
 	post_search_count := flag.IntP("post-search-count", "c", 0, "the number of post-search moves")
 	depth_limit := flag.IntP("mate-limit", "m", 0, "the maximum mate length")
 	time_limit := flag.IntP("time-limit", "t", 0, "the maximum time (msec)")
-	out_file := flag.StringP("out", "o", "", "the output file")
-	num_process := flag.IntP("process", "p", 4, "the number of process")
+	adaptive_time_limit := flag.Float64("adaptive-time-limit", 0, fmt.Sprintf("limit each position to the --adaptive-percentile of the solve times so far multiplied by F once %d positions are solved, within --time-limit (0: disable)", adaptiveWarmup))
+	adaptive_percentile := flag.Float64("adaptive-percentile", 99, "the percentile of the solve times for --adaptive-time-limit")
+	portfolio := flag.StringArray("portfolio", nil, "solve the positions which run out of the hash or the time again with an engine for each configuration at the same time, e.g. \"hash=4096,Threads=4\", taking the first verified answer (repeatable)")
+	portfolio_time_limit := flag.Int("portfolio-time-limit", 0, "the maximum time (msec) of --portfolio (0: --time-limit)")
+	nodes_limit := flag.Int64("nodes-limit", 0, "the maximum number of nodes searched, with NodesLimit of the engine if declared, or by stopping the search after an info line over the limit otherwise")
+	out_file := flag.StringP("out", "o", "", "the output file (unsolved positions are also written into <out>.unsolved, and mate lengths different from the expected ones into <out>.mismatch)")
+	append_out := flag.Bool("append", false, "append to the output files instead of overwriting them")
+	num_process := flag.StringP("process", "p", "auto", "the number of process, or \"auto\" for one per physical core (divided by Threads) leaving one core for the harness; SIGUSR1 and SIGUSR2 add and retire a worker while running")
+	no_dedup := flag.Bool("no-dedup", false, "solve duplicate positions again")
+	filters := flag.StringArray("filter", nil, filterHelp)
+	sample := flag.Int("sample", 0, "solve only N positions randomly chosen from the input")
+	seed := flag.Int64("seed", 0, "the random seed for --sample (0: choose randomly)")
+	checkpoint := flag.String("checkpoint", "", "record finished positions and their results into the file")
+	resume := flag.Bool("resume", false, "skip positions recorded in the checkpoint file, restoring their results")
+	checkpoint_interval := flag.Int("checkpoint-interval", 10, "write the checkpoint file to the disk every N seconds")
+	cache := flag.String("cache", defaultCachePath(), "the cache file of known results")
+	no_cache := flag.Bool("no-cache", false, "solve positions even if their results are cached")
+	watch := flag.String("watch", "", "keep solving problem files put into the directory until interrupted")
+	interactive := flag.BoolP("interactive", "i", false, "solve positions entered at a prompt")
+	sfen := flag.String("sfen", "", "solve only the position showing all engine output")
+	no_validate := flag.Bool("no-validate", false, "send positions to the engine without sanity checks")
+	out_format := flag.String("out-format", "text",
+		fmt.Sprintf("the format of the output file (%s)", strings.Join(outFormats, ", ")))
+	log_dir := flag.String("log-dir", "", "save the USI conversation for each position into a file in the directory")
+	log_failures_only := flag.Bool("log-failures-only", false,
+		"save transcripts only for positions which fail, time out or mismatch the expected answer")
+	log_level := flag.String("log-level", "info", "the minimum level of log messages (debug, info, warn, error)")
+	histogram := flag.Bool("histogram", false, "show the histogram of solve times in the summary")
+	histogram_svg := flag.String("histogram-svg", "", "write the histogram of solve times into the SVG file")
+	fail_on_unsolved := flag.Bool("fail-on-unsolved", false, "exit with 1 if any position is not solved")
+	min_solve_rate := flag.Float64("min-solve-rate", 0, "exit with 1 if the ratio of solved positions is below the value (0-1)")
+	fail_on_mismatch := flag.Bool("fail-on-mismatch", false, "exit with 1 if any answer mismatches the expected one")
+	fail_fast := flag.Bool("fail-fast", false, "stop all workers at the first wrong answer or engine crash")
+	quiet := flag.BoolP("quiet", "q", false, "show neither the progress nor failed positions")
+	progress := flag.String("progress", "bar",
+		fmt.Sprintf("how to show the progress (%s)", strings.Join(progressKinds, ", ")))
+	no_color := flag.Bool("no-color", false, "do not color failed positions in the terminal")
+	results_db := flag.String("results-db", "", "append the results into the SQLite database along with the run")
+	labels := flag.StringToString("label", nil, "attach a label key=value to the results of the run (can be repeated)")
+	cook := flag.Bool("cook", false, "search for other first moves which also lead to a mate (余詰) after solving")
+	cook_time_limit := flag.Int("cook-time-limit", 1000, "time limit in ms to search each first move for cooks")
+	check_mate_count := flag.Bool("check-mate-count", false, "check that the mate length decreases by one per ply along the pv")
+	mudaai_time_limit := flag.Int("mudaai-time-limit", 1000, "time limit in ms to search the position after each interposition captured in the pv for whether it is useless (0: do not report useless interpositions)")
+	mate_count_time_limit := flag.Int("mate-count-time-limit", 1000, "time limit in ms to search each position along the pv for --check-mate-count")
+	hash_sweep := flag.IntSlice("hash-sweep", nil, "solve each position again with these hash sizes (MB) and report positions whose result changes")
+	post_search_sweep := flag.IntSlice("post-search-sweep", nil, "solve each position again with these post-search counts and report positions whose result changes")
+	sweep_unexpected := flag.Bool("sweep-unexpected-only", false, "run the sweeps only for unexpected results")
+	verify_engine := flag.String("verify-engine", "", "cross-check every answer with another engine and report disagreements")
+	baseline := flag.String("baseline", "", "compare the results with a previous results file (json or csv) and report the changes")
+	slowdown := flag.Float64("slowdown-threshold", 2.0, "report positions solved slower than the baseline by this factor (0: disable)")
+	reproduce_dir := flag.String("reproduce-dir", "", "shrink the setting of each wrong answer and write a bug report into the directory")
+	reproduce_hand := flag.Bool("reproduce-hand", false, "also remove pieces from the defender's hand while shrinking wrong answers")
+	retry_no_pv := flag.Int("retry-no-pv", 0, "retry positions failing with \"Failed to detect PV\" up to N times with a larger hash and another PvInterval")
+	check_defense := flag.Bool("check-defense", false, "check that no other defender move in the pv holds longer against the mate")
+	defense_time_limit := flag.Int("defense-time-limit", 1000, "time limit in ms to search each alternative defender move for --check-defense")
+	defense_margin := flag.Int("defense-margin", 4, "report an alternative defender move for --check-defense only if it holds at least N plies longer, since the mates found after it need not be the shortest")
+	defense_moves := flag.Int("defense-moves", 16, "the maximum number of alternative defender moves searched at each ply for --check-defense, moves of pieces on the board before drops")
+	answers := flag.String("answers", "", "the answer database (JSON Lines of sfen, mate_len, pv and collection) to verify the answers against")
+	max_restarts := flag.Int("max-restarts", 3, "the maximum number of restarts of a crashed engine per position")
+	stop_grace := flag.Int("stop-grace", 5000, "time in ms to wait for the engine to stop before terminating it, and then before killing it")
+	hang_timeout := flag.Int("hang-timeout", 0, "kill and restart the engine if it outputs nothing for N seconds while solving, relying on the info lines printed every PvInterval, which is set to a quarter of N unless given with --option (0: disable)")
+	engine_options := flag.StringArray("option", nil, "set a USI option of the engine, e.g. --option Threads=4 (repeatable; {worker} in the value is replaced by the worker number)")
+	engine_dir := flag.String("engine-dir", "", "the working directory of the engines, where {worker} is replaced by the worker number, e.g. work/{worker}")
+	strict_options := flag.Bool("strict-options", false, "fail instead of warning if an option is not declared by the engine")
+	compare := flag.StringArray("compare", nil, "also solve every position with another engine command and print a side-by-side comparison (repeatable)")
+	race := flag.String("race", "", "race the engine against another engine command on every position and report the winners and the speedup")
+	race_options := flag.StringArray("race-option", nil, "set a USI option only of the second engine of --race, e.g. to race two option sets of the same engine (repeatable)")
+	repeat := flag.Int("repeat", 1, "solve every position N times and report the mean, the minimum and the standard deviation of the time and the nodes, or use the median time with --compare and --race")
+	interleave := flag.Bool("interleave", false, "solve all positions once per round with --repeat instead of solving each position N times in a row")
+	new_game := flag.String("new-game", "never", "when to send usinewgame: \"always\" before every position, \"never\", or every N positions of each engine; it only affects engines which handle usinewgame, which KomoringHeights ignores")
+	warmup := flag.String("warmup", "", "solve the positions in the file with each engine before the run, excluding them from the results")
+	pin_cpus := flag.Int("pin-cpus", 0, "bind the engines of each worker to N CPUs of their own (0: disable)")
+	nice := flag.Int("nice", 0, "run the engines with the nice value N (Windows: below normal priority if positive, idle if 10 or more)")
+	memory_limit := flag.Int("memory-limit", 0, "limit the memory of each engine process to N MB so that a runaway engine fails alone, with --cgroup on Linux, or the address space without it, and job objects on Windows (0: no limit)")
+	cpu_limit := flag.Float64("cpu-limit", 0, "limit the CPU time of each engine process to N CPUs, e.g. 1.5, with --cgroup on Linux and job objects on Windows (0: no limit)")
+	cgroup := flag.String("cgroup", "", "a cgroup v2 delegated to the user (relative to /sys/fs/cgroup unless absolute) with the memory and cpu controllers enabled for its children, under which each engine starts in a cgroup of its own for --memory-limit and --cpu-limit on Linux")
+	max_rss := flag.Int("max-rss", 0, "kill and restart an engine whose resident memory exceeds N MB while solving (0: no limit)")
+	order := flag.String("order", orderInput, "the order to solve the positions: input, or hardest-first by the difficulty of each position and the times of --difficulty")
+	difficulty := flag.String("difficulty", "", "a previous results file (json or csv) whose times estimate the difficulty of the positions for --order hardest-first")
+	split := flag.Bool("split", false, "solve each position by solving the positions after its checking moves on all workers at the same time, for a few very hard positions")
+	stream := flag.Bool("stream", false, "read the input files while solving instead of loading them at first, for inputs too large for the memory")
+	two_phase := flag.Bool("two-phase", false, "solve all positions quickly with --hash and --time-limit, and then the positions which ran out of them with --second-hash and --second-time-limit")
+	second_hash := flag.Int("second-hash", 0, "the size of hash (MB) in the second phase of --two-phase (0: 4 times the hash of the first phase)")
+	second_time_limit := flag.Int("second-time-limit", 0, "the maximum time (msec) in the second phase of --two-phase (0: no limit)")
+	retry := flag.Int("retry", 0, "retry positions which run out of the hash or the time up to N times, multiplying both of them by --retry-factor for each attempt")
+	retry_factor := flag.Float64("retry-factor", 2, "the factor of the hash size and the time limit for each attempt of --retry")
+	total_time_limit := flag.Int("total-time-limit", 0, "stop giving new positions to the engines after N seconds from the start, finishing the positions being solved and skipping the rest (0: no limit)")
+	deadline := flag.String("deadline", "", "finish the run by the time (RFC 3339, \"YYYY-MM-DD hh:mm\" or \"hh:mm\"), shortening the time limit of each position to its share of the time left and skipping the positions left at the time")
+	config := flag.String("config", "", "read default values of the options, the engine and the inputs from a TOML file")
+	profile := flag.String("profile", "", "apply the named profile ([profile.NAME]) of the config file")
+	kif_dir := flag.String("kif-dir", "", "write the solution of each solved position into a KIF file in the directory")
+	csa_dir := flag.String("csa-dir", "", "write the solution of each solved position into a CSA file in the directory")
+	ki2_dir := flag.String("ki2-dir", "", "write the solution of each solved position into a KI2 file in the directory")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: go run . [flags] ENGINE [INPUT...]")
+		fmt.Fprintln(os.Stderr, "       go run . [flags] [INPUT...] -- ENGINE [ARG...]")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
+	var engine string
+	var inputs []string
+	if *config != "" {
+		c, err := loadConfig(*config)
+		if err == nil {
+			engine, inputs, err = c.Apply(*profile)
+		}
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+	} else if *profile != "" {
+		fmt.Println("error: --profile requires --config")
+		os.Exit(1)
+	}
+
 	return Options{
-		HashSize:        *hash_size,
+		Hash:            *hash_size,
+		HashFraction:    *hash_fraction,
 		PostSearchCount: *post_search_count,
 		DepthLimit:      *depth_limit,
 		TimeLimit:       *time_limit,
+		NodesLimit:      *nodes_limit,
+		PortfolioArgs:   *portfolio,
+		PortfolioLimit:  *portfolio_time_limit,
+		AdaptiveFactor:  *adaptive_time_limit,
+		AdaptivePercent: *adaptive_percentile,
+		TotalTimeLimit:  *total_time_limit,
+		DeadlineArg:     *deadline,
 		OutFile:         *out_file,
-		Process:         *num_process,
+		Append:          *append_out,
+		ProcessArg:      *num_process,
+		NoDedup:         *no_dedup,
+		Filters:         *filters,
+		Sample:          *sample,
+		Seed:            *seed,
+		Checkpoint:      *checkpoint,
+		CheckpointEvery: *checkpoint_interval,
+		Resume:          *resume,
+		Cache:           *cache,
+		NoCache:         *no_cache,
+		Watch:           *watch,
+		Interactive:     *interactive,
+		Sfen:            *sfen,
+		NoValidate:      *no_validate,
+		OutFormat:       *out_format,
+		LogDir:          *log_dir,
+		LogFailuresOnly: *log_failures_only,
+		LogLevel:        *log_level,
+		Histogram:       *histogram,
+		HistogramSvg:    *histogram_svg,
+		FailOnUnsolved:  *fail_on_unsolved,
+		MinSolveRate:    *min_solve_rate,
+		FailOnMismatch:  *fail_on_mismatch,
+		FailFast:        *fail_fast,
+		Quiet:           *quiet,
+		Progress:        *progress,
+		NoColor:         *no_color,
+		ResultsDB:       *results_db,
+		Labels:          *labels,
+		Cook:            *cook,
+		CookTimeLimit:   *cook_time_limit,
+		CheckMateCount:  *check_mate_count,
+		MateCountLimit:  *mate_count_time_limit,
+		MudaaiLimit:     *mudaai_time_limit,
+		HashSweep:       *hash_sweep,
+		PostSearchSweep: *post_search_sweep,
+		SweepUnexpected: *sweep_unexpected,
+		VerifyEngine:    *verify_engine,
+		Baseline:        *baseline,
+		Slowdown:        *slowdown,
+		ReproduceDir:    *reproduce_dir,
+		ReproduceHand:   *reproduce_hand,
+		RetryNoPv:       *retry_no_pv,
+		Retry:           *retry,
+		RetryFactor:     *retry_factor,
+		CheckDefense:    *check_defense,
+		DefenseLimit:    *defense_time_limit,
+		DefenseMargin:   *defense_margin,
+		DefenseMoves:    *defense_moves,
+		Answers:         *answers,
+		MaxRestarts:     *max_restarts,
+		HangTimeout:     *hang_timeout,
+		StopGrace:       *stop_grace,
+		OptionArgs:      *engine_options,
+		StrictOptions:   *strict_options,
+		EngineDir:       *engine_dir,
+		Compare:         *compare,
+		Race:            *race,
+		RaceOptionArgs:  *race_options,
+		Repeat:          *repeat,
+		Interleave:      *interleave,
+		NewGame:         *new_game,
+		Warmup:          *warmup,
+		PinCpus:         *pin_cpus,
+		Order:           *order,
+		TwoPhase:        *two_phase,
+		Stream:          *stream,
+		Split:           *split,
+		SecondHash:      *second_hash,
+		SecondTimeLimit: *second_time_limit,
+		Difficulty:      *difficulty,
+		Nice:            *nice,
+		MemoryLimit:     *memory_limit,
+		CpuLimit:        *cpu_limit,
+		Cgroup:          *cgroup,
+		MaxRss:          *max_rss,
+		Engine:          engine,
+		Inputs:          inputs,
+		KifDir:          *kif_dir,
+		CsaDir:          *csa_dir,
+		Ki2Dir:          *ki2_dir,
 	}
 }
 
 type EngineProcess struct {
-	cmd     *exec.Cmd
-	stdin   io.WriteCloser
-	stdout  io.ReadCloser
-	scanner *bufio.Scanner
+	cmd         *exec.Cmd
+	stdin       io.WriteCloser
+	stdout      io.ReadCloser
+	scanner     *bufio.Scanner
+	hash_size   int
+	depth_limit int
+	nodes_limit int64
+	options     map[string]string
+	declared    []string
+	on_line     func(string)
+	transcript  *Transcript
+	abort       <-chan struct{}
+	// logger logs the lines written to stderr by the engine.
+	logger *slog.Logger
+	// mu guards transcript against the goroutine reading stderr.
+	mu sync.Mutex
+	// hang_timeout is the time without any output after which the engine is regarded as hung.
+	hang_timeout time.Duration
+	last_output  atomic.Int64
+	// max_rss is the resident memory in bytes above which the engine is killed (0: no limit).
+	max_rss int64
+	// stop_grace is the time to wait for the engine to answer stop before terminating it.
+	stop_grace time.Duration
+	// killed is set when the engine is terminated by stop, and the process must be restarted.
+	killed bool
+}
+
+// engineLimits are the resources which an engine process may use, where 0 means unlimited.
+type engineLimits struct {
+	// cgroup is the cgroup v2 under which a cgroup is created for each engine, or "" if none
+	cgroup    string
+	memory_mb int
+	cpus      float64
 }
 
 func newEngineProcess(command string) (*EngineProcess, error) {
-	cmd := exec.Command(command)
+	return newEngineProcessIn(command, "", engineLimits{})
+}
+
+// newEngineProcessIn starts command with limits in the working directory dir, or in the current
+// directory if dir is "".
+func newEngineProcessIn(command string, dir string, limits engineLimits) (*EngineProcess, error) {
+	args, err := splitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		// relative paths of the engine are relative to the current directory, not to dir
+		if args[0], err = absCommandPath(args[0]); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		return nil, err
@@ -62,21 +422,133 @@ func newEngineProcess(command string) (*EngineProcess, error) {
 	}
 	scanner := bufio.NewScanner(stdout)
 
-	err = cmd.Start()
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = startLimited(cmd, limits)
 	if err != nil {
 		return nil, err
 	}
 
-	return &EngineProcess{cmd, stdin, stdout, scanner}, nil
+	ep := &EngineProcess{cmd: cmd, stdout: stdout, scanner: scanner, logger: slog.Default()}
+	ep.stdin = &engineInput{WriteCloser: stdin, ep: ep}
+	go ep.readStderr(stderr)
+	return ep, nil
+}
+
+// readStderr logs the lines written to stderr by the engine, e.g. assertion failures and
+// backtraces, and records them into the transcript with "!".
+func (ep *EngineProcess) readStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		text := scanner.Text()
+		ep.mu.Lock()
+		ep.transcript.Record("!", text)
+		logger := ep.logger
+		ep.mu.Unlock()
+		logger.Warn("engine stderr", "pid", ep.cmd.Process.Pid, "line", text)
+	}
+}
+
+// SetTranscript sets the transcript into which the conversation with the engine is recorded.
+func (ep *EngineProcess) SetTranscript(transcript *Transcript) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	ep.transcript = transcript
+}
+
+// Usi performs the "usi" handshake and returns the name and the author of the engine. The
+// names of the declared options are kept in ep.declared.
+func (ep *EngineProcess) Usi() (name string, author string, err error) {
+	fmt.Fprintln(ep.stdin, "usi")
+
+	for ep.scanner.Scan() {
+		text := ep.scanner.Text()
+		ep.transcript.Record("<", text)
+		switch {
+		case strings.HasPrefix(text, "id name "):
+			name = strings.TrimPrefix(text, "id name ")
+		case strings.HasPrefix(text, "id author "):
+			author = strings.TrimPrefix(text, "id author ")
+		case strings.HasPrefix(text, "option name "):
+			if fields := strings.Fields(text); len(fields) >= 3 {
+				ep.declared = append(ep.declared, fields[2])
+			}
+		case text == "usiok":
+			return name, author, nil
+		}
+	}
+
+	if err := ep.scanner.Err(); err != nil {
+		return "", "", err
+	}
+
+	return "", "", fmt.Errorf("got no \"usiok\"")
+}
+
+func (ep *EngineProcess) Quit() error {
+	fmt.Fprintln(ep.stdin, "quit")
+	ep.stdin.Close()
+	return ep.cmd.Wait()
 }
 
-func (ep *EngineProcess) SetOption(op Options) {
+// SetOption performs the "usi" handshake and sets the options of op. The default options are
+// set only if the engine declares them.
+func (ep *EngineProcess) SetOption(op Options) error {
+	if _, _, err := ep.Usi(); err != nil {
+		return err
+	}
 	fmt.Fprintf(ep.stdin, "setoption name USI_Hash value %d\n", op.HashSize)
 	fmt.Fprintf(ep.stdin, "setoption name PostSearchCount value %d\n", op.PostSearchCount)
 	fmt.Fprintf(ep.stdin, "setoption name DepthLimit value %d\n", op.DepthLimit)
-	fmt.Fprintf(ep.stdin, "setoption name RootIsAndNodeIfChecked value false\n")
-	fmt.Fprintf(ep.stdin, "setoption name PvInterval value 0\n")
-	fmt.Fprintf(ep.stdin, "setoption name YozumePrintLevel value 0\n")
+	ep.options = make(map[string]string)
+	for _, option := range engineOptions(op.EngineOptions, ep.declared) {
+		fmt.Fprintf(ep.stdin, "setoption name %s value %s\n", option.Name, option.Value)
+		ep.options[option.Name] = option.Value
+	}
+	ep.hash_size = op.HashSize
+	ep.depth_limit = op.DepthLimit
+	ep.nodes_limit = op.NodesLimit
+	return nil
+}
+
+// RestoreOption sets the option name back to the value given by SetOption.
+func (ep *EngineProcess) RestoreOption(name string) {
+	if value, ok := ep.options[name]; ok {
+		fmt.Fprintf(ep.stdin, "setoption name %s value %s\n", name, value)
+	}
+}
+
+func (ep *EngineProcess) ApplyProblemOptions(op Options, problem Problem) error {
+	depth_limit := op.DepthLimit
+	if problem.DepthLimit != nil {
+		depth_limit = *problem.DepthLimit
+	}
+	if depth_limit != ep.depth_limit {
+		fmt.Fprintf(ep.stdin, "setoption name DepthLimit value %d\n", depth_limit)
+		ep.depth_limit = depth_limit
+	}
+
+	hash_size := op.HashSize
+	if problem.HashSize != nil {
+		hash_size = *problem.HashSize
+	}
+	if hash_size != ep.hash_size {
+		fmt.Fprintf(ep.stdin, "setoption name USI_Hash value %d\n", hash_size)
+		ep.hash_size = hash_size
+		return ep.Ready()
+	}
+
+	return nil
+}
+
+// NewGame sends usinewgame. It only affects engines which handle usinewgame, e.g. by clearing
+// their hash, whereas KomoringHeights ignores it.
+func (ep *EngineProcess) NewGame() error {
+	fmt.Fprintln(ep.stdin, "usinewgame")
+	return ep.Ready()
 }
 
 func (ep *EngineProcess) Ready() error {
@@ -84,6 +556,7 @@ func (ep *EngineProcess) Ready() error {
 
 	for ep.scanner.Scan() {
 		text := ep.scanner.Text()
+		ep.transcript.Record("<", text)
 		if strings.Contains(text, "readyok") {
 			return nil
 		}
@@ -97,167 +570,1923 @@ func (ep *EngineProcess) Ready() error {
 	return fmt.Errorf("got no \"readyok\"")
 }
 
-func (ep *EngineProcess) solveImpl(sfen string) error {
-	fmt.Fprintf(ep.stdin, "sfen %s\n", sfen)
+var (
+	errNoMate      = errors.New("got nomate")
+	errNoPv        = errors.New("Failed to detect PV")
+	errNoMateMoves = errors.New("got checkout without mate moves")
+	errTimeout     = errors.New("timeout")
+	errTimeLimit   = errors.New("time limit exceeded")
+	errNodesLimit  = errors.New("nodes limit exceeded")
+	errAborted     = errors.New("aborted")
+	errSkipped     = errors.New("skipped for lack of time")
+	errCrashed     = errors.New("the engine crashed")
+	errHung        = fmt.Errorf("%w: no output", errCrashed)
+	errMemory      = fmt.Errorf("%w: too much memory", errCrashed)
+)
+
+type Result struct {
+	Problem  Problem
+	Pv       []string
+	Err      error
+	Time     time.Duration
+	MirrorOf *Problem
+	Cached   bool
+	Nodes    int64
+	Nps      int64
+	Hashfull int
+	Score    string
+	// Surplus is the pieces left in the hand of the attacker at the mate, e.g. "G2P" (駒余り).
+	Surplus string
+	Cooks   []Cook
+	// Interpositions are the useless interpositions (無駄合) played by the defender in Pv.
+	Interpositions []Interposition
+	// Sweeps are the outcomes of solving the position again with engine options changed.
+	Sweeps []SweepPoint
+	// CrossCheck is the answer of --verify-engine if it disagrees with the result.
+	CrossCheck *CrossCheck
+	// Retries is the number of retries after failing to detect the PV.
+	Retries int
+	// Attempt is the attempt of --retry which gave the result, counting the first one as 1.
+	Attempt int
+	// Portfolio is the configuration of --portfolio which solved the position.
+	Portfolio string
+	// Resumed is set if the result is restored from the checkpoint of a previous run.
+	Resumed bool
+	// Crashes is the number of crashes of the engine while solving the position.
+	Crashes int
+	// TimeLimit is the time limit (ms) given to the engine, which is shorter than --time-limit
+	// when --deadline or --adaptive-time-limit shortens it.
+	TimeLimit int
+}
+
+func (r Result) Status() string {
+	if errors.Is(r.Err, errSkipped) {
+		return "skipped"
+	}
+	if r.Err != nil {
+		return "failed"
+	}
+
+	return "solved"
+}
+
+// Unexpected returns true if r is a failure other than an expected nomate, or a mate which
+// does not match the expected answer.
+func (r Result) Unexpected() bool {
+	if r.Err != nil {
+		return !(r.Problem.NoMate && errors.Is(r.Err, errNoMate))
+	}
+
+	return r.Problem.HasExpectation() && r.Problem.CheckAnswer(r.Pv) != ""
+}
+
+// Fatal returns true if r is a wrong answer or a crash of the engine, i.e. a result which
+// aborts the run in --fail-fast mode. Timeouts and nomate without expectations are not.
+func (r Result) Fatal() bool {
+	switch r.Category() {
+	case "":
+		return r.Unexpected()
+	case "engine_error", "illegal_pv", "not_mate", "pawn_drop", "inconsistent_mate", "repetition", "non_optimal_defense":
+		return true
+	case "nomate":
+		return r.Unexpected() && r.Problem.HasExpectation()
+	default:
+		return false
+	}
+}
+
+// Category classifies the error of r into a short name for machine-readable outputs.
+func (r Result) Category() string {
+	switch {
+	case r.Err == nil:
+		return ""
+	case errors.Is(r.Err, errNoMate):
+		return "nomate"
+	case errors.Is(r.Err, errNoPv):
+		return "no_pv"
+	case errors.Is(r.Err, errNoMateMoves):
+		return "no_mate_moves"
+	case errors.Is(r.Err, errTimeout):
+		return "timeout"
+	case errors.Is(r.Err, errTimeLimit):
+		return "time_limit"
+	case errors.Is(r.Err, errNodesLimit):
+		return "nodes_limit"
+	case errors.Is(r.Err, errSkipped):
+		return "skipped"
+	case errors.Is(r.Err, errIllegalPv):
+		return "illegal_pv"
+	case errors.Is(r.Err, errNotMate):
+		return "not_mate"
+	case errors.Is(r.Err, errPawnDrop):
+		return "pawn_drop"
+	case errors.Is(r.Err, errInconsistentMate):
+		return "inconsistent_mate"
+	case errors.Is(r.Err, errRepetition):
+		return "repetition"
+	case errors.Is(r.Err, errNonOptimalDefense):
+		return "non_optimal_defense"
+	default:
+		return "engine_error"
+	}
+}
+
+// parseInfo stores the search statistics in an "info" line into res.
+func parseInfo(text string, res *Result) {
+	tokens := strings.Fields(text)
+	for i := 1; i+1 < len(tokens); i++ {
+		switch tokens[i] {
+		case "nodes":
+			if n, err := strconv.ParseInt(tokens[i+1], 10, 64); err == nil {
+				res.Nodes = n
+			}
+		case "nps":
+			if n, err := strconv.ParseInt(tokens[i+1], 10, 64); err == nil {
+				res.Nps = n
+			}
+		case "hashfull":
+			if n, err := strconv.Atoi(tokens[i+1]); err == nil {
+				res.Hashfull = n
+			}
+		case "score":
+			if i+2 < len(tokens) {
+				res.Score = tokens[i+1] + " " + tokens[i+2]
+			}
+		case "pv", "string":
+			return
+		}
+	}
+}
+
+func (ep *EngineProcess) solveImpl(problem Problem) Result {
+	fmt.Fprintln(ep.stdin, problem.Position())
 	fmt.Fprintln(ep.stdin, "go mate infinite")
 
+	var res Result
+	// the search is stopped here unless the engine stops by itself with NodesLimit
+	_, stopped := ep.options["NodesLimit"]
 	for ep.scanner.Scan() {
 		text := ep.scanner.Text()
+		ep.last_output.Store(time.Now().UnixNano())
+		ep.transcript.Record("<", text)
+		if ep.on_line != nil {
+			ep.on_line(text)
+		}
+		if strings.HasPrefix(text, "info ") {
+			parseInfo(text, &res)
+			if ep.nodes_limit > 0 && res.Nodes >= ep.nodes_limit && !stopped {
+				fmt.Fprintln(ep.stdin, "stop")
+				stopped = true
+			}
+		}
 		switch {
 		case strings.Contains(text, "nomate"):
-			return fmt.Errorf("got nomate")
+			res.Err = errNoMate
+			return res
 		case strings.Contains(text, "Failed to detect PV"):
-			return fmt.Errorf("Failed to detect PV")
+			res.Err = errNoPv
+			return res
 		case strings.Contains(text, "checkmate "):
 			if text == "checkmate " {
-				return fmt.Errorf("got checkout without mate moves")
+				res.Err = errNoMateMoves
+			} else if text == "checkmate timeout" && ep.nodes_limit > 0 {
+				// the search is stopped by NodesLimit or by us unless Solve says otherwise
+				res.Err = errNodesLimit
 			} else if text == "checkmate timeout" {
-				return fmt.Errorf("timeout")
+				res.Err = errTimeout
 			} else {
-				return nil
+				res.Pv = strings.Fields(strings.TrimPrefix(text, "checkmate "))
 			}
+			return res
 		}
 	}
 	err := ep.scanner.Err()
 	if err != nil {
-		return err
+		res.Err = fmt.Errorf("%w: %v", errCrashed, err)
+		return res
 	}
 
-	return fmt.Errorf("unexpected EOF")
+	res.Err = fmt.Errorf("%w: unexpected EOF", errCrashed)
+	return res
+}
+
+// Kill kills the engine process, e.g. after it crashed, and waits for it to exit.
+func (ep *EngineProcess) Kill() {
+	ep.stdin.Close()
+	ep.cmd.Process.Kill()
+	ep.cmd.Wait()
 }
 
-func (ep *EngineProcess) Solve(sfen string, time_limit_ms int) error {
-	if time_limit_ms == 0 {
-		return ep.solveImpl(sfen)
+// rssPollInterval is the interval to check the resident memory of the engine for --max-rss.
+const rssPollInterval = time.Second
+
+// stop stops the search running in the background and returns its result. If the engine
+// ignores stop for ep.stop_grace, it is terminated, and killed after another ep.stop_grace.
+func (ep *EngineProcess) stop(result <-chan Result) Result {
+	fmt.Fprintln(ep.stdin, "stop")
+	if ep.stop_grace == 0 {
+		return <-result
 	}
 
-	timer := time.NewTimer(time.Duration(time_limit_ms) * time.Millisecond)
-	result := make(chan error)
-	go func() {
-		err := ep.solveImpl(sfen)
-		result <- err
-	}()
+	grace := time.NewTimer(ep.stop_grace)
+	defer grace.Stop()
+	select {
+	case res := <-result:
+		return res
+	case <-grace.C:
+	}
 
+	slog.Warn("the engine ignored stop, terminating", "pid", ep.cmd.Process.Pid)
+	ep.killed = true
+	if err := ep.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		ep.cmd.Process.Kill()
+		return <-result
+	}
+	grace.Reset(ep.stop_grace)
 	select {
-	case <-timer.C:
-		fmt.Fprintln(ep.stdin, "stop")
-		<-result
-		ep.Ready()
-		return fmt.Errorf("time limit exceeded")
 	case res := <-result:
-		if !timer.Stop() {
-			<-timer.C
-		}
 		return res
+	case <-grace.C:
+	}
+
+	slog.Warn("the engine ignored SIGTERM, killing", "pid", ep.cmd.Process.Pid)
+	ep.cmd.Process.Kill()
+	return <-result
+}
+
+// Solve solves problem within time_limit_ms (0: no limit). The search is also stopped when
+// ep.abort is closed, and the engine is killed if it outputs nothing for ep.hang_timeout.
+func (ep *EngineProcess) Solve(problem Problem, time_limit_ms int) Result {
+	if time_limit_ms == 0 && ep.abort == nil && ep.hang_timeout == 0 && ep.max_rss == 0 {
+		return ep.solveImpl(problem)
+	}
+
+	var timeout <-chan time.Time
+	if time_limit_ms > 0 {
+		timer := time.NewTimer(time.Duration(time_limit_ms) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var watchdog <-chan time.Time
+	if ep.hang_timeout > 0 || ep.max_rss > 0 {
+		interval := rssPollInterval
+		if ep.hang_timeout > 0 && (ep.max_rss == 0 || ep.hang_timeout/4 < interval) {
+			interval = ep.hang_timeout / 4
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+	ep.last_output.Store(time.Now().UnixNano())
+	result := make(chan Result)
+	go func() {
+		result <- ep.solveImpl(problem)
+	}()
+
+	for {
+		select {
+		case <-watchdog:
+			if ep.max_rss > 0 {
+				if rss, err := processRss(ep.cmd.Process.Pid); err == nil && rss > ep.max_rss {
+					ep.cmd.Process.Kill()
+					res := <-result
+					return Result{Err: fmt.Errorf("%w (resident %d MB)", errMemory, rss>>20), Nodes: res.Nodes, Nps: res.Nps, Hashfull: res.Hashfull}
+				}
+			}
+			if ep.hang_timeout == 0 || time.Since(time.Unix(0, ep.last_output.Load())) < ep.hang_timeout {
+				continue
+			}
+			ep.cmd.Process.Kill()
+			res := <-result
+			return Result{Err: fmt.Errorf("%w for %v", errHung, ep.hang_timeout), Nodes: res.Nodes, Nps: res.Nps, Hashfull: res.Hashfull}
+		case <-timeout:
+			res := ep.stop(result)
+			if !ep.killed {
+				ep.Ready()
+			}
+			return Result{Err: errTimeLimit, Nodes: res.Nodes, Nps: res.Nps, Hashfull: res.Hashfull}
+		case <-ep.abort:
+			ep.stop(result)
+			return Result{Err: errAborted}
+		case res := <-result:
+			return res
+		}
+	}
+}
+
+func sampleProblems(problems []Problem, n int, seed int64) []Problem {
+	rng := rand.New(rand.NewSource(seed))
+	indices := rng.Perm(len(problems))[:n]
+	sort.Ints(indices)
+
+	sampled := make([]Problem, 0, n)
+	for _, i := range indices {
+		sampled = append(sampled, problems[i])
 	}
+
+	return sampled
 }
 
 type Summary struct {
+	total        int
+	solved       int
+	expected     int
+	matched      int
+	duplicates   int
+	mirrored     int
+	filtered     int
+	resumed      int
+	cached       int
+	invalid      int
+	false_mates  int
+	pawn_drops   int
+	inconsistent int
+	blunders     int
+	surplus      int
+	cooked       int
+	mudaai       int
+	sensitive    int
+	disagreed    int
+	retried      int
+	escalated    int
+	crashed      int
+	unsolved     int
+	skipped      int
+	mismatched   int
+	shorter      int
+	tags         map[string]*TagSummary
+	times        *TimeSample
+	nodes        int64
+	mate_lens    map[string]*TagSummary
+}
+
+type TagSummary struct {
 	total  int
 	solved int
+	time   time.Duration
 }
 
-func solve(
-	bar *progressbar.ProgressBar,
-	command string, op Options,
-	sfen_input chan string,
-	output_ch chan string,
-	summary_ch chan Summary) {
-	process, err := newEngineProcess(command)
-	if err != nil {
-		fmt.Println("error:", err)
-		os.Exit(1)
+func (t *TagSummary) Add(res Result) {
+	t.total += 1
+	if res.Err == nil {
+		t.solved += 1
 	}
-	process.SetOption(op)
-	err = process.Ready()
-	if err != nil {
-		fmt.Println("error:", err)
-		os.Exit(1)
+	t.time += res.Time
+}
+
+func (s *Summary) AddTags(res Result) {
+	if s.tags == nil {
+		s.tags = make(map[string]*TagSummary)
+	}
+	for _, tag := range res.Problem.Tags {
+		t, ok := s.tags[tag]
+		if !ok {
+			t = &TagSummary{}
+			s.tags[tag] = t
+		}
+		t.Add(res)
 	}
+}
 
-	total := 0
-	solved := 0
-	for sfen := range sfen_input {
-		total += 1
-		err := process.Solve(sfen, op.TimeLimit)
-		if err != nil {
-			output_ch <- fmt.Sprintf("%v: sfen %v", err, sfen)
-		} else {
-			solved += 1
+// summaryTable formats the rows of groups in the order of names.
+func summaryTable(header string, names []string, groups map[string]*TagSummary) string {
+	width := len(header)
+	for _, name := range names {
+		if len(name) > width {
+			width = len(name)
 		}
-		bar.Add(1)
 	}
 
-	summary_ch <- Summary{total, solved}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-*s  %13s  %10s  %10s\n", width, header, "solved/total", "avg time", "total time")
+	for _, name := range names {
+		t := groups[name]
+		fmt.Fprintf(&sb, "%-*s  %13s  %9.2fs  %9.2fs\n", width, name,
+			fmt.Sprintf("%d/%d", t.solved, t.total),
+			t.time.Seconds()/float64(t.total), t.time.Seconds())
+	}
+
+	return sb.String()
 }
 
-func main() {
-	op := parseOptions()
+func (s Summary) TagTable() string {
+	if len(s.tags) == 0 {
+		return ""
+	}
 
-	if flag.NArg() == 0 {
-		fmt.Println("error: solver command was not specified")
-		flag.Usage()
-		os.Exit(1)
+	names := make([]string, 0, len(s.tags))
+	for name := range s.tags {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	start := time.Now()
-	bar := progressbar.Default(-1)
+	return summaryTable("tag", names, s.tags)
+}
 
-	command := flag.Arg(0)
-	sfen_chan := make(chan string)
-	output_chan := make(chan string)
-	summary_chan := make(chan Summary)
-	for i := 0; i < op.Process; i++ {
-		go solve(bar, command, op, sfen_chan, output_chan, summary_chan)
+// ExitCode returns 1 if the results violate the policies given by the options.
+func (s Summary) ExitCode(op Options) int {
+	exit_code := 0
+	if op.FailOnUnsolved && s.unsolved > 0 {
+		slog.Error("some positions are not solved", "count", s.unsolved)
+		exit_code = 1
+	}
+	if op.FailOnMismatch && s.mismatched > 0 {
+		slog.Error("some answers mismatch the expected ones", "count", s.mismatched)
+		exit_code = 1
+	}
+	if op.MinSolveRate > 0 && s.total > 0 {
+		rate := float64(s.total-s.unsolved) / float64(s.total)
+		if rate < op.MinSolveRate {
+			slog.Error("the solve rate is below the minimum", "rate", rate, "min", op.MinSolveRate)
+			exit_code = 1
+		}
 	}
 
-	end := make(chan struct{}, 1)
-	go func() {
-		defer close(end)
+	return exit_code
+}
 
-		has_outfile := false
-		var outfile *os.File
-		defer outfile.Close()
-		if op.OutFile != "" {
-			file, err := os.Create(op.OutFile)
-			if err == nil {
-				has_outfile = true
-				outfile = file
+func (s Summary) String() string {
+	str := fmt.Sprintf("solved/total: %v/%v", s.solved, s.total)
+	if s.expected > 0 {
+		str += fmt.Sprintf("  matched/expected: %v/%v", s.matched, s.expected)
+	}
+	if s.shorter > 0 {
+		str += fmt.Sprintf("  SHORTER THAN EXPECTED: %v", s.shorter)
+	}
+	if s.false_mates > 0 {
+		str += fmt.Sprintf("  FALSE MATES: %v", s.false_mates)
+	}
+	if s.pawn_drops > 0 {
+		str += fmt.Sprintf("  PAWN DROP VIOLATIONS: %v", s.pawn_drops)
+	}
+	if s.blunders > 0 {
+		str += fmt.Sprintf("  NON-OPTIMAL DEFENSES: %v", s.blunders)
+	}
+	if s.inconsistent > 0 {
+		str += fmt.Sprintf("  INCONSISTENT MATE COUNTS: %v", s.inconsistent)
+	}
+	if s.crashed > 0 {
+		str += fmt.Sprintf("  ENGINE CRASHES: %v", s.crashed)
+	}
+	if s.retried > 0 {
+		str += fmt.Sprintf("  retried: %v", s.retried)
+	}
+	if s.skipped > 0 {
+		str += fmt.Sprintf("  SKIPPED: %v", s.skipped)
+	}
+	if s.escalated > 0 {
+		str += fmt.Sprintf("  solved on retry: %v", s.escalated)
+	}
+	if s.disagreed > 0 {
+		str += fmt.Sprintf("  DISAGREEMENTS: %v", s.disagreed)
+	}
+	if s.sensitive > 0 {
+		str += fmt.Sprintf("  OPTION SENSITIVE: %v", s.sensitive)
+	}
+	if s.cooked > 0 {
+		str += fmt.Sprintf("  cooked: %v", s.cooked)
+	}
+	if s.mudaai > 0 {
+		str += fmt.Sprintf("  useless interpositions: %v", s.mudaai)
+	}
+	if s.surplus > 0 {
+		str += fmt.Sprintf("  surplus: %v", s.surplus)
+	}
+	if s.duplicates > 0 {
+		str += fmt.Sprintf("  duplicates: %v", s.duplicates)
+	}
+	if s.mirrored > 0 {
+		str += fmt.Sprintf("  mirrored: %v", s.mirrored)
+	}
+	if s.filtered > 0 {
+		str += fmt.Sprintf("  filtered: %v", s.filtered)
+	}
+	if s.resumed > 0 {
+		str += fmt.Sprintf("  resumed: %v", s.resumed)
+	}
+	if s.cached > 0 {
+		str += fmt.Sprintf("  cached: %v", s.cached)
+	}
+	if s.invalid > 0 {
+		str += fmt.Sprintf("  invalid: %v", s.invalid)
+	}
+
+	return str
+}
+
+func solve(
+	worker int,
+	command string, op Options,
+	cache *ResultCache,
+	monitor *Monitor,
+	abort <-chan struct{},
+	retire <-chan struct{},
+	queue *Scheduler,
+	result_ch chan Result) {
+	logger := slog.With("worker", worker)
+	defer monitor.Stop(worker)
+	queue.Join(worker)
+	defer queue.Leave(worker)
+	op.EngineOptions = expandWorkerOptions(op.EngineOptions, worker)
+	engine_dir := expandWorker(op.EngineDir, worker)
+	limits := engineLimits{cgroup: op.Cgroup, memory_mb: op.MemoryLimit, cpus: op.CpuLimit}
+	// confine applies the CPU affinity and the priority to the engine
+	confine := func(ep *EngineProcess) {
+		pid := ep.cmd.Process.Pid
+		if op.PinCpus > 0 {
+			cpus := workerCpus(worker, op.PinCpus, runtime.NumCPU())
+			if err := setAffinity(pid, cpus); err != nil {
+				logger.Warn("failed to pin the engine to CPUs", "cpus", cpus, "error", err)
+			}
+		}
+		if op.Nice != 0 {
+			if err := setPriority(pid, op.Nice); err != nil {
+				logger.Warn("failed to set the priority of the engine", "nice", op.Nice, "error", err)
+			}
+		}
+	}
+	var process *EngineProcess
+	start_engine := func() {
+		var err error
+		process, err = newEngineProcessIn(command, engine_dir, limits)
+		if err != nil {
+			logger.Error("failed to start the engine", "error", err)
+			exit(1)
+		}
+		confine(process)
+		if err := process.SetOption(op); err != nil {
+			logger.Error("failed to set the options of the engine", "error", err)
+			exit(1)
+		}
+		err = process.Ready()
+		if err != nil {
+			logger.Error("the engine is not ready", "error", err)
+			exit(1)
+		}
+		logger.Debug("engine started", "command", command)
+		process.abort = abort
+		process.logger = logger
+		process.hang_timeout = time.Duration(op.HangTimeout) * time.Second
+		process.stop_grace = time.Duration(op.StopGrace) * time.Millisecond
+		process.max_rss = int64(op.MaxRss) << 20
+		process.on_line = func(text string) {
+			if strings.HasPrefix(text, "info ") {
+				monitor.Update(worker, text)
+			}
+		}
+	}
+	start_engine()
+	restart_engine := func() {
+		transcript := process.transcript
+		process.Kill()
+		start_engine()
+		process.SetTranscript(transcript)
+	}
+
+	var verifier *EngineProcess
+	start_verifier := func() {
+		var err error
+		verifier, err = newEngineProcessIn(op.VerifyEngine, engine_dir, limits)
+		if err != nil {
+			logger.Error("failed to start the verification engine", "error", err)
+			exit(1)
+		}
+		confine(verifier)
+		if err := verifier.SetOption(op); err != nil {
+			logger.Error("failed to set the options of the verification engine", "error", err)
+			exit(1)
+		}
+		if err := verifier.Ready(); err != nil {
+			logger.Error("the verification engine is not ready", "error", err)
+			exit(1)
+		}
+		verifier.abort = abort
+		verifier.logger = logger.With("engine", op.VerifyEngine)
+		verifier.hang_timeout = time.Duration(op.HangTimeout) * time.Second
+		verifier.stop_grace = time.Duration(op.StopGrace) * time.Millisecond
+		verifier.max_rss = int64(op.MaxRss) << 20
+	}
+	if op.VerifyEngine != "" {
+		start_verifier()
+		defer func() { verifier.Quit() }()
+	}
+	if len(op.WarmupProblems) > 0 {
+		logger.Debug("warming up", "positions", len(op.WarmupProblems))
+		for _, problem := range op.WarmupProblems {
+			if err := process.ApplyProblemOptions(op, problem); err != nil {
+				logger.Error("failed to set options", "error", err)
+				exit(1)
+			}
+			process.Solve(problem, op.TimeLimit)
+		}
+		if process.killed {
+			restart_engine()
+		}
+	}
+	reanalyze := op.Cook || op.CheckMateCount || op.MudaaiLimit > 0 || op.CheckDefense || len(op.HashSweep) > 0 || len(op.PostSearchSweep) > 0 ||
+		verifier != nil || op.ReproduceDir != ""
+
+	// analyze re-queries the engine about the solution of res
+	analyze := func(logger *slog.Logger, res Result) Result {
+		defer func() {
+			if op.ReproduceDir == "" || !isWrongAnswer(res) {
+				return
+			}
+			reproduction, err := process.Reproduce(res, process.hash_size, op.TimeLimit, op.ReproduceHand)
+			if err != nil {
+				logger.Error("failed to shrink the wrong answer", "error", err)
+			}
+			if err := writeBugReport(op.ReproduceDir, reproduction, command, op, process.declared); err != nil {
+				logger.Error("failed to write the bug report", "error", err)
+			}
+		}()
+		if verifier != nil {
+			cross_check, err := verifier.crossCheck(res, op.TimeLimit)
+			if err != nil {
+				logger.Error("failed to cross-check the answer", "error", err)
+			}
+			res.CrossCheck = cross_check
+		}
+		if len(op.HashSweep) > 0 && res.Category() != "engine_error" && (!op.SweepUnexpected || res.Unexpected()) {
+			points, err := process.Sweep(res.Problem, op.TimeLimit, "USI_Hash", op.HashSweep, process.hash_size)
+			if err != nil {
+				logger.Error("failed to sweep the hash size", "error", err)
+			}
+			res.Sweeps = append(res.Sweeps, points...)
+		}
+		if len(op.PostSearchSweep) > 0 && res.Category() != "engine_error" && (!op.SweepUnexpected || res.Unexpected()) {
+			points, err := process.Sweep(res.Problem, op.TimeLimit, "PostSearchCount", op.PostSearchSweep, op.PostSearchCount)
+			if err != nil {
+				logger.Error("failed to sweep the post-search count", "error", err)
+			}
+			res.Sweeps = append(res.Sweeps, points...)
+		}
+		if res.Err != nil {
+			return res
+		}
+		if op.MudaaiLimit > 0 {
+			interpositions, err := process.UselessInterpositions(res.Problem, res.Pv, op.MudaaiLimit)
+			if err != nil {
+				logger.Error("failed to check the interpositions", "error", err)
+			}
+			res.Interpositions = interpositions
+		}
+		if op.CheckMateCount {
+			err := process.CheckMateCount(res.Problem, res.Pv, op.MateCountLimit)
+			if errors.Is(err, errInconsistentMate) {
+				res.Err = err
+				return res
+			} else if err != nil {
+				logger.Error("failed to check the mate count", "error", err)
+			}
+		}
+		if op.CheckDefense {
+			err := process.CheckDefense(res.Problem, res.Pv, op.DefenseLimit, op.DefenseMargin, op.DefenseMoves)
+			if errors.Is(err, errNonOptimalDefense) {
+				res.Err = err
+				return res
+			} else if err != nil {
+				logger.Error("failed to check the defense", "error", err)
+			}
+		}
+		if op.Cook {
+			cooks, err := process.FindCooks(res.Problem, res.Pv, op.CookTimeLimit)
+			if err != nil {
+				logger.Error("failed to search for cooks", "error", err)
 			}
+			res.Cooks = cooks
 		}
+		return res
+	}
 
-		total := 0
-		solved := 0
-		running := op.Process
-		for {
+	// solved is the number of positions passed to the engine, which decides when to send
+	// usinewgame
+	solved := 0
+	for {
+		// a retiring worker leaves its queued positions to the others
+		select {
+		case <-retire:
+			logger.Info("retiring the worker")
+			process.Quit()
+			return
+		default:
+		}
+		problem, ok, wait := queue.Take(worker)
+		if !ok {
+			if wait == nil {
+				return
+			}
 			select {
-			case out := <-output_chan:
-				fmt.Printf("\r%v\n", out)
-				if has_outfile {
-					fmt.Fprintf(outfile, "\r%v\n", out)
-				}
-			case summary := <-summary_chan:
-				total += summary.total
-				solved += summary.solved
-				running -= 1
-				if running <= 0 {
-					fmt.Println()
-					fmt.Printf("solved/total: %v/%v  (%.2f sec)\n", solved, total, time.Since(start).Seconds())
-					if has_outfile {
-						fmt.Fprintf(outfile, "solved/total: %v/%v   (%.2f sec)\n", solved, total, time.Since(start).Seconds())
+			case <-retire:
+				logger.Info("retiring the worker")
+				process.Quit()
+				return
+			case <-wait:
+			}
+			continue
+		}
+		time_limit := op.TimeLimit
+		if op.Adaptive != nil {
+			time_limit = op.Adaptive.Limit(time_limit)
+		}
+		if op.Deadline != nil {
+			time_limit = op.Deadline.Take(time_limit)
+		}
+		select {
+		case <-abort:
+			fmt.Fprintln(process.stdin, "quit")
+			return
+		default:
+		}
+		if process.killed {
+			logger.Warn("restarting the engine terminated while stopping")
+			restart_engine()
+		}
+		if verifier != nil && verifier.killed {
+			logger.Warn("restarting the verification engine terminated while stopping")
+			verifier.Kill()
+			start_verifier()
+		}
+
+		logger := logger.With("position", problemLabel(problem))
+		if cache != nil {
+			// failures cached by runs without the portfolio are given to the portfolio
+			if res, ok := cache.Lookup(op, problem, time_limit); ok && !(len(op.Portfolio) > 0 && survives(res)) {
+				logger.Debug("found a cached result", "status", res.Status())
+				res = verifyResult(res)
+				if reanalyze {
+					if err := process.ApplyProblemOptions(op, problem); err != nil {
+						logger.Error("failed to set options", "error", err)
+						exit(1)
 					}
-					return
+					res = analyze(logger, res)
 				}
+				result_ch <- res
+				continue
 			}
 		}
-	}()
 
-	sfen_scanner := bufio.NewScanner(os.Stdin)
-	for sfen_scanner.Scan() {
-		sfen := sfen_scanner.Text()
-		sfen_chan <- sfen
+		err := process.ApplyProblemOptions(op, problem)
+		if err != nil {
+			logger.Error("failed to set options", "error", err)
+			exit(1)
+		}
+
+		if op.LogDir != "" {
+			transcript, err := openTranscript(op.LogDir, problem, op.LogFailuresOnly)
+			if err != nil {
+				logger.Error("failed to open the transcript", "error", err)
+				exit(1)
+			}
+			process.SetTranscript(transcript)
+		}
+
+		if op.NewGameEvery > 0 && solved%op.NewGameEvery == 0 {
+			if err := process.NewGame(); err != nil {
+				logger.Error("the engine is not ready", "error", err)
+				exit(1)
+			}
+		}
+		solved += 1
+
+		logger.Debug("solving", "time_limit", time_limit)
+		monitor.Begin(worker, problem)
+		start := time.Now()
+		res := process.Solve(problem, time_limit)
+		crashes := 0
+		for errors.Is(res.Err, errCrashed) {
+			logger.Warn("the engine crashed, restarting", "error", res.Err, "crashes", crashes+1)
+			restart_engine()
+			// a position using too much memory would use it again
+			if crashes += 1; crashes > op.MaxRestarts || errors.Is(res.Err, errMemory) {
+				break
+			}
+			if err := process.ApplyProblemOptions(op, problem); err != nil {
+				logger.Error("failed to set options", "error", err)
+				exit(1)
+			}
+			res = process.Solve(problem, time_limit)
+		}
+		if process.killed {
+			logger.Warn("restarting the engine terminated while stopping")
+			restart_engine()
+		}
+		res, res.Retries = process.RetryNoPv(logger, res, problem, time_limit, op.RetryNoPv)
+		if op.Retry > 0 {
+			res, res.Attempt = process.RetryEscalating(logger, res, problem, time_limit, op.Retry, op.RetryFactor)
+			if errors.Is(res.Err, errCrashed) || process.killed {
+				logger.Warn("restarting the engine after the retries", "error", res.Err)
+				restart_engine()
+			}
+		}
+		if len(op.Portfolio) > 0 && survives(res) {
+			portfolio_time_limit := op.PortfolioLimit
+			if portfolio_time_limit == 0 {
+				portfolio_time_limit = op.TimeLimit
+			}
+			res = solvePortfolio(logger, func() (*EngineProcess, error) {
+				ep, err := newEngineProcessIn(command, engine_dir, limits)
+				if err == nil {
+					confine(ep)
+				}
+				return ep, err
+			}, op, problem, res, portfolio_time_limit)
+		}
+		res.Crashes = crashes
+		res.Problem = problem
+		res.Time = time.Since(start)
+		res.TimeLimit = time_limit
+		if op.Adaptive != nil {
+			op.Adaptive.Observe(res)
+		}
+		monitor.End(worker)
+		res = verifyResult(res)
+		logger.Debug("finished", "status", res.Status(), "error", res.Err,
+			"time_ms", res.Time.Milliseconds(), "nodes", res.Nodes)
+
+		if process.transcript != nil {
+			if err := process.transcript.Close(res.Unexpected()); err != nil {
+				logger.Error("failed to save the transcript", "error", err)
+			}
+			process.SetTranscript(nil)
+		}
+		result_ch <- analyze(logger, res)
+	}
+}
+
+func main() {
+	op := parseOptions()
+	if err := setupLogger(op.LogLevel, !op.Quiet && op.Progress != "json"); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+
+	// the engine is given after "--" with its arguments, by the config file, or as the first
+	// argument
+	args := flag.Args()
+	if dash := flag.CommandLine.ArgsLenAtDash(); dash >= 0 {
+		if dash == len(args) {
+			fmt.Println("error: solver command was not specified after --")
+			os.Exit(1)
+		}
+		args = append([]string{quoteCommand(args[dash:])}, args[:dash]...)
+	} else if op.Engine != "" {
+		args = append([]string{op.Engine}, args...)
+	}
+	if len(args) == 1 {
+		args = append(args, op.Inputs...)
+	}
+	if len(args) == 0 {
+		fmt.Println("error: solver command was not specified")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var err error
+	if op.EngineOptions, err = parseEngineOptions(op.OptionArgs); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	if op.HangTimeout > 0 {
+		if op.EngineOptions, err = hangOptions(op.EngineOptions, op.HangTimeout); err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+	}
+	if op.Process, err = resolveProcess(op.ProcessArg, op.EngineOptions); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	if op.HashSize, err = resolveHashSize(op.Hash, op.Process, op.HashFraction); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	if op.NewGameEvery, err = parseNewGame(op.NewGame); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	if err := checkLimits(op.Cgroup, op.MemoryLimit, op.CpuLimit); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	if op.MemoryLimit > 0 && op.MemoryLimit <= op.HashSize {
+		slog.Warn("the engines may fail to allocate the hash under --memory-limit", "memory_limit_mb", op.MemoryLimit, "hash_mb", op.HashSize)
+	}
+	if op.PinCpus*op.Process > runtime.NumCPU() {
+		slog.Warn("the workers share CPUs since --pin-cpus times --process exceeds the number of CPUs", "cpus", runtime.NumCPU())
+	}
+	if op.Warmup != "" {
+		if op.WarmupProblems, err = readProblems([]string{op.Warmup}); err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+	}
+
+	command := args[0]
+	if op.Interactive {
+		if err := runInteractive(command, op); err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if op.Sfen != "" {
+		if err := runSingle(command, op, op.Sfen); err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	input_paths, err := expandInputs(args[1:])
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	if op.Watch != "" && (len(input_paths) > 0 || op.Sample > 0) {
+		fmt.Println("error: --watch cannot be used with input files or --sample")
+		os.Exit(1)
+	}
+
+	if op.LogFailuresOnly && op.LogDir == "" {
+		fmt.Println("error: --log-failures-only requires --log-dir")
+		os.Exit(1)
+	}
+	for key := range op.Labels {
+		if key == "" {
+			fmt.Println("error: --label requires a key")
+			os.Exit(1)
+		}
+	}
+	if op.Retry > 0 && op.RetryFactor <= 1 {
+		fmt.Println("error: --retry-factor must be greater than 1")
+		os.Exit(1)
+	}
+	if op.AdaptiveFactor < 0 || op.AdaptivePercent <= 0 || op.AdaptivePercent > 100 {
+		fmt.Println("error: --adaptive-time-limit must not be negative and --adaptive-percentile must be in (0, 100]")
+		os.Exit(1)
+	}
+	if op.Portfolio, err = parsePortfolio(op.PortfolioArgs); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	if !isOrder(op.Order) {
+		fmt.Println("error: unknown order:", op.Order)
+		os.Exit(1)
+	}
+	if op.Difficulty != "" && op.Order != orderHardestFirst {
+		fmt.Println("error: --difficulty requires --order hardest-first")
+		os.Exit(1)
+	}
+	if !isProgressKind(op.Progress) {
+		fmt.Println("error: unknown progress kind:", op.Progress)
+		os.Exit(1)
+	}
+	if op.OutFormat != "text" {
+		if op.OutFile == "" {
+			fmt.Printf("error: --out-format %s requires --out\n", op.OutFormat)
+			os.Exit(1)
+		}
+		if !isOutFormat(op.OutFormat) {
+			fmt.Println("error: unknown output format:", op.OutFormat)
+			os.Exit(1)
+		}
+	}
+	if op.Append {
+		if op.OutFile == "" {
+			fmt.Println("error: --append requires --out")
+			os.Exit(1)
+		}
+		if isReportFormat(op.OutFormat) {
+			fmt.Printf("error: --append cannot be used with --out-format %s\n", op.OutFormat)
+			os.Exit(1)
+		}
+	}
+
+	filter, err := newFilter(op.Filters)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	if op.Stream && op.Sample > 0 {
+		fmt.Println("error: --stream cannot be used with --sample")
+		os.Exit(1)
+	}
+	if op.Resume && op.Checkpoint == "" {
+		fmt.Println("error: --resume requires --checkpoint")
+		os.Exit(1)
+	}
+	var checkpoint *Checkpoint
+	if op.Checkpoint != "" {
+		checkpoint, err = newCheckpoint(op.Checkpoint, op.Resume, time.Duration(op.CheckpointEvery)*time.Second)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		if queue := checkpoint.Resumed(); queue.Read > 0 {
+			slog.Info("resuming the previous run", "read", queue.Read, "in_flight", len(queue.InFlight))
+		}
+	}
+
+	if op.Watch != "" {
+		op.NoDedup = true
+	}
+	var answers *AnswerDB
+	if op.Answers != "" {
+		answers, err = loadAnswerDB(op.Answers)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+	}
+	streaming := op.Stream || len(input_paths) == 0 && op.Sample == 0
+	dedup := newDeduplicator(0)
+	if streaming {
+		dedup = newDeduplicator(streamResolved)
+	}
+	invalid := 0
+	accept := func(problem Problem) bool {
+		if !op.NoValidate {
+			if err := validateProblem(problem); err != nil {
+				slog.Warn("invalid position", "position", problemLabel(problem), "error", err)
+				invalid++
+				return false
+			}
+		}
+		if !filter.Match(problem) || !op.NoDedup && !dedup.Add(problem) {
+			return false
+		}
+		// the duplicates of a finished position are resolved by its restored result, and the
+		// positions read at once are checked after sampling so that the same ones are sampled
+		return !streaming || checkpoint == nil || !checkpoint.Finished(problem)
+	}
+
+	var problems []Problem
+	if !streaming {
+		var all_problems []Problem
+		if len(input_paths) > 0 {
+			all_problems, err = readProblems(input_paths)
+		} else {
+			err = scanProblems(os.Stdin, false, func(problem Problem) {
+				all_problems = append(all_problems, problem)
+			})
+		}
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		for _, problem := range all_problems {
+			problem = answers.Apply(problem)
+			if accept(problem) {
+				problems = append(problems, problem)
+			}
+		}
+		if filter.filtered > 0 {
+			slog.Info("filtered out positions", "count", filter.filtered)
+		}
+		if dedup.removed > 0 || dedup.mirrored > 0 {
+			slog.Info("removed duplicate positions", "duplicates", dedup.removed, "mirrored", dedup.mirrored)
+		}
+		if op.Sample > 0 && op.Sample < len(problems) {
+			seed := op.Seed
+			if seed == 0 && checkpoint != nil {
+				seed = checkpoint.Resumed().Seed
+			}
+			if seed == 0 {
+				seed = time.Now().UnixNano()
+			}
+			if checkpoint != nil {
+				checkpoint.SetSeed(seed)
+			}
+			slog.Info("sampled positions", "count", op.Sample, "total", len(problems), "seed", seed)
+			problems = sampleProblems(problems, op.Sample, seed)
+		}
+		if checkpoint != nil {
+			unfinished := problems[:0]
+			for _, problem := range problems {
+				if !checkpoint.Finished(problem) {
+					unfinished = append(unfinished, problem)
+				}
+			}
+			problems = unfinished
+			checkpoint.SetRead(len(all_problems))
+			if checkpoint.skipped+len(checkpoint.restored) > 0 {
+				slog.Info("skipped positions finished in the previous run", "count", checkpoint.skipped+len(checkpoint.restored))
+			}
+		}
+	}
+
+	if op.Order == orderHardestFirst {
+		if streaming {
+			slog.Warn("--order hardest-first is ignored for positions read from stdin, watched or streamed")
+		} else {
+			var baseline map[string]baselineEntry
+			if op.Difficulty != "" {
+				baseline, err = loadBaseline(op.Difficulty)
+				if err != nil {
+					fmt.Println("error:", err)
+					os.Exit(1)
+				}
+			}
+			problems = orderHardestFirstProblems(problems, estimateDifficulties(problems, baseline))
+		}
+	}
+	if checkpoint != nil && !streaming {
+		problems = checkpoint.InFlightFirst(problems)
+	}
+
+	if op.Split {
+		if streaming {
+			fmt.Println("error: --split requires input files or --sample without --stream")
+			os.Exit(1)
+		}
+		if op.TwoPhase || len(op.Compare) > 0 || op.Race != "" || len(op.RaceOptionArgs) > 0 {
+			fmt.Println("error: --split cannot be used with --two-phase, --compare or --race")
+			os.Exit(1)
+		}
+		info, err := identifyEngine(command)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		info.Labels = op.Labels
+		var results []Result
+		var report strings.Builder
+		for _, problem := range problems {
+			s, err := solveSplit(command, op, problem)
+			if err != nil {
+				fmt.Println("error:", err)
+				os.Exit(1)
+			}
+			fmt.Print(s)
+			report.WriteString(s.String())
+			results = append(results, s.combined)
+		}
+		if op.OutFile != "" {
+			if err := writeResults(op.OutFile, op.OutFormat, results, report.String(), info, op.Append); err != nil {
+				fmt.Println("error:", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	if op.TwoPhase {
+		if streaming {
+			fmt.Println("error: --two-phase requires input files or --sample without --stream")
+			os.Exit(1)
+		}
+		if len(op.Compare) > 0 || op.Race != "" || len(op.RaceOptionArgs) > 0 {
+			fmt.Println("error: --two-phase cannot be used with --compare or --race")
+			os.Exit(1)
+		}
+		if op.TimeLimit <= 0 {
+			fmt.Println("error: --two-phase requires --time-limit for the first phase")
+			os.Exit(1)
+		}
+		second_hash := op.SecondHash
+		if second_hash == 0 {
+			second_hash = 4 * op.HashSize
+		}
+		info, err := identifyEngine(command)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		info.Labels = op.Labels
+		phases := solveTwoPhase(command, op, problems, second_hash, op.SecondTimeLimit)
+		report := TwoPhaseReport(phases)
+		fmt.Print(report)
+		if op.OutFile != "" {
+			if err := writeResults(op.OutFile, op.OutFormat, finalResults(phases), report, info, op.Append); err != nil {
+				fmt.Println("error:", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	if len(op.RaceOptionArgs) > 0 && op.Race == "" {
+		// race the option sets with the same engine
+		op.Race = command
+	}
+	if op.Repeat > 1 && len(op.Compare) == 0 && op.Race == "" {
+		if streaming {
+			fmt.Println("error: --repeat requires input files or --sample without --stream")
+			os.Exit(1)
+		}
+		if op.OutFormat != "text" && op.OutFormat != "csv" {
+			fmt.Printf("error: --repeat cannot be used with --out-format %s (expected text or csv)\n", op.OutFormat)
+			os.Exit(1)
+		}
+		benchmark := repeatProblems(command, op, problems, op.Repeat, op.Interleave)
+		report := benchmark.String()
+		fmt.Print(report)
+		if op.OutFile != "" {
+			file, err := openBufferedFile(op.OutFile, op.Append)
+			if err != nil {
+				fmt.Println("error:", err)
+				os.Exit(1)
+			}
+			size, err := file.Size()
+			if err == nil && op.OutFormat == "csv" {
+				err = benchmark.WriteCsv(file, size > 0)
+			} else if err == nil {
+				_, err = io.WriteString(file, report)
+			}
+			if cerr := file.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				fmt.Println("error:", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+	if len(op.Compare) > 0 || op.Race != "" {
+		if streaming {
+			fmt.Println("error: --compare and --race require input files or --sample without --stream")
+			os.Exit(1)
+		}
+		if len(op.Compare) > 0 && op.Race != "" {
+			fmt.Println("error: --compare cannot be used with --race")
+			os.Exit(1)
+		}
+		if op.Repeat < 1 {
+			fmt.Println("error: --repeat must be positive")
+			os.Exit(1)
+		}
+		commands := append([]string{command}, op.Compare...)
+		ops := make([]Options, len(commands))
+		for i := range ops {
+			ops[i] = op
+		}
+		if op.Race != "" {
+			race_options, err := parseEngineOptions(op.RaceOptionArgs)
+			if err != nil {
+				fmt.Println("error:", err)
+				os.Exit(1)
+			}
+			commands = []string{command, op.Race}
+			ops = []Options{op, op}
+			ops[1].EngineOptions = append(append([]EngineOption(nil), op.EngineOptions...), race_options...)
+		}
+		comparison := compareEngines(commands, ops, problems, op.Repeat)
+		report := comparison.String()
+		if op.Race != "" {
+			report = comparison.RaceReport() + comparison.SignificanceReport()
+		}
+		fmt.Print(report)
+		if op.OutFile != "" {
+			if err := writeEngineComparison(op.OutFile, op.OutFormat, comparison, report, op.Append); err != nil {
+				fmt.Println("error:", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	start := time.Now()
+	if op.AdaptiveFactor > 0 {
+		op.Adaptive = newAdaptiveTimeLimit(op.AdaptivePercent, op.AdaptiveFactor)
+	}
+	if op.DeadlineArg != "" {
+		if streaming {
+			fmt.Println("error: --deadline requires input files or --sample without --stream")
+			os.Exit(1)
+		}
+		at, err := parseDeadline(op.DeadlineArg, start)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		if !at.After(start) {
+			fmt.Println("error: the deadline has passed:", at.Format(time.RFC3339))
+			os.Exit(1)
+		}
+		slog.Info("finishing the run by the deadline", "deadline", at.Format(time.RFC3339), "time_left", time.Until(at).Round(time.Second))
+		op.Deadline = newDeadline(at, op.Process, len(problems))
+	}
+	total := -1
+	if !streaming {
+		aliases := 0
+		for _, problem := range problems {
+			aliases += dedup.AliasCount(problem)
+		}
+		total = len(problems) + aliases
+	}
+	// the terminal shows failed positions and the summary unless machine-readable progress
+	// is written to stdout
+	console := !op.Quiet && op.Progress != "json"
+	progress_kind := op.Progress
+	if op.Quiet {
+		progress_kind = "none"
+	}
+	monitor := newMonitor(op.Process)
+	progress := newProgress(progress_kind, total, monitor, os.Stdout, op.Watch != "")
+
+	info, err := identifyEngine(command)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+	info.Labels = op.Labels
+	for _, problem := range checkEngineOptions(info.EngineOptions, op.EngineOptions) {
+		if op.StrictOptions {
+			fmt.Println("error:", problem)
+			os.Exit(1)
+		}
+		slog.Warn(problem)
+	}
+	if op.NodesLimit > 0 {
+		for _, name := range info.EngineOptions {
+			if name == "NodesLimit" {
+				// the engine stops at the limit exactly, while a stop after an info line overshoots
+				op.EngineOptions = append(op.EngineOptions, EngineOption{Name: "NodesLimit", Value: strconv.FormatInt(op.NodesLimit, 10)})
+			}
+		}
+	}
+	var cache *ResultCache
+	if !op.NoCache && op.Cache != "" {
+		cache, err = openResultCache(op.Cache, info.EngineHash, op, info.EngineOptions)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		defer cache.Close()
+	}
+	var results_db *ResultsDB
+	if op.ResultsDB != "" {
+		results_db, err = openResultsDB(op.ResultsDB, command, op, info)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		slog.Info("recording the run", "results_db", op.ResultsDB, "run_id", results_db.run_id)
+	}
+	var comparison *Comparison
+	if op.Baseline != "" {
+		baseline, err := loadBaseline(op.Baseline)
+		if err != nil {
+			fmt.Println("error:", err)
+			os.Exit(1)
+		}
+		comparison = newComparison(baseline, op.Slowdown)
+	}
+
+	queue := newScheduler()
+	result_chan := make(chan Result)
+	abort := make(chan struct{})
+	var abort_once sync.Once
+	var wg sync.WaitGroup
+	retire := make(chan struct{})
+	spawn := func(i int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			solve(i, command, op, cache, monitor, abort, retire, queue, result_chan)
+		}()
+	}
+	for i := 0; i < op.Process; i++ {
+		spawn(i)
+	}
+	// the workers can be added and retired with signals until all the problems are fed
+	fed := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scaleWorkers(op.Process, fed, func() { spawn(monitor.Add()) }, retire)
+	}()
+	go func() {
+		wg.Wait()
+		close(result_chan)
+	}()
+
+	// the watcher and the TUI handle interrupts by themselves
+	if op.Watch != "" || progress_kind == "tui" {
+		exitOnSignals(syscall.SIGTERM)
+	} else {
+		exitOnSignals(os.Interrupt, syscall.SIGTERM)
+	}
+	end := make(chan struct{}, 1)
+	exit_code := 0
+	go func() {
+		defer close(end)
+
+		// the files are written through buffers, which are flushed periodically and when the
+		// recorder finishes
+		appending := op.Watch != "" || op.Append
+		has_outfile := false
+		var outfile *bufferedFile
+		defer func() { outfile.Close() }()
+		if op.OutFile != "" {
+			file, err := openBufferedFile(op.OutFile, appending && !isReportFormat(op.OutFormat))
+			if err == nil {
+				has_outfile = true
+				outfile = file
+			} else {
+				slog.Error("failed to open the output file", "error", err)
+			}
+		}
+		var unsolved_file, mismatch_file *bufferedFile
+		defer func() { unsolved_file.Close() }()
+		defer func() { mismatch_file.Close() }()
+		if has_outfile {
+			unsolved_file, err = openBufferedFile(op.OutFile+".unsolved", appending)
+			if err != nil {
+				slog.Error("failed to open the file of unsolved positions", "error", err)
+			}
+			mismatch_file, err = openBufferedFile(op.OutFile+".mismatch", appending)
+			if err != nil {
+				slog.Error("failed to open the file of mate length mismatches", "error", err)
+			}
+		}
+		text_out := has_outfile && op.OutFormat == "text"
+		var writer ResultWriter
+		if has_outfile && !text_out {
+			size, err := outfile.Size()
+			writer = newResultWriter(op.OutFormat, outfile, err == nil && size > 0, info)
+		}
+		colored := useColor(op.NoColor)
+		output := func(color string, out string) {
+			if console {
+				if colored {
+					progress.Print(colorize(color, out))
+				} else {
+					progress.Print(out)
+				}
+			}
+			if text_out {
+				fmt.Fprintf(outfile, "\r%v\n", out)
+			}
+		}
+
+		dbs := newProblemDBWriter()
+		defer dbs.Close()
+		if results_db != nil {
+			defer func() {
+				if err := results_db.Close(); err != nil {
+					slog.Error("failed to close the results database", "error", err)
+				}
+			}()
+		}
+
+		var summary Summary
+		if streaming {
+			summary.times = newTimeSample(timeSampleSize)
+		} else {
+			summary.times = newTimeSample(0)
+		}
+		aborted := false
+		record := func(res Result) {
+			problem := res.Problem
+			if errors.Is(res.Err, errAborted) {
+				return
+			}
+			if errors.Is(res.Err, errSkipped) {
+				// skipped positions are left to the next run
+				summary.skipped += 1
+				if unsolved_file != nil {
+					fmt.Fprintln(unsolved_file, problemKey(problem.Sfen, problem.Moves))
+				}
+				if writer != nil {
+					if err := writer.Write(res); err != nil {
+						slog.Error("failed to write the result", "position", problemLabel(problem), "error", err)
+					}
+				}
+				return
+			}
+			if op.FailFast && res.Fatal() {
+				abort_once.Do(func() {
+					reason := fmt.Sprint(res.Err)
+					if res.Err == nil {
+						reason = problem.CheckAnswer(res.Pv)
+					}
+					slog.Error("aborting the run", "position", problemLabel(problem), "reason", reason)
+					aborted = true
+					close(abort)
+				})
+			}
+			annotation := ""
+			if res.MirrorOf != nil {
+				annotation = fmt.Sprintf(" (mirror of %v)", *res.MirrorOf)
+			}
+			if res.Crashes > 0 {
+				annotation += fmt.Sprintf(" (engine crashed %v times)", res.Crashes)
+				summary.crashed += 1
+			}
+			if res.Retries > 0 {
+				annotation += fmt.Sprintf(" (retried %v)", res.Retries)
+				summary.retried += 1
+			}
+			if res.Attempt > 1 {
+				annotation += fmt.Sprintf(" (attempt %v)", res.Attempt)
+				if res.Err == nil {
+					summary.escalated += 1
+				}
+			}
+			if res.Portfolio != "" {
+				annotation += fmt.Sprintf(" (portfolio: %v)", res.Portfolio)
+			}
+			if res.Cached {
+				annotation += " (cached)"
+				summary.cached += 1
+			}
+			if res.Resumed {
+				annotation += " (resumed)"
+				summary.resumed += 1
+			}
+
+			summary.total += 1
+			summary.AddTags(res)
+			summary.AddMateLen(res)
+			if !res.Cached && !res.Resumed && res.MirrorOf == nil {
+				summary.times.Add(res.Time)
+				summary.nodes += res.Nodes
+			}
+			if op.Watch != "" && problem.Source != "" {
+				annotation += fmt.Sprintf(" (%v)", problem.Source)
+			}
+			if res.CrossCheck != nil {
+				summary.disagreed += 1
+				output(colorMagenta, fmt.Sprintf("disagreement (%v, verify engine: %v): %v%v", outcome(res), res.CrossCheck, problem, annotation))
+			}
+			if sweepChanged(res) {
+				summary.sensitive += 1
+				output(colorMagenta, fmt.Sprintf("option sensitive (%v, %v): %v%v", outcome(res), formatSweeps(res.Sweeps), problem, annotation))
+			}
+			if res.Err != nil {
+				if problem.NoMate && errors.Is(res.Err, errNoMate) {
+					summary.expected += 1
+					summary.matched += 1
+				} else {
+					summary.unsolved += 1
+					if errors.Is(res.Err, errPawnDrop) {
+						summary.pawn_drops += 1
+					}
+					if errors.Is(res.Err, errInconsistentMate) {
+						summary.inconsistent += 1
+					}
+					if errors.Is(res.Err, errNonOptimalDefense) {
+						summary.blunders += 1
+					}
+					output(resultColor(res), fmt.Sprintf("%v: %v%v", res.Err, problem, annotation))
+					if unsolved_file != nil {
+						fmt.Fprintln(unsolved_file, problemKey(problem.Sfen, problem.Moves))
+					}
+				}
+			} else {
+				if len(res.Cooks) > 0 {
+					summary.cooked += 1
+					output(colorMagenta, fmt.Sprintf("cook (%v): %v%v", formatCooks(res.Cooks), problem, annotation))
+					annotation += fmt.Sprintf(" (cooks: %v)", formatCooks(res.Cooks))
+				}
+				if len(res.Interpositions) > 0 {
+					annotation += fmt.Sprintf(" (useless interpositions: %v)", formatInterpositions(res.Interpositions))
+					summary.mudaai += 1
+				}
+				if res.Surplus != "" {
+					annotation += fmt.Sprintf(" (surplus: %v)", res.Surplus)
+					summary.surplus += 1
+				}
+				if op.KifDir != "" {
+					if err := writeKif(op.KifDir, res); err != nil {
+						slog.Error("failed to write the KIF file", "position", problemLabel(problem), "error", err)
+					}
+				}
+				if op.CsaDir != "" {
+					if err := writeCsa(op.CsaDir, res); err != nil {
+						slog.Error("failed to write the CSA file", "position", problemLabel(problem), "error", err)
+					}
+				}
+				if op.Ki2Dir != "" {
+					if err := writeKi2(op.Ki2Dir, res); err != nil {
+						slog.Error("failed to write the KI2 file", "position", problemLabel(problem), "error", err)
+					}
+				}
+				solution := fmt.Sprintf("checkmate %v: %v%v", strings.Join(res.Pv, " "), problem, annotation)
+				if op.Watch != "" {
+					output(colorNone, solution)
+				} else if text_out {
+					fmt.Fprintf(outfile, "%v\n", solution)
+				}
+				summary.solved += 1
+				if problem.HasExpectation() {
+					summary.expected += 1
+					if mismatch := problem.CheckAnswer(res.Pv); problem.NoMate {
+						summary.false_mates += 1
+						summary.mismatched += 1
+						output(resultColor(res), fmt.Sprintf("false mate (%v): %v%v", mismatch, problem, annotation))
+					} else if mismatch != "" {
+						summary.mismatched += 1
+						output(resultColor(res), fmt.Sprintf("mismatch (%v): %v%v", mismatch, problem, annotation))
+						if problem.MateLen > 0 && len(res.Pv) != problem.MateLen {
+							// a shorter mate means that the engine missed the best defense
+							if len(res.Pv) < problem.MateLen {
+								summary.shorter += 1
+							}
+							if mismatch_file != nil {
+								fmt.Fprintf(mismatch_file, "%v\t%v\t%v\n", problemKey(problem.Sfen, problem.Moves), len(res.Pv), problem.MateLen)
+							}
+						}
+					} else {
+						summary.matched += 1
+					}
+				}
+			}
+			if writer != nil {
+				if err := writer.Write(res); err != nil {
+					slog.Error("failed to write the result", "position", problemLabel(problem), "error", err)
+				}
+			}
+			if err := dbs.Store(res); err != nil {
+				slog.Error("failed to store the result into the database", "position", problemLabel(problem), "error", err)
+			}
+			if comparison != nil {
+				comparison.Add(res)
+			}
+			if answers != nil {
+				answers.Add(res)
+			}
+			if results_db != nil {
+				if err := results_db.Store(res); err != nil {
+					slog.Error("failed to store the result into the results database", "position", problemLabel(problem), "error", err)
+				}
+			}
+			if checkpoint != nil && !res.Resumed {
+				checkpoint.Add(res)
+			}
+			if cache != nil && !res.Resumed {
+				if err := cache.Store(op, res); err != nil {
+					slog.Error("failed to cache the result", "position", problemLabel(problem), "error", err)
+				}
+			}
+			progress.Add(res)
+		}
+
+		for res := range result_chan {
+			record(res)
+			if !op.NoDedup {
+				for _, alias := range dedup.Resolve(res) {
+					record(alias)
+				}
+				// the results are forgotten as they are flushed in streaming
+				if streaming {
+					for _, alias := range dedup.Flush() {
+						record(alias)
+					}
+				}
+			}
+		}
+		for _, alias := range dedup.Flush() {
+			record(alias)
+		}
+
+		if writer != nil {
+			if err := writer.Close(); err != nil {
+				slog.Error("failed to write the output file", "error", err)
+			}
+		}
+
+		summary.duplicates = dedup.removed
+		summary.mirrored = dedup.mirrored
+		summary.filtered = filter.filtered
+		summary.invalid = invalid
+		if checkpoint != nil {
+			if err := checkpoint.Close(); err != nil {
+				slog.Error("failed to save the checkpoint", "error", err)
+			}
+			summary.resumed += checkpoint.skipped
+		}
+		elapsed := time.Since(start)
+		if op.HistogramSvg != "" {
+			svg := histogramSvg(summary.times.Histogram())
+			if err := os.WriteFile(op.HistogramSvg, []byte(svg), 0644); err != nil {
+				slog.Error("failed to write the histogram", "error", err)
+			}
+		}
+		progress.Finish(summary)
+		if console {
+			fmt.Println()
+			if op.Histogram {
+				fmt.Print(summary.TimeHistogram())
+			}
+			fmt.Print(summary.MateLenTable())
+			fmt.Print(summary.TagTable())
+			if answers != nil {
+				fmt.Print(answers.AccuracyTable())
+			}
+			fmt.Printf("%v  (%.2f sec)\n", summary, elapsed.Seconds())
+			if stats := summary.TimeStats(elapsed); stats != "" {
+				fmt.Println(stats)
+			}
+			if comparison != nil {
+				fmt.Print(comparison)
+			}
+		}
+		if text_out {
+			fmt.Fprintf(outfile, "%v\n", info)
+			if op.Histogram {
+				fmt.Fprint(outfile, summary.TimeHistogram())
+			}
+			fmt.Fprint(outfile, summary.MateLenTable())
+			fmt.Fprint(outfile, summary.TagTable())
+			if answers != nil {
+				fmt.Fprint(outfile, answers.AccuracyTable())
+			}
+			fmt.Fprintf(outfile, "%v   (%.2f sec)\n", summary, elapsed.Seconds())
+			if stats := summary.TimeStats(elapsed); stats != "" {
+				fmt.Fprintln(outfile, stats)
+			}
+			if comparison != nil {
+				fmt.Fprint(outfile, comparison)
+			}
+		}
+		exit_code = summary.ExitCode(op)
+		if aborted {
+			exit_code = 1
+		}
+	}()
+
+	// After --total-time-limit or --deadline, the positions being solved are finished and the
+	// rest, including the ones queued for the workers, are recorded as skipped.
+	var expired <-chan time.Time
+	if op.TotalTimeLimit > 0 {
+		expired = time.After(time.Until(start.Add(time.Duration(op.TotalTimeLimit) * time.Second)))
+	}
+	if op.Deadline != nil && (expired == nil || op.Deadline.at.Before(start.Add(time.Duration(op.TotalTimeLimit)*time.Second))) {
+		expired = time.After(time.Until(op.Deadline.at))
+	}
+	out_of_time := false
+	skip := func(problem Problem) bool {
+		select {
+		case result_chan <- Result{Problem: problem, Err: errSkipped}:
+			return true
+		case <-abort:
+			return false
+		}
+	}
+	feed := func(problem Problem) bool {
+		if checkpoint != nil {
+			checkpoint.Fed(problem)
+		}
+		for !out_of_time {
+			ok, wait := queue.Push(problem)
+			if ok {
+				return true
+			}
+			select {
+			case <-wait:
+			case <-abort:
+				return false
+			case <-expired:
+				slog.Warn("out of the time of the run, skipping the remaining positions")
+				out_of_time = true
+				for _, queued := range queue.Drain() {
+					if !skip(queued) {
+						return false
+					}
+				}
+			}
+		}
+		return skip(problem)
+	}
+	// the results restored from the checkpoint are recorded along with the new ones
+	replay := func() {
+		if checkpoint == nil {
+			return
+		}
+		for _, res := range checkpoint.Restored() {
+			result_chan <- res
+		}
+	}
+	if !streaming {
+		replay()
+		for _, problem := range problems {
+			if !feed(problem) {
+				break
+			}
+		}
+	} else if op.Watch != "" {
+		stop := make(chan struct{})
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		go func() {
+			select {
+			case <-interrupt:
+			case <-abort:
+			case <-expired:
+			}
+			signal.Stop(interrupt)
+			close(stop)
+		}()
+
+		slog.Info("watching the directory (press Ctrl-C to stop)", "dir", op.Watch)
+		err := watchDirectory(op.Watch, time.Second, stop, func(problem Problem) {
+			problem = answers.Apply(problem)
+			if accept(problem) {
+				feed(problem)
+			}
+			replay()
+		})
+		if err != nil {
+			fmt.Println("error:", err)
+			exit(1)
+		}
+	} else if len(input_paths) > 0 {
+		// the total is counted in the background, and fixed when all the positions are read
+		var total_mu sync.Mutex
+		exact := false
+		set_total := func(total int, is_exact bool) {
+			total_mu.Lock()
+			defer total_mu.Unlock()
+			if !exact {
+				progress.SetTotal(total)
+				exact = is_exact
+			}
+		}
+		go func() {
+			if count, err := countProblems(input_paths); err == nil {
+				set_total(count, false)
+			}
+		}()
+
+		// the unfinished positions read by the previous run are held until all of them are read,
+		// so that the ones being solved at its interruption are solved first
+		held := 0
+		if checkpoint != nil {
+			held = checkpoint.Resumed().Read
+		}
+		var pending []Problem
+		feed_pending := func() bool {
+			problems := pending
+			pending = nil
+			for _, problem := range checkpoint.InFlightFirst(problems) {
+				if !feed(problem) {
+					return false
+				}
+			}
+			return true
+		}
+
+		stream, errs := streamProblems(input_paths, abort)
+		read := 0
+		for problem := range stream {
+			read++
+			if checkpoint != nil {
+				checkpoint.SetRead(read)
+			}
+			problem = answers.Apply(problem)
+			if accept(problem) {
+				if read <= held {
+					pending = append(pending, problem)
+				} else if !feed(problem) {
+					break
+				}
+			}
+			if read == held && !feed_pending() {
+				break
+			}
+			replay()
+		}
+		select {
+		case <-abort:
+		default:
+			if len(pending) > 0 {
+				feed_pending()
+			}
+		}
+		select {
+		case err := <-errs:
+			if err != nil {
+				fmt.Println("error:", err)
+				exit(1)
+			}
+		case <-abort:
+		}
+		skipped := 0
+		if checkpoint != nil {
+			skipped = checkpoint.skipped
+		}
+		set_total(read-invalid-filter.filtered-dedup.removed-skipped, true)
+	} else {
+		err := scanProblems(os.Stdin, false, func(problem Problem) {
+			problem = answers.Apply(problem)
+			if accept(problem) {
+				feed(problem)
+			}
+			replay()
+		})
+		if err != nil {
+			fmt.Println("error:", err)
+			exit(1)
+		}
 	}
-	close(sfen_chan)
+	replay()
+	queue.Close()
+	close(fed)
 
 	<-end
+	exit(exit_code)
 }
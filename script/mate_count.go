@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errInconsistentMate = errors.New("inconsistent mate count")
+
+// CheckMateCount asks the engine for the mate length of every position along pv, searching
+// for at most time_limit_ms each, and returns errInconsistentMate if the length does not
+// decrease by one per ply. Positions whose search does not finish are skipped.
+func (ep *EngineProcess) CheckMateCount(problem Problem, pv []string, time_limit_ms int) error {
+	defer ep.RestoreOption("RootIsAndNodeIfChecked")
+
+	for ply := 1; ply < len(pv); ply++ {
+		// the defender is to move after the moves of the attacker
+		and_node := ply%2 == 1
+		fmt.Fprintf(ep.stdin, "setoption name RootIsAndNodeIfChecked value %v\n", and_node)
+
+		position := problem
+		position.Moves = append(append([]string(nil), problem.Moves...), pv[:ply]...)
+		res := ep.Solve(position, time_limit_ms)
+		switch {
+		case res.Err == nil:
+			if expected := len(pv) - ply; len(res.Pv) != expected {
+				return fmt.Errorf("%w: mate in %d after move %d, expected %d", errInconsistentMate, len(res.Pv), ply, expected)
+			}
+		case errors.Is(res.Err, errNoMate):
+			return fmt.Errorf("%w: nomate after move %d, expected mate in %d", errInconsistentMate, ply, len(pv)-ply)
+		case errors.Is(res.Err, errAborted), res.Category() == "engine_error":
+			return res.Err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// WorkerState is the position a worker is solving now and the latest search statistics of it.
+type WorkerState struct {
+	Busy    bool
+	Problem Problem
+	Start   time.Time
+	Nodes   int64
+	Score   string
+}
+
+// Monitor tracks what each worker is doing so that stragglers can be seen while running.
+type Monitor struct {
+	mu      sync.Mutex
+	workers []WorkerState
+	// stopped[i] is set when worker i has stopped, e.g. retired
+	stopped []bool
+}
+
+func newMonitor(workers int) *Monitor {
+	return &Monitor{workers: make([]WorkerState, workers), stopped: make([]bool, workers)}
+}
+
+// Add adds a worker started during the run and returns its number.
+func (m *Monitor) Add() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers = append(m.workers, WorkerState{})
+	m.stopped = append(m.stopped, false)
+	return len(m.workers) - 1
+}
+
+// Stop records that worker has stopped and solves no more positions.
+func (m *Monitor) Stop(worker int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers[worker] = WorkerState{}
+	m.stopped[worker] = true
+}
+
+// Live returns the number of the workers which have not stopped.
+func (m *Monitor) Live() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	live := 0
+	for _, stopped := range m.stopped {
+		if !stopped {
+			live++
+		}
+	}
+	return live
+}
+
+func (m *Monitor) Begin(worker int, problem Problem) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers[worker] = WorkerState{Busy: true, Problem: problem, Start: time.Now()}
+}
+
+// Update stores the statistics in an "info" line which the worker received.
+func (m *Monitor) Update(worker int, text string) {
+	var res Result
+	parseInfo(text, &res)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := &m.workers[worker]
+	if res.Nodes > 0 {
+		state.Nodes = res.Nodes
+	}
+	if res.Score != "" {
+		state.Score = res.Score
+	}
+}
+
+func (m *Monitor) End(worker int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers[worker] = WorkerState{}
+}
+
+// Workers returns a copy of the states of all workers.
+func (m *Monitor) Workers() []WorkerState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]WorkerState(nil), m.workers...)
+}
+
+// Slowest returns the state of the worker which has been solving its position for the longest
+// time. ok is false if all workers are idle.
+func (m *Monitor) Slowest() (state WorkerState, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, worker := range m.workers {
+		if worker.Busy && (!ok || worker.Start.Before(state.Start)) {
+			state, ok = worker, true
+		}
+	}
+	return state, ok
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+)
+
+// Kinds of useless interpositions (無駄合). The engine decides whether an interposition is
+// useless, and then its kind follows the taxonomy of the mudaai wiki by the moves of the PV.
+const (
+	// mudaaiNarrow is an interposition captured by the checking piece, where the captured
+	// piece is never dropped afterwards (狭義無駄合).
+	mudaaiNarrow = "narrow"
+	// mudaaiX is an interposition captured by the checking piece, where the captured piece is
+	// dropped later in the PV (例外無駄合X型).
+	mudaaiX = "x"
+	// mudaaiXY is an interposition captured by a piece other than the checking piece, so that
+	// the line of the check is given up (例外無駄合XY(Y)型).
+	mudaaiXY = "xy"
+)
+
+// Interposition is a defender move of a PV which blocks a distant check and is captured by the
+// next move of the attacker.
+type Interposition struct {
+	Ply  int
+	Move string
+	Kind string
+	// captured is the type of the piece captured, as held in hand
+	captured shogi.PieceType
+}
+
+func (i Interposition) String() string {
+	return fmt.Sprintf("%d:%s:%s", i.Ply, i.Move, i.Kind)
+}
+
+func formatInterpositions(interpositions []Interposition) string {
+	strs := make([]string, len(interpositions))
+	for i, interposition := range interpositions {
+		strs[i] = interposition.String()
+	}
+	return strings.Join(strs, " ")
+}
+
+func mirrorInterpositions(interpositions []Interposition) []Interposition {
+	var mirrored []Interposition
+	for _, interposition := range interpositions {
+		interposition.Move = mirrorMove(interposition.Move)
+		mirrored = append(mirrored, interposition)
+	}
+	return mirrored
+}
+
+// findInterpositions returns the interpositions captured by the next move in the legal moves pv
+// played from pos, which are the candidates of useless interpositions.
+func findInterpositions(pos shogi.Position, pv []shogi.Move) []Interposition {
+	var interpositions []Interposition
+	for i, m := range pv {
+		before := pos
+		pos.Do(m)
+		if i%2 == 0 || i+1 >= len(pv) {
+			continue
+		}
+
+		checkers := before.Checkers()
+		king := before.King(before.SideToMove())
+		if len(checkers) != 1 || m.From == king || pv[i+1].To != m.To {
+			continue
+		}
+		blocks := false
+		for _, sq := range shogi.Between(checkers[0], king) {
+			blocks = blocks || sq == m.To
+		}
+		if !blocks {
+			continue
+		}
+
+		captured := m.Drop
+		if !m.IsDrop() {
+			captured = before.Piece(m.From).Type.Unpromoted()
+		}
+		kind := mudaaiXY
+		if pv[i+1].From == checkers[0] {
+			kind = mudaaiNarrow
+			for j := i + 3; j < len(pv); j += 2 {
+				if pv[j].Drop == captured {
+					kind = mudaaiX
+				}
+			}
+		}
+		interpositions = append(interpositions, Interposition{Ply: i + 1, Move: m.String(), Kind: kind, captured: captured})
+	}
+	return interpositions
+}
+
+// UselessInterpositions returns the useless interpositions of the defender in pv, a verified
+// mate of problem. An interposition captured by the next move is useless if the position after
+// the capture, with the captured piece given back to the defender, is still mated in the rest
+// of pv, which the engine searches for at most time_limit_ms. Interpositions whose search does
+// not finish are not reported.
+func (ep *EngineProcess) UselessInterpositions(problem Problem, pv []string, time_limit_ms int) ([]Interposition, error) {
+	pos, err := problemPosition(problem)
+	if err != nil {
+		return nil, err
+	}
+	start := *pos
+	moves := make([]shogi.Move, len(pv))
+	for i, usi := range pv {
+		if moves[i], err = pos.ParseMove(usi); err != nil {
+			return nil, err
+		}
+		pos.Do(moves[i])
+	}
+	candidates := findInterpositions(start, moves)
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	// the defender is to move after the capture
+	defer ep.RestoreOption("RootIsAndNodeIfChecked")
+	fmt.Fprintln(ep.stdin, "setoption name RootIsAndNodeIfChecked value true")
+
+	var useless []Interposition
+	for _, interposition := range candidates {
+		pos := start
+		for _, m := range moves[:interposition.Ply+1] {
+			pos.Do(m)
+		}
+		if !pos.GiveHand(pos.SideToMove().Opponent(), interposition.captured) {
+			continue
+		}
+		if pos.IsCheckmate() {
+			useless = append(useless, interposition)
+			continue
+		}
+		res := ep.Solve(Problem{Sfen: pos.Sfen()}, time_limit_ms)
+		switch {
+		case res.Err == nil:
+			if len(res.Pv) <= len(pv)-interposition.Ply-1 {
+				useless = append(useless, interposition)
+			}
+		case errors.Is(res.Err, errAborted), res.Category() == "engine_error":
+			return useless, res.Err
+		}
+	}
+	return useless, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+)
+
+// slides returns true if a piece of type t moves any number of squares in some direction.
+func slides(t shogi.PieceType) bool {
+	switch t {
+	case shogi.Lance, shogi.Bishop, shogi.Rook, shogi.Horse, shogi.Dragon:
+		return true
+	default:
+		return false
+	}
+}
+
+// rivals returns the squares of the pieces of the same kind as m that can also move to its
+// destination in pos. Pins and checks are not considered.
+func rivals(pos *shogi.Position, m boardMove) []shogi.Square {
+	var squares []shogi.Square
+	for sq := shogi.Square(0); sq < 81; sq++ {
+		if pos.Piece(sq) != (shogi.Piece{Type: m.Piece, Color: m.Color}) {
+			continue
+		}
+		if !m.IsDrop() && sq == m.From {
+			continue
+		}
+		for _, to := range pos.Attacks(sq) {
+			if to == m.To {
+				squares = append(squares, sq)
+				break
+			}
+		}
+	}
+
+	return squares
+}
+
+// disambiguation returns the relative position word (e.g. "右", "直", "左上") which
+// distinguishes the move of m from the moves of rivals to the same square.
+func disambiguation(m boardMove, rivals []shogi.Square) string {
+	sign := 1
+	if m.Color == shogi.White {
+		sign = -1
+	}
+	direction := func(sq shogi.Square) string {
+		switch forward := (sq.Rank() - m.To.Rank()) * sign; {
+		case forward > 0:
+			return "上"
+		case forward < 0:
+			return "引"
+		default:
+			return "寄"
+		}
+	}
+	// rightness is larger for pieces on the right side from the player's point of view
+	rightness := func(sq shogi.Square) int {
+		return -sq.File() * sign
+	}
+	side := func(squares []shogi.Square) string {
+		right, left := true, true
+		for _, sq := range squares {
+			right = right && rightness(m.From) > rightness(sq)
+			left = left && rightness(m.From) < rightness(sq)
+		}
+		switch {
+		case right:
+			return "右"
+		case left:
+			return "左"
+		default:
+			return ""
+		}
+	}
+
+	dir := direction(m.From)
+	var same_dir []shogi.Square
+	for _, sq := range rivals {
+		if direction(sq) == dir {
+			same_dir = append(same_dir, sq)
+		}
+	}
+	if len(same_dir) == 0 {
+		return dir
+	}
+
+	if dir == "上" && m.From.File() == m.To.File() && !slides(m.Piece) {
+		return "直"
+	}
+	if s := side(rivals); s != "" {
+		return s
+	}
+	if s := side(same_dir); s != "" {
+		return s + dir
+	}
+
+	return dir
+}
+
+// japaneseMove formats m in the Japanese notation used in KI2 files, e.g. "▲２三金右".
+// pos is the position before m is played and same is set if m moves to the square of the
+// previous move.
+func japaneseMove(pos *shogi.Position, m boardMove, same bool) string {
+	var sb strings.Builder
+	if m.Color == shogi.White {
+		sb.WriteString("△")
+	} else {
+		sb.WriteString("▲")
+	}
+	if same {
+		sb.WriteString("同　")
+	} else {
+		sb.WriteString(kifSquare(m.To))
+	}
+	sb.WriteString(kifPieceNames[m.Piece])
+
+	others := rivals(pos, m)
+	switch {
+	case m.IsDrop():
+		if len(others) > 0 {
+			sb.WriteString("打")
+		}
+	case len(others) > 0:
+		sb.WriteString(disambiguation(m, others))
+	}
+	switch {
+	case m.Promote:
+		sb.WriteString("成")
+	case m.CanPromote:
+		sb.WriteString("不成")
+	}
+
+	return sb.String()
+}
+
+// japaneseMoves converts pv played from the position of problem into the Japanese notation.
+func japaneseMoves(problem Problem, pv []string) ([]string, error) {
+	pos, err := problemPosition(problem)
+	if err != nil {
+		return nil, err
+	}
+
+	moves := make([]string, 0, len(pv))
+	prev := shogi.NoSquare
+	for _, move := range pv {
+		before := *pos
+		m, err := playMove(pos, move)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", err, problem)
+		}
+		moves = append(moves, japaneseMove(&before, m, m.To == prev))
+		prev = m.To
+	}
+
+	return moves, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// flushInterval is the interval to write out the buffered output files, which bounds the
+// output lost by a crash.
+const flushInterval = time.Second
+
+// openFiles are the buffered files open, which exit flushes.
+var openFiles = struct {
+	mu    sync.Mutex
+	files map[*bufferedFile]struct{}
+}{files: make(map[*bufferedFile]struct{})}
+
+// exit flushes the buffered files open and exits with code.
+func exit(code int) {
+	openFiles.mu.Lock()
+	for f := range openFiles.files {
+		f.Flush()
+	}
+	openFiles.mu.Unlock()
+	os.Exit(code)
+}
+
+// exitOnSignals exits with 128 plus the signal number, flushing the buffered files, on signals
+// instead of losing the buffered output.
+func exitOnSignals(signals ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	go func() {
+		sig := <-c
+		code := 1
+		if s, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(s)
+		}
+		exit(code)
+	}()
+}
+
+// bufferedFile is an output file written through a buffer instead of a write per line. It is
+// flushed every flushInterval and on Close.
+type bufferedFile struct {
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// openBufferedFile opens path for writing, appending to it if appending is set.
+func openBufferedFile(path string, appending bool) (*bufferedFile, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appending {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &bufferedFile{file: file, writer: bufio.NewWriter(file), done: make(chan struct{}), stopped: make(chan struct{})}
+	openFiles.mu.Lock()
+	openFiles.files[f] = struct{}{}
+	openFiles.mu.Unlock()
+	go func() {
+		defer close(f.stopped)
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f.Flush()
+			case <-f.done:
+				return
+			}
+		}
+	}()
+	return f, nil
+}
+
+func (f *bufferedFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writer.Write(p)
+}
+
+func (f *bufferedFile) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writer.Flush()
+}
+
+// Size returns the size of the file including the buffered output.
+func (f *bufferedFile) Size() (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stat, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size() + int64(f.writer.Buffered()), nil
+}
+
+// Close flushes and closes f. It does nothing for nil so that it can be deferred before f is
+// opened.
+func (f *bufferedFile) Close() error {
+	if f == nil {
+		return nil
+	}
+	openFiles.mu.Lock()
+	delete(openFiles.files, f)
+	openFiles.mu.Unlock()
+	close(f.done)
+	<-f.stopped
+	err := f.Flush()
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
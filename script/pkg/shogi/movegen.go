@@ -0,0 +1,311 @@
+package shogi
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrIllegalMovement = errors.New("the piece cannot move there")
+	ErrInvalidPromote  = errors.New("invalid promotion")
+	ErrDeadPiece       = errors.New("the piece would have no legal move")
+	ErrTwoPawns        = errors.New("two pawns on a file")
+	ErrPawnDropMate    = errors.New("checkmate by a pawn drop")
+	ErrLeftInCheck     = errors.New("the king is left in check")
+	ErrKingCapture     = errors.New("capturing the king")
+)
+
+type direction struct {
+	file  int
+	rank  int
+	slide bool
+}
+
+// directions are the moves of each piece seen from Black, for which a smaller rank is forward.
+var directions = map[PieceType][]direction{}
+
+func init() {
+	gold := []direction{{0, -1, false}, {-1, -1, false}, {1, -1, false}, {-1, 0, false}, {1, 0, false}, {0, 1, false}}
+	diagonal := []direction{{-1, -1, true}, {1, -1, true}, {-1, 1, true}, {1, 1, true}}
+	orthogonal := []direction{{0, -1, true}, {0, 1, true}, {-1, 0, true}, {1, 0, true}}
+	king := []direction{{0, -1, false}, {-1, -1, false}, {1, -1, false}, {-1, 0, false},
+		{1, 0, false}, {0, 1, false}, {-1, 1, false}, {1, 1, false}}
+	steps := func(dirs []direction) []direction {
+		result := make([]direction, len(dirs))
+		for i, d := range dirs {
+			result[i] = direction{d.file, d.rank, false}
+		}
+		return result
+	}
+
+	directions[Pawn] = []direction{{0, -1, false}}
+	directions[Lance] = []direction{{0, -1, true}}
+	directions[Knight] = []direction{{-1, -2, false}, {1, -2, false}}
+	directions[Silver] = []direction{{0, -1, false}, {-1, -1, false}, {1, -1, false}, {-1, 1, false}, {1, 1, false}}
+	directions[Gold] = gold
+	directions[Bishop] = diagonal
+	directions[Rook] = orthogonal
+	directions[King] = king
+	for _, t := range []PieceType{ProPawn, ProLance, ProKnight, ProSilver} {
+		directions[t] = gold
+	}
+	directions[Horse] = append(append([]direction(nil), diagonal...), steps(orthogonal)...)
+	directions[Dragon] = append(append([]direction(nil), orthogonal...), steps(diagonal)...)
+}
+
+// relativeRank returns the rank of sq seen from color, i.e. 1 is the farthest rank.
+func relativeRank(color Color, sq Square) int {
+	if color == Black {
+		return sq.Rank()
+	}
+	return 10 - sq.Rank()
+}
+
+func inPromotionZone(color Color, sq Square) bool {
+	return relativeRank(color, sq) <= 3
+}
+
+// CanPromote returns true if the piece moved by m can promote, i.e. it is promotable and moves
+// from or into the promotion zone. It is false for drops.
+func (p *Position) CanPromote(m Move) bool {
+	if m.IsDrop() {
+		return false
+	}
+	return p.board[m.From].Type.CanPromote() && (inPromotionZone(p.side, m.From) || inPromotionZone(p.side, m.To))
+}
+
+// isDeadSquare returns true if a piece of type t of color cannot move any further from sq.
+func isDeadSquare(color Color, t PieceType, sq Square) bool {
+	switch t {
+	case Pawn, Lance:
+		return relativeRank(color, sq) == 1
+	case Knight:
+		return relativeRank(color, sq) <= 2
+	default:
+		return false
+	}
+}
+
+// Attacks returns the squares which the piece on sq attacks, including squares occupied by
+// pieces of the same color.
+func (p *Position) Attacks(sq Square) []Square {
+	piece := p.board[sq]
+	sign := 1
+	if piece.Color == White {
+		sign = -1
+	}
+
+	var squares []Square
+	for _, d := range directions[piece.Type] {
+		file, rank := sq.File(), sq.Rank()
+		for {
+			file += d.file * sign
+			rank += d.rank * sign
+			if file < 1 || file > 9 || rank < 1 || rank > 9 {
+				break
+			}
+			to := NewSquare(file, rank)
+			squares = append(squares, to)
+			if !d.slide || !p.board[to].IsEmpty() {
+				break
+			}
+		}
+	}
+	return squares
+}
+
+// IsAttacked returns true if a piece of color by attacks sq.
+func (p *Position) IsAttacked(sq Square, by Color) bool {
+	for from := Square(0); from < 81; from++ {
+		piece := p.board[from]
+		if piece.IsEmpty() || piece.Color != by {
+			continue
+		}
+		for _, to := range p.Attacks(from) {
+			if to == sq {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// InCheck returns true if the king of the side to move is attacked.
+func (p *Position) InCheck() bool {
+	king := p.King(p.side)
+	return king != NoSquare && p.IsAttacked(king, p.side.Opponent())
+}
+
+// Checkers returns the squares of the pieces which attack the king of the side to move.
+func (p *Position) Checkers() []Square {
+	king := p.King(p.side)
+	if king == NoSquare {
+		return nil
+	}
+
+	var checkers []Square
+	for from := Square(0); from < 81; from++ {
+		piece := p.board[from]
+		if piece.IsEmpty() || piece.Color == p.side {
+			continue
+		}
+		for _, to := range p.Attacks(from) {
+			if to == king {
+				checkers = append(checkers, from)
+			}
+		}
+	}
+	return checkers
+}
+
+// Between returns the squares strictly between from and to if they are on the same file, rank
+// or diagonal, or nil otherwise.
+func Between(from Square, to Square) []Square {
+	df, dr := to.File()-from.File(), to.Rank()-from.Rank()
+	if from == to || (df != 0 && dr != 0 && df != dr && df != -dr) {
+		return nil
+	}
+
+	step := func(d int) int {
+		switch {
+		case d > 0:
+			return 1
+		case d < 0:
+			return -1
+		default:
+			return 0
+		}
+	}
+	var squares []Square
+	file, rank := from.File()+step(df), from.Rank()+step(dr)
+	for file != to.File() || rank != to.Rank() {
+		squares = append(squares, NewSquare(file, rank))
+		file, rank = file+step(df), rank+step(dr)
+	}
+	return squares
+}
+
+// candidates returns the moves of the side to move which follow the movements of the pieces,
+// before the legality is checked.
+func (p *Position) candidates() []Move {
+	var moves []Move
+	for from := Square(0); from < 81; from++ {
+		piece := p.board[from]
+		if piece.IsEmpty() || piece.Color != p.side {
+			continue
+		}
+		for _, to := range p.Attacks(from) {
+			if target := p.board[to]; !target.IsEmpty() && target.Color == p.side {
+				continue
+			}
+			moves = append(moves, Move{From: from, To: to})
+			if piece.Type.CanPromote() && (inPromotionZone(p.side, from) || inPromotionZone(p.side, to)) {
+				moves = append(moves, Move{From: from, To: to, Promote: true})
+			}
+		}
+	}
+
+	for _, t := range HandTypes {
+		if p.hands[p.side][t] == 0 {
+			continue
+		}
+		for to := Square(0); to < 81; to++ {
+			if p.board[to].IsEmpty() {
+				moves = append(moves, Move{From: NoSquare, To: to, Drop: t})
+			}
+		}
+	}
+	return moves
+}
+
+// CheckLegal returns the reason why m is illegal in p, or nil if it is legal.
+func (p *Position) CheckLegal(m Move) error {
+	return p.checkLegal(m, true)
+}
+
+// checkLegal checks m, where the pawn drop mate is checked only if pawn_drop_mate is set so
+// that the escapes from a pawn drop do not search for pawn drop mates recursively.
+func (p *Position) checkLegal(m Move, pawn_drop_mate bool) error {
+	if m.IsDrop() {
+		if m.Drop < Pawn || m.Drop > Rook || p.hands[p.side][m.Drop] == 0 {
+			return fmt.Errorf("no %v in hand", m.Drop)
+		}
+		if !p.board[m.To].IsEmpty() {
+			return fmt.Errorf("drop on an occupied square")
+		}
+		if isDeadSquare(p.side, m.Drop, m.To) {
+			return ErrDeadPiece
+		}
+		if m.Drop == Pawn {
+			for rank := 1; rank <= 9; rank++ {
+				if p.board[NewSquare(m.To.File(), rank)] == (Piece{Type: Pawn, Color: p.side}) {
+					return ErrTwoPawns
+				}
+			}
+		}
+	} else {
+		piece := p.board[m.From]
+		if piece.IsEmpty() || piece.Color != p.side {
+			return fmt.Errorf("no piece to move")
+		}
+		if target := p.board[m.To]; !target.IsEmpty() && target.Color == p.side {
+			return fmt.Errorf("capturing an own piece")
+		} else if target.Type == King {
+			return ErrKingCapture
+		}
+		reachable := false
+		for _, to := range p.Attacks(m.From) {
+			reachable = reachable || to == m.To
+		}
+		if !reachable {
+			return ErrIllegalMovement
+		}
+		if m.Promote {
+			if !piece.Type.CanPromote() || !(inPromotionZone(p.side, m.From) || inPromotionZone(p.side, m.To)) {
+				return ErrInvalidPromote
+			}
+		} else if isDeadSquare(p.side, piece.Type, m.To) {
+			return ErrDeadPiece
+		}
+	}
+
+	next := *p
+	next.Do(m)
+	if king := next.King(p.side); king != NoSquare && next.IsAttacked(king, next.side) {
+		return ErrLeftInCheck
+	}
+	if pawn_drop_mate && m.IsDrop() && m.Drop == Pawn && next.InCheck() && !next.hasLegalMove(false) {
+		return ErrPawnDropMate
+	}
+
+	return nil
+}
+
+func (p *Position) IsLegal(m Move) bool {
+	return p.CheckLegal(m) == nil
+}
+
+// LegalMoves returns all legal moves of the side to move.
+func (p *Position) LegalMoves() []Move {
+	var moves []Move
+	for _, m := range p.candidates() {
+		if p.checkLegal(m, true) == nil {
+			moves = append(moves, m)
+		}
+	}
+	return moves
+}
+
+func (p *Position) hasLegalMove(pawn_drop_mate bool) bool {
+	for _, m := range p.candidates() {
+		if p.checkLegal(m, pawn_drop_mate) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCheckmate returns true if the side to move is in check and has no legal move.
+func (p *Position) IsCheckmate() bool {
+	return p.InCheck() && !p.hasLegalMove(true)
+}
@@ -0,0 +1,95 @@
+package shogi
+
+import (
+	"errors"
+	"testing"
+)
+
+// perft returns the number of the move sequences of depth plies from p.
+func perft(p *Position, depth int) int {
+	if depth == 0 {
+		return 1
+	}
+	n := 0
+	for _, m := range p.LegalMoves() {
+		next := *p
+		next.Do(m)
+		n += perft(&next, depth-1)
+	}
+	return n
+}
+
+func TestPerft(t *testing.T) {
+	tests := []struct {
+		name  string
+		sfen  string
+		nodes []int
+	}{
+		{"startpos", "startpos", []int{30, 900, 25470}},
+		// a middle game position with many pieces in hand, whose legal moves are mostly drops
+		{"matsuri", "l6nl/5+P1gk/2np1S3/p1p4Pp/3P2Sp1/1PPb2P1P/P5GS1/R8/LN4bKL w RGgsn5p 1", []int{207, 28684}},
+		// 3 king moves, 4 gold moves and 68 pawn drops without the pawn drop mate P*9b
+		{"pawn drop mate", "kn7/1p7/G8/9/9/9/9/9/8K b P 1", []int{75}},
+		// the gold pinned by the rook moves only along the file
+		{"pin", "4k4/9/9/9/4r4/9/4G4/9/4K4 b - 1", []int{7}},
+	}
+	for _, test := range tests {
+		p, err := ParseSfen(test.sfen)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		for i, want := range test.nodes {
+			if got := perft(p, i+1); got != want {
+				t.Errorf("%s: perft(%d) = %d, want %d", test.name, i+1, got, want)
+			}
+		}
+	}
+}
+
+func TestCheckLegal(t *testing.T) {
+	tests := []struct {
+		sfen string
+		move string
+		err  error
+	}{
+		{"kn7/1p7/G8/9/9/9/9/9/8K b P 1", "P*9b", ErrPawnDropMate},
+		{"4k4/9/9/9/4r4/9/4G4/9/4K4 b - 1", "5g4f", ErrLeftInCheck},
+		{"4k4/9/9/9/4r4/9/4G4/9/4K4 b - 1", "5g5f", nil},
+		{"4k4/9/9/9/9/9/4P4/9/4K4 b P 1", "P*5e", ErrTwoPawns},
+		{"4k4/9/9/9/9/9/9/9/4K4 b N 1", "N*5b", ErrDeadPiece},
+	}
+	for _, test := range tests {
+		p, err := ParseSfen(test.sfen)
+		if err != nil {
+			t.Fatalf("%s: %v", test.sfen, err)
+		}
+		m, err := p.ParseMove(test.move)
+		if err != nil {
+			t.Fatalf("%s %s: %v", test.sfen, test.move, err)
+		}
+		if err := p.CheckLegal(m); !errors.Is(err, test.err) {
+			t.Errorf("%s %s: got %v, want %v", test.sfen, test.move, err, test.err)
+		}
+	}
+}
+
+func TestKingCapture(t *testing.T) {
+	// white is left in check by the rook, which can capture the king
+	p, err := ParseSfen("4k4/9/9/9/9/9/9/9/4R3K b - 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.ParseMove("5i5a"); !errors.Is(err, ErrKingCapture) {
+		t.Errorf("ParseMove: got %v, want %v", err, ErrKingCapture)
+	}
+	from, _ := ParseSquare("5i")
+	to, _ := ParseSquare("5a")
+	if err := p.CheckLegal(Move{From: from, To: to}); !errors.Is(err, ErrKingCapture) {
+		t.Errorf("CheckLegal: got %v, want %v", err, ErrKingCapture)
+	}
+	for _, m := range p.LegalMoves() {
+		if m.To == to {
+			t.Errorf("LegalMoves: %v captures the king", m)
+		}
+	}
+}
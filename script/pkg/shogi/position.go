@@ -0,0 +1,328 @@
+package shogi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StartSfen is the SFEN of the initial position of an even game.
+const StartSfen = "lnsgkgsnl/1r5b1/ppppppppp/9/9/9/PPPPPPPPP/1B5R1/LNSGKGSNL b - 1"
+
+// Position is a board, the pieces in hand of both sides and the side to move. It is a value
+// type, so a copy can be made by assignment.
+type Position struct {
+	board [81]Piece
+	hands [2][King]int
+	side  Color
+	ply   int
+}
+
+var sfenPieceTypes = map[byte]PieceType{
+	'P': Pawn, 'L': Lance, 'N': Knight, 'S': Silver, 'G': Gold, 'B': Bishop, 'R': Rook, 'K': King,
+}
+
+// NewPosition returns an empty board with no pieces in hand and Black to move.
+func NewPosition() *Position {
+	return &Position{ply: 1}
+}
+
+// ParseSfen parses an SFEN such as "lnsgkgsnl/... b - 1". "startpos" is accepted as the initial
+// position and the move number may be omitted.
+func ParseSfen(sfen string) (*Position, error) {
+	if sfen == "startpos" {
+		sfen = StartSfen
+	}
+	fields := strings.Fields(sfen)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid sfen: %s", sfen)
+	}
+
+	p := &Position{ply: 1}
+	if err := p.parseBoard(fields[0]); err != nil {
+		return nil, err
+	}
+	switch fields[1] {
+	case "b":
+		p.side = Black
+	case "w":
+		p.side = White
+	default:
+		return nil, fmt.Errorf("invalid side to move: %s", fields[1])
+	}
+	if err := p.parseHands(fields[2]); err != nil {
+		return nil, err
+	}
+	if len(fields) > 3 {
+		ply, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid move number: %s", fields[3])
+		}
+		p.ply = ply
+	}
+
+	return p, nil
+}
+
+func (p *Position) parseBoard(board string) error {
+	ranks := strings.Split(board, "/")
+	if len(ranks) != 9 {
+		return fmt.Errorf("invalid board: %s", board)
+	}
+
+	for r, rank := range ranks {
+		file := 9
+		promoted := false
+		for i := 0; i < len(rank); i++ {
+			c := rank[i]
+			switch {
+			case c >= '1' && c <= '9':
+				if promoted {
+					return fmt.Errorf("invalid board: %s", board)
+				}
+				file -= int(c - '0')
+			case c == '+':
+				promoted = true
+			default:
+				color := Black
+				if c >= 'a' && c <= 'z' {
+					color = White
+					c = c - 'a' + 'A'
+				}
+				t, ok := sfenPieceTypes[c]
+				if !ok || file < 1 || (promoted && !t.CanPromote()) {
+					return fmt.Errorf("invalid board: %s", board)
+				}
+				if promoted {
+					t = t.Promoted()
+				}
+				p.board[NewSquare(file, r+1)] = Piece{Type: t, Color: color}
+				file--
+				promoted = false
+			}
+		}
+		if file != 0 || promoted {
+			return fmt.Errorf("invalid board: %s", board)
+		}
+	}
+
+	return nil
+}
+
+func (p *Position) parseHands(hands string) error {
+	if hands == "-" {
+		return nil
+	}
+
+	count := 0
+	for i := 0; i < len(hands); i++ {
+		c := hands[i]
+		if c >= '0' && c <= '9' {
+			count = count*10 + int(c-'0')
+			continue
+		}
+		color := Black
+		if c >= 'a' && c <= 'z' {
+			color = White
+			c = c - 'a' + 'A'
+		}
+		t, ok := sfenPieceTypes[c]
+		if !ok || t == King {
+			return fmt.Errorf("invalid hand: %s", hands)
+		}
+		if count == 0 {
+			count = 1
+		}
+		p.hands[color][t] += count
+		count = 0
+	}
+	if count != 0 {
+		return fmt.Errorf("invalid hand: %s", hands)
+	}
+
+	return nil
+}
+
+// Sfen returns the SFEN of p.
+func (p *Position) Sfen() string {
+	var b strings.Builder
+	for rank := 1; rank <= 9; rank++ {
+		if rank > 1 {
+			b.WriteByte('/')
+		}
+		empty := 0
+		for file := 9; file >= 1; file-- {
+			piece := p.board[NewSquare(file, rank)]
+			if piece.IsEmpty() {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				b.WriteString(strconv.Itoa(empty))
+				empty = 0
+			}
+			b.WriteString(piece.String())
+		}
+		if empty > 0 {
+			b.WriteString(strconv.Itoa(empty))
+		}
+	}
+
+	hands := p.HandSfen(Black) + p.HandSfen(White)
+	if hands == "" {
+		hands = "-"
+	}
+
+	return fmt.Sprintf("%s %v %s %d", b.String(), p.side, hands, p.ply)
+}
+
+// HandSfen returns the pieces in the hand of color in SFEN, e.g. "G2P", or "" if it is empty.
+func (p *Position) HandSfen(color Color) string {
+	hand := ""
+	for _, t := range HandTypes {
+		n := p.hands[color][t]
+		if n == 0 {
+			continue
+		}
+		if n > 1 {
+			hand += strconv.Itoa(n)
+		}
+		hand += Piece{Type: t, Color: color}.String()
+	}
+	return hand
+}
+
+func (p *Position) Piece(sq Square) Piece {
+	return p.board[sq]
+}
+
+// SetPiece puts piece on sq, or empties sq if piece is NoPiece.
+func (p *Position) SetPiece(sq Square, piece Piece) {
+	p.board[sq] = piece
+}
+
+// Hand returns the number of pieces of type t in the hand of color.
+func (p *Position) Hand(color Color, t PieceType) int {
+	return p.hands[color][t]
+}
+
+// SetHand sets the number of pieces of type t in the hand of color.
+func (p *Position) SetHand(color Color, t PieceType, n int) {
+	p.hands[color][t] = n
+}
+
+// GiveHand moves a piece of type t from the hand of color to the hand of its opponent. It returns
+// false if color has no such piece.
+func (p *Position) GiveHand(color Color, t PieceType) bool {
+	if p.hands[color][t] == 0 {
+		return false
+	}
+	p.hands[color][t]--
+	p.hands[color.Opponent()][t]++
+	return true
+}
+
+func (p *Position) SideToMove() Color {
+	return p.side
+}
+
+func (p *Position) SetSideToMove(color Color) {
+	p.side = color
+}
+
+// King returns the square of the king of color, or NoSquare if it has no king, e.g. the
+// attacker of a tsume-shogi problem.
+func (p *Position) King(color Color) Square {
+	for sq := Square(0); sq < 81; sq++ {
+		if p.board[sq] == (Piece{Type: King, Color: color}) {
+			return sq
+		}
+	}
+	return NoSquare
+}
+
+// ParseMove parses a move in USI notation. The move must be consistent with the pieces on the
+// board and in hand, but it is not checked whether it is legal; see IsLegal.
+func (p *Position) ParseMove(usi string) (Move, error) {
+	if len(usi) == 4 && usi[1] == '*' {
+		t, ok := sfenPieceTypes[usi[0]]
+		if !ok || t == King {
+			return Move{}, fmt.Errorf("invalid move: %s", usi)
+		}
+		to, err := ParseSquare(usi[2:])
+		if err != nil {
+			return Move{}, fmt.Errorf("invalid move: %s", usi)
+		}
+		if p.hands[p.side][t] == 0 {
+			return Move{}, fmt.Errorf("no %v in hand: %s", t, usi)
+		}
+		if !p.board[to].IsEmpty() {
+			return Move{}, fmt.Errorf("drop on an occupied square: %s", usi)
+		}
+		return Move{From: NoSquare, To: to, Drop: t}, nil
+	}
+
+	if len(usi) != 4 && !(len(usi) == 5 && usi[4] == '+') {
+		return Move{}, fmt.Errorf("invalid move: %s", usi)
+	}
+	from, err := ParseSquare(usi[0:2])
+	if err != nil {
+		return Move{}, fmt.Errorf("invalid move: %s", usi)
+	}
+	to, err := ParseSquare(usi[2:4])
+	if err != nil {
+		return Move{}, fmt.Errorf("invalid move: %s", usi)
+	}
+	piece := p.board[from]
+	if piece.IsEmpty() || piece.Color != p.side {
+		return Move{}, fmt.Errorf("no piece to move: %s", usi)
+	}
+	if target := p.board[to]; !target.IsEmpty() && target.Color == p.side {
+		return Move{}, fmt.Errorf("capturing an own piece: %s", usi)
+	} else if target.Type == King {
+		return Move{}, fmt.Errorf("%w: %s", ErrKingCapture, usi)
+	}
+	promote := len(usi) == 5
+	if promote && (!piece.Type.CanPromote() || !(inPromotionZone(p.side, from) || inPromotionZone(p.side, to))) {
+		return Move{}, fmt.Errorf("invalid promotion: %s", usi)
+	}
+
+	return Move{From: from, To: to, Promote: promote}, nil
+}
+
+// Do plays m without checking whether it is legal. m must not capture the king, which
+// ParseMove and CheckLegal reject.
+func (p *Position) Do(m Move) {
+	if m.IsDrop() {
+		p.board[m.To] = Piece{Type: m.Drop, Color: p.side}
+		p.hands[p.side][m.Drop]--
+	} else {
+		piece := p.board[m.From]
+		if captured := p.board[m.To]; !captured.IsEmpty() {
+			p.hands[p.side][captured.Type.Unpromoted()]++
+		}
+		if m.Promote {
+			piece.Type = piece.Type.Promoted()
+		}
+		p.board[m.To] = piece
+		p.board[m.From] = NoPiece
+	}
+	p.side = p.side.Opponent()
+	p.ply++
+}
+
+// DoUsi parses and plays a sequence of moves in USI notation, returning an error at the first
+// illegal move.
+func (p *Position) DoUsi(moves []string) error {
+	for i, usi := range moves {
+		m, err := p.ParseMove(usi)
+		if err != nil {
+			return fmt.Errorf("move %d: %v", i+1, err)
+		}
+		if err := p.CheckLegal(m); err != nil {
+			return fmt.Errorf("move %d: %v", i+1, err)
+		}
+		p.Do(m)
+	}
+	return nil
+}
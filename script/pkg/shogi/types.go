@@ -0,0 +1,166 @@
+// Package shogi implements a shogi board with SFEN parsing and legal move generation, so
+// that answers of engines can be verified instead of being treated as opaque strings.
+package shogi
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Color int8
+
+const (
+	Black Color = iota // sente, the attacker in tsume-shogi
+	White              // gote
+)
+
+func (c Color) Opponent() Color {
+	return 1 - c
+}
+
+func (c Color) String() string {
+	if c == Black {
+		return "b"
+	}
+	return "w"
+}
+
+type PieceType int8
+
+const (
+	NoPieceType PieceType = iota
+	Pawn
+	Lance
+	Knight
+	Silver
+	Gold
+	Bishop
+	Rook
+	King
+	ProPawn
+	ProLance
+	ProKnight
+	ProSilver
+	Horse
+	Dragon
+)
+
+// HandTypes are the piece types which can be held in hand, in the order of SFEN hands.
+var HandTypes = []PieceType{Rook, Bishop, Gold, Silver, Knight, Lance, Pawn}
+
+var pieceLetters = map[PieceType]string{
+	Pawn: "P", Lance: "L", Knight: "N", Silver: "S", Gold: "G", Bishop: "B", Rook: "R", King: "K",
+	ProPawn: "+P", ProLance: "+L", ProKnight: "+N", ProSilver: "+S", Horse: "+B", Dragon: "+R",
+}
+
+// CanPromote returns true if t is an unpromoted piece which has a promoted form.
+func (t PieceType) CanPromote() bool {
+	return t >= Pawn && t <= Rook && t != Gold
+}
+
+func (t PieceType) IsPromoted() bool {
+	return t >= ProPawn
+}
+
+func (t PieceType) Promoted() PieceType {
+	switch t {
+	case Pawn, Lance, Knight, Silver:
+		return t - Pawn + ProPawn
+	case Bishop:
+		return Horse
+	case Rook:
+		return Dragon
+	default:
+		return t
+	}
+}
+
+func (t PieceType) Unpromoted() PieceType {
+	switch t {
+	case ProPawn, ProLance, ProKnight, ProSilver:
+		return t - ProPawn + Pawn
+	case Horse:
+		return Bishop
+	case Dragon:
+		return Rook
+	default:
+		return t
+	}
+}
+
+// String returns the uppercase SFEN letter of t, e.g. "P" or "+R".
+func (t PieceType) String() string {
+	return pieceLetters[t]
+}
+
+type Piece struct {
+	Type  PieceType
+	Color Color
+}
+
+var NoPiece = Piece{}
+
+func (p Piece) IsEmpty() bool {
+	return p.Type == NoPieceType
+}
+
+// String returns the SFEN notation of p: uppercase for Black and lowercase for White.
+func (p Piece) String() string {
+	letter := p.Type.String()
+	if p.Color == White {
+		return strings.ToLower(letter)
+	}
+	return letter
+}
+
+// Square is an index of the 81 squares. File 1 is the right edge and rank 1 ("a") is the
+// top edge seen from Black.
+type Square int8
+
+const NoSquare Square = -1
+
+func NewSquare(file int, rank int) Square {
+	return Square((file-1)*9 + (rank - 1))
+}
+
+func (sq Square) File() int {
+	return int(sq)/9 + 1
+}
+
+func (sq Square) Rank() int {
+	return int(sq)%9 + 1
+}
+
+func (sq Square) String() string {
+	return fmt.Sprintf("%d%c", sq.File(), 'a'+sq.Rank()-1)
+}
+
+func ParseSquare(s string) (Square, error) {
+	if len(s) != 2 || s[0] < '1' || s[0] > '9' || s[1] < 'a' || s[1] > 'i' {
+		return NoSquare, fmt.Errorf("invalid square: %s", s)
+	}
+	return NewSquare(int(s[0]-'0'), int(s[1]-'a'+1)), nil
+}
+
+// Move is a move on a board. A drop has Drop set and From is NoSquare.
+type Move struct {
+	From    Square
+	To      Square
+	Drop    PieceType
+	Promote bool
+}
+
+func (m Move) IsDrop() bool {
+	return m.Drop != NoPieceType
+}
+
+// String returns the USI notation of m, e.g. "7g7f", "8h2b+" or "P*5e".
+func (m Move) String() string {
+	if m.IsDrop() {
+		return m.Drop.String() + "*" + m.To.String()
+	}
+	if m.Promote {
+		return m.From.String() + m.To.String() + "+"
+	}
+	return m.From.String() + m.To.String()
+}
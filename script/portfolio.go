@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// PortfolioConfig is an engine configuration of --portfolio, e.g. "hash=4096,Threads=4".
+type PortfolioConfig struct {
+	spec      string
+	hash_size int
+	options   []EngineOption
+}
+
+// parsePortfolio parses the arguments of --portfolio, each of which is "hash=N" and engine
+// options separated by commas.
+func parsePortfolio(args []string) ([]PortfolioConfig, error) {
+	var configs []PortfolioConfig
+	for _, arg := range args {
+		config := PortfolioConfig{spec: arg}
+		var option_args []string
+		for _, field := range strings.Split(arg, ",") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(field), "hash="); ok {
+				hash_size, err := strconv.Atoi(value)
+				if err != nil || hash_size <= 0 {
+					return nil, fmt.Errorf("invalid hash size in the portfolio %q", arg)
+				}
+				config.hash_size = hash_size
+			} else if field != "" {
+				option_args = append(option_args, field)
+			}
+		}
+		options, err := parseEngineOptions(option_args)
+		if err != nil {
+			return nil, fmt.Errorf("portfolio %q: %v", arg, err)
+		}
+		config.options = options
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// solvePortfolio solves problem with an engine for each of op.Portfolio at the same
+// time, and returns the first answer verified, cancelling the other engines. res is returned
+// as it is if none of them solve it. start_engine starts an engine in the working directory
+// of the worker.
+func solvePortfolio(logger *slog.Logger, start_engine func() (*EngineProcess, error), op Options, problem Problem, res Result, time_limit_ms int) Result {
+	logger.Info("solving with the portfolio", "configs", len(op.Portfolio), "category", res.Category())
+	cancel := make(chan struct{})
+	type candidate struct {
+		config PortfolioConfig
+		res    Result
+	}
+	candidates := make(chan candidate, len(op.Portfolio))
+	for _, config := range op.Portfolio {
+		go func(config PortfolioConfig) {
+			config_op := op
+			if config.hash_size > 0 {
+				config_op.HashSize = config.hash_size
+			}
+			config_op.EngineOptions = append(append([]EngineOption(nil), op.EngineOptions...), config.options...)
+
+			process, err := start_engine()
+			if err != nil {
+				candidates <- candidate{config, Result{Err: err}}
+				return
+			}
+			process.logger = logger
+			err = process.SetOption(config_op)
+			if err == nil {
+				err = process.ApplyProblemOptions(config_op, problem)
+			}
+			if err != nil {
+				process.Kill()
+				candidates <- candidate{config, Result{Err: err}}
+				return
+			}
+			process.abort = cancel
+			res := process.Solve(problem, time_limit_ms)
+			if process.killed || errors.Is(res.Err, errCrashed) {
+				process.Kill()
+			} else {
+				process.Quit()
+			}
+			res.Problem = problem
+			candidates <- candidate{config, verifyResult(res)}
+		}(config)
+	}
+
+	// the cancelled engines are stopped and quit in the background
+	for range op.Portfolio {
+		c := <-candidates
+		logger.Debug("portfolio finished", "config", c.config.spec, "status", c.res.Status(), "error", c.res.Err)
+		if c.res.Err == nil {
+			logger.Info("solved by the portfolio", "config", c.config.spec)
+			close(cancel)
+			c.res.Portfolio = c.config.spec
+			return c.res
+		}
+	}
+	return res
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type Problem struct {
+	Sfen       string
+	Moves      []string
+	ID         string
+	MateLen    int
+	FirstMove  string
+	NoMate     bool
+	DepthLimit *int
+	HashSize   *int
+	Tags       []string
+	Source     string
+	// Difficulty is the estimated solve time in seconds (0: unknown).
+	Difficulty float64
+
+	db_path string
+	db_id   int64
+}
+
+type jsonProblem struct {
+	ID         string   `json:"id"`
+	Sfen       string   `json:"sfen"`
+	MateLen    int      `json:"mate_len"`
+	FirstMove  string   `json:"first_move"`
+	NoMate     bool     `json:"nomate"`
+	DepthLimit *int     `json:"depth_limit"`
+	HashSize   *int     `json:"hash"`
+	Tags       []string `json:"tags"`
+	Difficulty float64  `json:"difficulty"`
+}
+
+func parseJsonProblem(line string) (Problem, error) {
+	var record jsonProblem
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return Problem{}, err
+	}
+
+	problem, err := parseProblem(record.Sfen)
+	if err != nil {
+		return Problem{}, err
+	}
+	problem.ID = record.ID
+	problem.MateLen = record.MateLen
+	problem.FirstMove = record.FirstMove
+	problem.NoMate = record.NoMate
+	problem.DepthLimit = record.DepthLimit
+	problem.HashSize = record.HashSize
+	problem.Tags = record.Tags
+	problem.Difficulty = record.Difficulty
+
+	return problem, nil
+}
+
+func parseProblem(line string) (Problem, error) {
+	tokens := strings.Fields(line)
+	if len(tokens) > 0 && tokens[0] == "position" {
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 0 {
+		return Problem{}, fmt.Errorf("empty position: %q", line)
+	}
+
+	var problem Problem
+	switch tokens[0] {
+	case "startpos":
+		problem.Sfen = "startpos"
+		tokens = tokens[1:]
+	default:
+		if tokens[0] == "sfen" {
+			tokens = tokens[1:]
+		}
+		i := 0
+		for i < len(tokens) && tokens[i] != "moves" {
+			i++
+		}
+		if i < 3 {
+			return Problem{}, fmt.Errorf("invalid sfen: %q", line)
+		}
+		problem.Sfen = strings.Join(tokens[:i], " ")
+		tokens = tokens[i:]
+	}
+
+	if len(tokens) > 0 {
+		if tokens[0] != "moves" {
+			return Problem{}, fmt.Errorf("unexpected token %q: %q", tokens[0], line)
+		}
+		problem.Moves = tokens[1:]
+	}
+
+	return problem, nil
+}
+
+func (p Problem) Position() string {
+	var sb strings.Builder
+	sb.WriteString("position ")
+	if p.Sfen == "startpos" {
+		sb.WriteString("startpos")
+	} else {
+		sb.WriteString("sfen ")
+		sb.WriteString(p.Sfen)
+	}
+	if len(p.Moves) > 0 {
+		sb.WriteString(" moves ")
+		sb.WriteString(strings.Join(p.Moves, " "))
+	}
+
+	return sb.String()
+}
+
+func (p Problem) Metadata() string {
+	var items []string
+	if p.ID != "" {
+		items = append(items, "id="+p.ID)
+	}
+	if p.MateLen > 0 {
+		items = append(items, fmt.Sprintf("mate_len=%d", p.MateLen))
+	}
+	if p.FirstMove != "" {
+		items = append(items, "first_move="+p.FirstMove)
+	}
+	if p.NoMate {
+		items = append(items, "nomate")
+	}
+	if p.DepthLimit != nil {
+		items = append(items, fmt.Sprintf("depth_limit=%d", *p.DepthLimit))
+	}
+	if p.HashSize != nil {
+		items = append(items, fmt.Sprintf("hash=%d", *p.HashSize))
+	}
+	if len(p.Tags) > 0 {
+		items = append(items, "tags="+strings.Join(p.Tags, ","))
+	}
+
+	return strings.Join(items, " ")
+}
+
+func (p Problem) String() string {
+	position := p.Position()
+	if p.Sfen != "startpos" && len(p.Moves) == 0 {
+		position = "sfen " + p.Sfen
+	}
+
+	if metadata := p.Metadata(); metadata != "" {
+		return fmt.Sprintf("[%s] %s", metadata, position)
+	}
+	return position
+}
+
+func (p Problem) HasExpectation() bool {
+	return p.MateLen > 0 || p.FirstMove != "" || p.NoMate
+}
+
+// CheckAnswer compares pv with the expected answer and returns a description of the
+// difference, or an empty string if pv matches.
+func (p Problem) CheckAnswer(pv []string) string {
+	if p.NoMate {
+		return fmt.Sprintf("mate %d, expected nomate", len(pv))
+	}
+
+	var diffs []string
+	if p.MateLen > 0 && len(pv) != p.MateLen {
+		diffs = append(diffs, fmt.Sprintf("mate %d, expected %d", len(pv), p.MateLen))
+	}
+	if p.FirstMove != "" && (len(pv) == 0 || pv[0] != p.FirstMove) {
+		first_move := ""
+		if len(pv) > 0 {
+			first_move = pv[0]
+		}
+		diffs = append(diffs, fmt.Sprintf("first move %s, expected %s", first_move, p.FirstMove))
+	}
+
+	return strings.Join(diffs, ", ")
+}
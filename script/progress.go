@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+var progressKinds = []string{"bar", "tui", "json", "none"}
+
+func isProgressKind(kind string) bool {
+	for _, k := range progressKinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Progress reports the progress of a run as results are recorded.
+type Progress interface {
+	Add(res Result)
+	// SetTotal sets the number of the positions when it is known after the start.
+	SetTotal(total int)
+	// Print shows a line of the output, e.g. a failed position, along with the progress.
+	Print(line string)
+	Finish(summary Summary)
+}
+
+// newProgress returns the progress reporter of kind for total positions (-1: unknown).
+// In the TUI, an interrupt is left to the caller if graceful is set.
+func newProgress(kind string, total int, monitor *Monitor, w io.Writer, graceful bool) Progress {
+	switch kind {
+	case "json":
+		p := &jsonProgress{encoder: json.NewEncoder(w), total: total, start: time.Now()}
+		p.encoder.Encode(progressEvent{Event: "start", Total: total})
+		return p
+	case "tui":
+		return newTuiProgress(total, monitor, w, graceful)
+	case "none":
+		return nopProgress{}
+	default:
+		return newBarProgress(total, monitor)
+	}
+}
+
+const (
+	describeInterval  = time.Second
+	slowestSfenLength = 32
+	// etaMinSamples is the number of solved positions needed to estimate the remaining time
+	etaMinSamples = 3
+)
+
+// barProgress shows a progress bar described with the estimated remaining time and the
+// position which has been solved for the longest time, so that a stuck run can be noticed.
+//
+// The remaining time is estimated from the times which the engine took for the finished
+// positions instead of the linear rate of the bar, which is dominated by easy positions.
+type barProgress struct {
+	mu      sync.Mutex
+	bar     *progressbar.ProgressBar
+	monitor *Monitor
+	total   int
+	done    int
+	times   *TimeSample
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newBarProgress(total int, monitor *Monitor) *barProgress {
+	p := &barProgress{
+		monitor: monitor,
+		total:   total,
+		times:   newTimeSample(timeSampleSize),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if total >= 0 {
+		p.bar = progressbar.NewOptions(total,
+			progressbar.OptionSetWriter(os.Stderr),
+			progressbar.OptionShowCount(),
+			progressbar.OptionSetPredictTime(false),
+			progressbar.OptionOnCompletion(func() { fmt.Fprintln(os.Stderr) }))
+	} else {
+		p.bar = progressbar.Default(-1)
+	}
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(describeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.bar.Describe(p.description())
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// eta estimates the remaining time assuming that the remaining positions take as long as the
+// median or the mean of the finished ones, whichever is longer.
+func (p *barProgress) eta() (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.total < 0 || p.times.Len() < etaMinSamples {
+		return 0, false
+	}
+
+	per_position := p.times.Total() / time.Duration(p.times.Len())
+	if median := p.times.Percentile(50); median > per_position {
+		per_position = median
+	}
+	remaining := p.total - p.done
+	// retired workers solve no more positions
+	workers := max(p.monitor.Live(), 1)
+	return per_position * time.Duration(remaining) / time.Duration(workers), true
+}
+
+func (p *barProgress) description() string {
+	var parts []string
+	if eta, ok := p.eta(); ok {
+		parts = append(parts, fmt.Sprintf("eta %v", eta.Round(time.Second)))
+	}
+	if state, ok := p.monitor.Slowest(); ok {
+		position := problemLabel(state.Problem)
+		if len(position) > slowestSfenLength {
+			position = position[:slowestSfenLength] + "..."
+		}
+		parts = append(parts, fmt.Sprintf("slowest %.0fs: %s", time.Since(state.Start).Seconds(), position))
+	}
+	return strings.Join(parts, "  ")
+}
+
+func (p *barProgress) Add(res Result) {
+	p.mu.Lock()
+	p.done++
+	if !res.Cached && res.MirrorOf == nil {
+		p.times.Add(res.Time)
+	}
+	p.mu.Unlock()
+	p.bar.Add(1)
+}
+
+func (p *barProgress) SetTotal(total int) {
+	p.mu.Lock()
+	p.total = total
+	p.mu.Unlock()
+	p.bar.ChangeMax(total)
+}
+
+func (p *barProgress) Print(line string) {
+	fmt.Printf("\r%v\n", line)
+}
+
+func (p *barProgress) Finish(summary Summary) {
+	close(p.stop)
+	<-p.stopped
+}
+
+type nopProgress struct{}
+
+func (nopProgress) Add(res Result) {
+}
+
+func (nopProgress) SetTotal(total int) {
+}
+
+func (nopProgress) Print(line string) {
+}
+
+func (nopProgress) Finish(summary Summary) {
+}
+
+type progressEvent struct {
+	Event     string `json:"event"`
+	Done      int    `json:"done"`
+	Total     int    `json:"total"`
+	Position  string `json:"position,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Category  string `json:"category,omitempty"`
+	TimeMs    int64  `json:"time_ms,omitempty"`
+	ElapsedMs int64  `json:"elapsed_ms"`
+	Solved    int    `json:"solved,omitempty"`
+}
+
+// jsonProgress writes newline-delimited JSON events: "start" before the first result,
+// "result" for each result and "finish" with the number of solved positions at the end.
+type jsonProgress struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	done    int
+	total   int
+	start   time.Time
+}
+
+func (p *jsonProgress) Add(res Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.encoder.Encode(progressEvent{
+		Event:     "result",
+		Done:      p.done,
+		Total:     p.total,
+		Position:  problemLabel(res.Problem),
+		Status:    res.Status(),
+		Category:  res.Category(),
+		TimeMs:    res.Time.Milliseconds(),
+		ElapsedMs: time.Since(p.start).Milliseconds(),
+	})
+}
+
+func (p *jsonProgress) SetTotal(total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = total
+}
+
+func (p *jsonProgress) Print(line string) {
+}
+
+func (p *jsonProgress) Finish(summary Summary) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.encoder.Encode(progressEvent{
+		Event:     "finish",
+		Done:      p.done,
+		Total:     p.total,
+		ElapsedMs: time.Since(p.start).Milliseconds(),
+		Solved:    summary.solved,
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// raceTie is the difference of the solve times regarded as a tie, so that fluctuations of very
+// short searches do not decide the winner.
+const raceTie = 10 * time.Millisecond
+
+// raceWinner returns 0 or 1 for the engine which won the race of a and b, or -1 for a tie.
+// Solving wins against failing, and a faster solve wins against a slower one.
+func raceWinner(a Result, b Result) int {
+	switch {
+	case a.Err == nil && b.Err != nil:
+		return 0
+	case a.Err != nil && b.Err == nil:
+		return 1
+	case a.Err != nil && b.Err != nil:
+		return -1
+	case b.Time-a.Time > raceTie:
+		return 0
+	case a.Time-b.Time > raceTie:
+		return 1
+	}
+	return -1
+}
+
+// RaceReport returns the head-to-head report of the first two engines of c: the winner of
+// each position, the speedup of the second engine over the first one, and the positions
+// where they disagree about the answer.
+func (c *EngineComparison) RaceReport() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "A: %s\nB: %s\n\n", c.commands[0], c.commands[1])
+
+	width := len("position")
+	for _, problem := range c.problems {
+		if label := problemLabel(problem); len(label) > width {
+			width = len(label)
+		}
+	}
+	fmt.Fprintf(&sb, "%-*s  %18s  %18s  %9s  %6s\n", width, "position", "A", "B", "speedup", "winner")
+
+	wins := [2]int{}
+	ties := 0
+	var log_speedup float64
+	var time_a, time_b time.Duration
+	both := 0
+	var disagreements []string
+	for j, problem := range c.problems {
+		a, b := c.results[0][j], c.results[1][j]
+		speedup := "-"
+		if a.Err == nil && b.Err == nil && a.Time > 0 && b.Time > 0 {
+			ratio := a.Time.Seconds() / b.Time.Seconds()
+			speedup = fmt.Sprintf("%.2fx", ratio)
+			log_speedup += math.Log(ratio)
+			time_a += a.Time
+			time_b += b.Time
+			both += 1
+		}
+		winner := "-"
+		switch raceWinner(a, b) {
+		case 0:
+			winner = "A"
+			wins[0] += 1
+		case 1:
+			winner = "B"
+			wins[1] += 1
+		default:
+			ties += 1
+		}
+		fmt.Fprintf(&sb, "%-*s  %18s  %18s  %9s  %6s\n", width, problemLabel(problem),
+			fmt.Sprintf("%s %.2fs", compareOutcome(a), a.Time.Seconds()),
+			fmt.Sprintf("%s %.2fs", compareOutcome(b), b.Time.Seconds()), speedup, winner)
+
+		if !c.agree(j) {
+			disagreements = append(disagreements, fmt.Sprintf("A: %s, B: %s: %v",
+				raceAnswer(a), raceAnswer(b), problem))
+		}
+	}
+
+	if len(disagreements) > 0 {
+		sb.WriteString("\ndisagreements:\n")
+		for _, line := range disagreements {
+			fmt.Fprintf(&sb, "  %s\n", line)
+		}
+	}
+
+	fmt.Fprintf(&sb, "\nA wins: %d  B wins: %d  ties: %d  disagreements: %d\n", wins[0], wins[1], ties, len(disagreements))
+	if both > 0 {
+		fmt.Fprintf(&sb, "speedup of B (solved by both: %d): geometric mean %.2fx  total time %.2fx\n",
+			both, math.Exp(log_speedup/float64(both)), time_a.Seconds()/time_b.Seconds())
+	}
+
+	return sb.String()
+}
+
+func raceAnswer(res Result) string {
+	if res.Err != nil {
+		return res.Category()
+	}
+	return strings.Join(res.Pv, " ")
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RepeatBenchmark is the results of every problem solved several times with the same engine.
+type RepeatBenchmark struct {
+	problems []Problem
+	// runs[j] are the results of problems[j]
+	runs [][]Result
+}
+
+// repeatProblems solves every problem repeat times. If interleave is set, all problems are
+// solved once per round so that changes in the load of the machine affect every problem alike.
+// Otherwise each problem is solved repeat times in a row by one worker, which keeps the engine
+// warm and keeps the runs of a problem from competing with each other for the CPU.
+func repeatProblems(command string, op Options, problems []Problem, repeat int, interleave bool) *RepeatBenchmark {
+	b := &RepeatBenchmark{problems: problems, runs: make([][]Result, len(problems))}
+	if interleave {
+		for k := 0; k < repeat; k++ {
+			slog.Info("solving the positions", "round", k+1, "rounds", repeat)
+			for j, res := range solveAll(command, op, problems) {
+				b.runs[j] = append(b.runs[j], res)
+			}
+		}
+		return b
+	}
+
+	indices := make(chan int)
+	monitor := newMonitor(op.Process)
+	var wg sync.WaitGroup
+	for i := 0; i < op.Process; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			queue := newScheduler()
+			result_chan := make(chan Result)
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				solve(i, command, op, nil, monitor, nil, nil, queue, result_chan)
+			}()
+			for j := range indices {
+				for k := 0; k < repeat; k++ {
+					queue.Feed(problems[j])
+					b.runs[j] = append(b.runs[j], <-result_chan)
+				}
+			}
+			queue.Close()
+			<-stopped
+		}(i)
+	}
+	for j := range problems {
+		indices <- j
+	}
+	close(indices)
+	wg.Wait()
+	return b
+}
+
+// repeatStats returns the mean, the minimum and the sample standard deviation of values.
+func repeatStats(values []float64) (mean float64, minimum float64, sd float64) {
+	if len(values) == 0 {
+		return 0, 0, 0
+	}
+	minimum = values[0]
+	for _, v := range values {
+		mean += v
+		minimum = math.Min(minimum, v)
+	}
+	mean /= float64(len(values))
+	if len(values) > 1 {
+		for _, v := range values {
+			sd += (v - mean) * (v - mean)
+		}
+		sd = math.Sqrt(sd / float64(len(values)-1))
+	}
+	return mean, minimum, sd
+}
+
+// stats returns the statistics of the time (sec) and the nodes of the runs of problems[j].
+func (b *RepeatBenchmark) stats(j int) (solved int, times [3]float64, nodes [3]float64) {
+	var time_values, node_values []float64
+	for _, res := range b.runs[j] {
+		if res.Err == nil {
+			solved += 1
+		}
+		time_values = append(time_values, res.Time.Seconds())
+		node_values = append(node_values, float64(res.Nodes))
+	}
+	times[0], times[1], times[2] = repeatStats(time_values)
+	nodes[0], nodes[1], nodes[2] = repeatStats(node_values)
+	return solved, times, nodes
+}
+
+// String returns the table of the mean, the minimum and the standard deviation of the time
+// and the nodes of each position, followed by the totals.
+func (b *RepeatBenchmark) String() string {
+	var sb strings.Builder
+	width := len("position")
+	for _, problem := range b.problems {
+		if label := problemLabel(problem); len(label) > width {
+			width = len(label)
+		}
+	}
+	fmt.Fprintf(&sb, "%-*s  %6s  %9s  %9s  %8s  %12s  %12s  %10s\n", width, "position", "solved",
+		"mean", "min", "sd", "mean nodes", "min nodes", "sd nodes")
+
+	var total_mean, total_min float64
+	var cvs []float64
+	for j, problem := range b.problems {
+		solved, times, nodes := b.stats(j)
+		fmt.Fprintf(&sb, "%-*s  %6s  %8.3fs  %8.3fs  %7.3fs  %12.0f  %12.0f  %10.0f\n", width, problemLabel(problem),
+			fmt.Sprintf("%d/%d", solved, len(b.runs[j])), times[0], times[1], times[2], nodes[0], nodes[1], nodes[2])
+		total_mean += times[0]
+		total_min += times[1]
+		if times[0] > 0 {
+			cvs = append(cvs, times[2]/times[0])
+		}
+	}
+
+	fmt.Fprintf(&sb, "\ntotal time: mean %.2fs  min %.2fs\n", total_mean, total_min)
+	if len(cvs) > 0 {
+		var cv float64
+		for _, v := range cvs {
+			cv += v
+		}
+		fmt.Fprintf(&sb, "mean coefficient of variation of the time: %.1f%% (speedups below it are noise)\n", 100*cv/float64(len(cvs)))
+	}
+	return sb.String()
+}
+
+// WriteCsv writes the statistics in CSV, one row per position. If appending is set, w already
+// contains rows and the header is omitted.
+func (b *RepeatBenchmark) WriteCsv(w io.Writer, appending bool) error {
+	writer := csv.NewWriter(w)
+	header := []string{"position", "runs", "solved", "time_mean_ms", "time_min_ms", "time_sd_ms", "nodes_mean", "nodes_min", "nodes_sd"}
+	if !appending {
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+	}
+	ms := func(seconds float64) string {
+		return strconv.FormatInt(time.Duration(seconds*float64(time.Second)).Milliseconds(), 10)
+	}
+	for j, problem := range b.problems {
+		solved, times, nodes := b.stats(j)
+		record := []string{problem.String(), strconv.Itoa(len(b.runs[j])), strconv.Itoa(solved),
+			ms(times[0]), ms(times[1]), ms(times[2]),
+			strconv.FormatFloat(nodes[0], 'f', 0, 64), strconv.FormatFloat(nodes[1], 'f', 0, 64), strconv.FormatFloat(nodes[2], 'f', 0, 64)}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var outFormats = []string{"text", "json", "csv", "junit", "html"}
+
+// ResultWriter writes a machine-readable record of each result into the output file.
+type ResultWriter interface {
+	Write(res Result) error
+	Close() error
+}
+
+func isOutFormat(format string) bool {
+	for _, f := range outFormats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isReportFormat returns true if format is written at once at the end of a run, which
+// means the output file cannot be appended to.
+func isReportFormat(format string) bool {
+	return format == "junit" || format == "html"
+}
+
+// newResultWriter returns the writer of format, or nil for "text" whose output is
+// written by the caller. If appending is set, w already contains records of the format.
+// info is recorded along with the results.
+func newResultWriter(format string, w io.Writer, appending bool, info RunInfo) ResultWriter {
+	switch format {
+	case "json":
+		return &jsonResultWriter{encoder: json.NewEncoder(w), info: info}
+	case "csv":
+		return &csvResultWriter{writer: csv.NewWriter(w), header_written: appending, info: info}
+	case "junit":
+		return &junitResultWriter{w: w, start: time.Now(), info: info}
+	case "html":
+		return &htmlResultWriter{w: w, start: time.Now(), info: info}
+	default:
+		return nil
+	}
+}
+
+type jsonEngine struct {
+	Name   string `json:"name,omitempty"`
+	Author string `json:"author,omitempty"`
+	Hash   string `json:"hash"`
+}
+
+type jsonCook struct {
+	Move    string `json:"move"`
+	MateLen int    `json:"mate_len"`
+}
+
+type jsonMudaai struct {
+	Ply  int    `json:"ply"`
+	Move string `json:"move"`
+	Kind string `json:"kind"`
+}
+
+type jsonSweep struct {
+	Option  string `json:"option"`
+	Value   int    `json:"value"`
+	Outcome string `json:"outcome"`
+}
+
+type jsonCrossCheck struct {
+	Outcome string   `json:"outcome"`
+	Pv      []string `json:"pv,omitempty"`
+}
+
+type jsonResult struct {
+	ID        string            `json:"id,omitempty"`
+	Sfen      string            `json:"sfen"`
+	Moves     []string          `json:"moves,omitempty"`
+	Tags      []string          `json:"tags,omitempty"`
+	Source    string            `json:"source,omitempty"`
+	Status    string            `json:"status"`
+	Category  string            `json:"category,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Mismatch  string            `json:"mismatch,omitempty"`
+	TimeMs    int64             `json:"time_ms"`
+	Nodes     int64             `json:"nodes"`
+	Nps       int64             `json:"nps"`
+	Hashfull  int               `json:"hashfull"`
+	MateLen   *int              `json:"mate_len,omitempty"`
+	Pv        []string          `json:"pv,omitempty"`
+	MirrorOf  string            `json:"mirror_of,omitempty"`
+	Cached    bool              `json:"cached,omitempty"`
+	Surplus   string            `json:"surplus,omitempty"`
+	Cooks     []jsonCook        `json:"cooks,omitempty"`
+	Mudaai    []jsonMudaai      `json:"mudaai,omitempty"`
+	Sweeps    []jsonSweep       `json:"sweeps,omitempty"`
+	Cross     *jsonCrossCheck   `json:"cross_check,omitempty"`
+	Retries   int               `json:"retries,omitempty"`
+	Attempt   int               `json:"attempt,omitempty"`
+	Portfolio string            `json:"portfolio,omitempty"`
+	Crashes   int               `json:"crashes,omitempty"`
+	Engine    jsonEngine        `json:"engine"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type jsonResultWriter struct {
+	encoder *json.Encoder
+	info    RunInfo
+}
+
+func (w *jsonResultWriter) Write(res Result) error {
+	problem := res.Problem
+	record := jsonResult{
+		ID:        problem.ID,
+		Sfen:      problem.Sfen,
+		Moves:     problem.Moves,
+		Tags:      problem.Tags,
+		Source:    problem.Source,
+		Status:    res.Status(),
+		Category:  res.Category(),
+		TimeMs:    res.Time.Milliseconds(),
+		Nodes:     res.Nodes,
+		Nps:       res.Nps,
+		Hashfull:  res.Hashfull,
+		Cached:    res.Cached,
+		Surplus:   res.Surplus,
+		Retries:   res.Retries,
+		Attempt:   res.Attempt,
+		Portfolio: res.Portfolio,
+		Crashes:   res.Crashes,
+		Engine:    jsonEngine{Name: w.info.EngineName, Author: w.info.EngineAuthor, Hash: w.info.EngineHash},
+		Labels:    w.info.Labels,
+	}
+	if res.Err != nil {
+		record.Error = res.Err.Error()
+	} else {
+		mate_len := len(res.Pv)
+		record.MateLen = &mate_len
+		record.Pv = res.Pv
+		if problem.HasExpectation() {
+			record.Mismatch = problem.CheckAnswer(res.Pv)
+		}
+	}
+	for _, cook := range res.Cooks {
+		record.Cooks = append(record.Cooks, jsonCook{Move: cook.Move, MateLen: cook.MateLen})
+	}
+	for _, interposition := range res.Interpositions {
+		record.Mudaai = append(record.Mudaai, jsonMudaai{Ply: interposition.Ply, Move: interposition.Move, Kind: interposition.Kind})
+	}
+	for _, point := range res.Sweeps {
+		record.Sweeps = append(record.Sweeps, jsonSweep{Option: point.Option, Value: point.Value, Outcome: point.Outcome})
+	}
+	if res.CrossCheck != nil {
+		record.Cross = &jsonCrossCheck{Outcome: res.CrossCheck.Outcome, Pv: res.CrossCheck.Pv}
+	}
+	if res.MirrorOf != nil {
+		record.MirrorOf = res.MirrorOf.Sfen
+	}
+
+	return w.encoder.Encode(record)
+}
+
+func (w *jsonResultWriter) Close() error {
+	return nil
+}
+
+var csvResultHeader = []string{"position", "status", "time_ms", "nodes", "mate_len", "first_move", "pv", "surplus", "cooks", "mudaai", "sweeps", "cross_check"}
+
+// csvResultWriter writes a row per result. The engine identity and each label of the run are
+// added as columns.
+type csvResultWriter struct {
+	writer         *csv.Writer
+	header_written bool
+	info           RunInfo
+}
+
+func (w *csvResultWriter) Write(res Result) error {
+	if !w.header_written {
+		header := append([]string(nil), csvResultHeader...)
+		for _, property := range w.info.Properties() {
+			header = append(header, property[0])
+		}
+		if err := w.writer.Write(header); err != nil {
+			return err
+		}
+		w.header_written = true
+	}
+
+	mate_len := ""
+	first_move := ""
+	if res.Err == nil {
+		mate_len = fmt.Sprint(len(res.Pv))
+		if len(res.Pv) > 0 {
+			first_move = res.Pv[0]
+		}
+	}
+	record := []string{
+		problemKey(res.Problem.Sfen, res.Problem.Moves),
+		res.Status(),
+		fmt.Sprint(res.Time.Milliseconds()),
+		fmt.Sprint(res.Nodes),
+		mate_len,
+		first_move,
+		strings.Join(res.Pv, " "),
+		res.Surplus,
+		formatCooks(res.Cooks),
+		formatInterpositions(res.Interpositions),
+		formatSweeps(res.Sweeps),
+		res.CrossCheck.String(),
+	}
+	for _, property := range w.info.Properties() {
+		record = append(record, property[1])
+	}
+	if err := w.writer.Write(record); err != nil {
+		return err
+	}
+	w.writer.Flush()
+
+	return w.writer.Error()
+}
+
+func (w *csvResultWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type junitFailure struct {
+	Type    string `xml:"type,attr"`
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestSuite struct {
+	XMLName    xml.Name        `xml:"testsuite"`
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+// junitResultWriter collects results as test cases and writes them as a JUnit XML report
+// on Close. Wrong answers and timeouts are reported as failures, other engine errors
+// as errors, and positions skipped after --total-time-limit as skipped. The engine identity
+// and the labels of the run are written as the properties of the test suite.
+type junitResultWriter struct {
+	w     io.Writer
+	start time.Time
+	info  RunInfo
+	suite junitTestSuite
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func (w *junitResultWriter) Write(res Result) error {
+	problem := res.Problem
+	test_case := junitTestCase{
+		Name:      problemKey(problem.Sfen, problem.Moves),
+		ClassName: "mate",
+		Time:      junitSeconds(res.Time),
+	}
+	if problem.ID != "" {
+		test_case.Name = problem.ID
+		test_case.SystemOut = problemKey(problem.Sfen, problem.Moves) + "\n"
+	}
+	if res.Err == nil {
+		test_case.SystemOut += "checkmate " + strings.Join(res.Pv, " ")
+	}
+	if problem.Source != "" {
+		test_case.ClassName = problem.Source
+	}
+
+	switch {
+	case res.Err == nil:
+		if problem.HasExpectation() {
+			if mismatch := problem.CheckAnswer(res.Pv); problem.NoMate {
+				test_case.Failure = &junitFailure{Type: "false_mate", Message: mismatch, Text: problem.String()}
+			} else if mismatch != "" {
+				test_case.Failure = &junitFailure{Type: "mismatch", Message: mismatch, Text: problem.String()}
+			}
+		}
+	case errors.Is(res.Err, errSkipped):
+		test_case.Skipped = &junitSkipped{Message: res.Err.Error()}
+	case problem.NoMate && errors.Is(res.Err, errNoMate):
+	case errors.Is(res.Err, errNoMate), errors.Is(res.Err, errTimeout), errors.Is(res.Err, errTimeLimit), errors.Is(res.Err, errNodesLimit),
+		errors.Is(res.Err, errIllegalPv), errors.Is(res.Err, errNotMate),
+		errors.Is(res.Err, errPawnDrop), errors.Is(res.Err, errInconsistentMate),
+		errors.Is(res.Err, errRepetition), errors.Is(res.Err, errNonOptimalDefense):
+		test_case.Failure = &junitFailure{Type: res.Category(), Message: res.Err.Error(), Text: problem.String()}
+	default:
+		test_case.Error = &junitFailure{Type: res.Category(), Message: res.Err.Error(), Text: problem.String()}
+	}
+
+	if test_case.Failure != nil {
+		w.suite.Failures++
+	}
+	if test_case.Error != nil {
+		w.suite.Errors++
+	}
+	if test_case.Skipped != nil {
+		w.suite.Skipped++
+	}
+	w.suite.Tests++
+	w.suite.TestCases = append(w.suite.TestCases, test_case)
+
+	return nil
+}
+
+func (w *junitResultWriter) Close() error {
+	w.suite.Name = "mate"
+	w.suite.Time = junitSeconds(time.Since(w.start))
+	w.suite.Timestamp = w.start.Format("2006-01-02T15:04:05")
+	for _, property := range w.info.Properties() {
+		w.suite.Properties = append(w.suite.Properties, junitProperty{Name: property[0], Value: property[1]})
+	}
+
+	if _, err := io.WriteString(w.w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w.w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(w.suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w.w, "\n")
+	return err
+}
+
+// writeResults writes results in format, or the text report, appending to path if appending
+// is set.
+func writeResults(path string, format string, results []Result, report string, info RunInfo, appending bool) error {
+	file, err := openBufferedFile(path, appending)
+	if err != nil {
+		return err
+	}
+	size, err := file.Size()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	writer := newResultWriter(format, file, size > 0, info)
+	if writer == nil {
+		_, err = io.WriteString(file, report)
+	} else {
+		for _, res := range results {
+			if err = writer.Write(res); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+)
+
+// minReproduceTimeLimit is the smallest time limit tried while shrinking reproductions.
+const minReproduceTimeLimit = 100
+
+// Reproduction is a reduced setting which still reproduces a wrong answer.
+type Reproduction struct {
+	Original  Problem
+	Problem   Problem
+	HashSize  int
+	TimeLimit int
+	Result    Result
+}
+
+// isWrongAnswer returns true if res is an answer which the engine should never return, as
+// opposed to a timeout or a crash of the engine.
+func isWrongAnswer(res Result) bool {
+	return res.Fatal() && res.Category() != "engine_error"
+}
+
+// sameWrongAnswer returns true if res reproduces the wrong answer original.
+func sameWrongAnswer(res Result, original Result) bool {
+	return isWrongAnswer(res) && res.Category() == original.Category()
+}
+
+// removeDefenderHandPiece returns sfen with a piece of type t removed from the defender's
+// hand, or false if the defender has no such piece.
+func removeDefenderHandPiece(sfen string, t shogi.PieceType) (string, bool) {
+	pos, err := shogi.ParseSfen(sfen)
+	if err != nil {
+		return sfen, false
+	}
+	defender := pos.SideToMove().Opponent()
+	if pos.Hand(defender, t) == 0 {
+		return sfen, false
+	}
+	pos.SetHand(defender, t, pos.Hand(defender, t)-1)
+
+	return pos.Sfen(), true
+}
+
+// Reproduce shrinks the hash size, the time limit and, if reduce_hand is set, the pieces in the
+// defender's hand of the wrong answer res while it is still reproduced. The hand is reduced
+// only for answers wrong by themselves, since the expected answer no longer holds for other
+// hands. The hash size is restored to current afterwards.
+func (ep *EngineProcess) Reproduce(res Result, current int, time_limit_ms int, reduce_hand bool) (Reproduction, error) {
+	if time_limit_ms == 0 {
+		time_limit_ms = int(2 * res.Time.Milliseconds())
+		if time_limit_ms < 1000 {
+			time_limit_ms = 1000
+		}
+	}
+	best := Reproduction{Original: res.Problem, Problem: res.Problem, HashSize: current, TimeLimit: time_limit_ms, Result: res}
+	defer func() {
+		fmt.Fprintf(ep.stdin, "setoption name USI_Hash value %d\n", current)
+		ep.Ready()
+	}()
+
+	try := func(problem Problem, hash_size int, time_limit_ms int) (bool, error) {
+		fmt.Fprintf(ep.stdin, "setoption name USI_Hash value %d\n", hash_size)
+		if err := ep.Ready(); err != nil {
+			return false, err
+		}
+		start := time.Now()
+		other := ep.Solve(problem, time_limit_ms)
+		other.Problem = problem
+		other.Time = time.Since(start)
+		other = verifyResult(other)
+		if other.Category() == "engine_error" {
+			return false, other.Err
+		}
+		if !sameWrongAnswer(other, res) {
+			return false, nil
+		}
+		best = Reproduction{Original: res.Problem, Problem: problem, HashSize: hash_size, TimeLimit: time_limit_ms, Result: other}
+		return true, nil
+	}
+
+	for best.HashSize > 1 {
+		ok, err := try(best.Problem, best.HashSize/2, best.TimeLimit)
+		if err != nil {
+			return best, err
+		}
+		if !ok {
+			break
+		}
+	}
+	for best.TimeLimit/2 >= minReproduceTimeLimit {
+		ok, err := try(best.Problem, best.HashSize, best.TimeLimit/2)
+		if err != nil {
+			return best, err
+		}
+		if !ok {
+			break
+		}
+	}
+	if reduce_hand && res.Err != nil {
+		for _, t := range shogi.HandTypes {
+			for {
+				sfen, removed := removeDefenderHandPiece(best.Problem.Sfen, t)
+				if !removed {
+					break
+				}
+				problem := best.Problem
+				problem.Sfen = sfen
+				ok, err := try(problem, best.HashSize, best.TimeLimit)
+				if err != nil {
+					return best, err
+				}
+				if !ok {
+					break
+				}
+			}
+		}
+	}
+
+	return best, nil
+}
+
+// BugReport returns a Markdown snippet describing r, which can be pasted into an issue.
+// declared is the names of the options declared by the engine.
+func (r Reproduction) BugReport(command string, op Options, declared []string) string {
+	res := r.Result
+	answer := res.Category()
+	if res.Err == nil {
+		answer = res.Problem.CheckAnswer(res.Pv)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "### Wrong answer: %s\n\n", answer)
+	fmt.Fprintf(&sb, "- engine: `%s`\n", command)
+	fmt.Fprintf(&sb, "- position: `%s`\n", r.Problem.Position())
+	fmt.Fprintf(&sb, "- USI_Hash: %d, PostSearchCount: %d, DepthLimit: %d\n", r.HashSize, op.PostSearchCount, op.DepthLimit)
+	fmt.Fprintf(&sb, "- time limit: %d ms (reproduced in %.2f sec)\n", r.TimeLimit, res.Time.Seconds())
+	if res.Err != nil {
+		fmt.Fprintf(&sb, "- error: %v\n", res.Err)
+	}
+	if len(res.Pv) > 0 {
+		fmt.Fprintf(&sb, "- answer: `%s`\n", strings.Join(res.Pv, " "))
+	}
+	fmt.Fprintf(&sb, "\n```\nusi\n")
+	fmt.Fprintf(&sb, "setoption name USI_Hash value %d\n", r.HashSize)
+	fmt.Fprintf(&sb, "setoption name PostSearchCount value %d\n", op.PostSearchCount)
+	fmt.Fprintf(&sb, "setoption name DepthLimit value %d\n", op.DepthLimit)
+	for _, option := range engineOptions(op.EngineOptions, declared) {
+		fmt.Fprintf(&sb, "setoption name %s value %s\n", option.Name, option.Value)
+	}
+	fmt.Fprintf(&sb, "isready\n%s\ngo mate %d\n```\n", r.Problem.Position(), r.TimeLimit)
+
+	return sb.String()
+}
+
+func writeBugReport(dir string, r Reproduction, command string, op Options, declared []string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, solutionFileName(r.Original, ".md")), []byte(r.BugReport(command, op, declared)), 0644)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// resultsDBSchema is the schema of results databases, which keep the results of every run
+// for cross-run comparisons. A row of "runs" is added per run, and every result of the run
+// is appended into "results" with the ID of the run. "position" is the normalized position
+// so that the results of the same position can be joined across runs.
+const resultsDBSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id            INTEGER PRIMARY KEY,
+	started_at    TEXT NOT NULL,
+	finished_at   TEXT,
+	command       TEXT NOT NULL,
+	engine_id     TEXT NOT NULL,
+	engine_name   TEXT,
+	engine_author TEXT,
+	options       TEXT NOT NULL,
+	labels        TEXT
+);
+CREATE TABLE IF NOT EXISTS results (
+	run_id     INTEGER NOT NULL REFERENCES runs(id),
+	position   TEXT NOT NULL,
+	problem_id TEXT,
+	status     TEXT NOT NULL,
+	category   TEXT,
+	error      TEXT,
+	mate_len   INTEGER,
+	pv         TEXT,
+	time_ms    INTEGER NOT NULL,
+	nodes      INTEGER,
+	nps        INTEGER,
+	hashfull   INTEGER,
+	surplus    TEXT,
+	cooks      TEXT,
+	mudaai     TEXT,
+	cached     INTEGER NOT NULL,
+	created_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS results_position ON results(position);
+`
+
+// runColumns are added to "runs" tables created before the columns were introduced.
+var runColumns = [][2]string{{"labels", "TEXT"}, {"engine_name", "TEXT"}, {"engine_author", "TEXT"}}
+
+// resultColumns are added to "results" tables created before the columns were introduced.
+var resultColumns = [][2]string{{"surplus", "TEXT"}, {"cooks", "TEXT"}, {"mudaai", "TEXT"}}
+
+// ResultsDB appends the results of a run into a results database.
+type ResultsDB struct {
+	db     *sql.DB
+	run_id int64
+}
+
+func openResultsDB(path string, command string, op Options, info RunInfo) (*ResultsDB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(resultsDBSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := addMissingColumns(db, "runs", runColumns); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := addMissingColumns(db, "results", resultColumns); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	options, err := json.Marshal(op)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	var labels sql.NullString
+	if len(info.Labels) > 0 {
+		encoded, err := json.Marshal(info.Labels)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		labels = sql.NullString{String: string(encoded), Valid: true}
+	}
+	run, err := db.Exec(`
+		INSERT INTO runs (started_at, command, engine_id, engine_name, engine_author, options, labels)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().Format(time.RFC3339), command, info.EngineHash, info.EngineName, info.EngineAuthor,
+		string(options), labels)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	run_id, err := run.LastInsertId()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &ResultsDB{db: db, run_id: run_id}, nil
+}
+
+func (r *ResultsDB) Store(res Result) error {
+	var problem_id, category, err_text, pv, surplus, cooks, mudaai sql.NullString
+	var mate_len sql.NullInt64
+	if res.Problem.ID != "" {
+		problem_id = sql.NullString{String: res.Problem.ID, Valid: true}
+	}
+	if res.Err != nil {
+		category = sql.NullString{String: res.Category(), Valid: true}
+		err_text = sql.NullString{String: res.Err.Error(), Valid: true}
+	} else {
+		pv = sql.NullString{String: strings.Join(res.Pv, " "), Valid: true}
+		mate_len = sql.NullInt64{Int64: int64(len(res.Pv)), Valid: true}
+		surplus = sql.NullString{String: res.Surplus, Valid: true}
+		cooks = sql.NullString{String: formatCooks(res.Cooks), Valid: true}
+		mudaai = sql.NullString{String: formatInterpositions(res.Interpositions), Valid: true}
+	}
+
+	_, err := r.db.Exec(`
+		INSERT INTO results
+			(run_id, position, problem_id, status, category, error, mate_len, pv, time_ms,
+			 nodes, nps, hashfull, surplus, cooks, mudaai, cached, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.run_id, positionKey(res.Problem), problem_id, res.Status(), category, err_text, mate_len, pv,
+		res.Time.Milliseconds(), res.Nodes, res.Nps, res.Hashfull, surplus, cooks, mudaai, res.Cached,
+		time.Now().Format(time.RFC3339))
+
+	return err
+}
+
+// Close records the end time of the run.
+func (r *ResultsDB) Close() error {
+	_, err := r.db.Exec(`UPDATE runs SET finished_at = ? WHERE id = ?`, time.Now().Format(time.RFC3339), r.run_id)
+	if cerr := r.db.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
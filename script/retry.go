@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// retryPvInterval is the PvInterval (ms) added per retry, so that the engine reconstructs the
+// PV at different timings.
+const retryPvInterval = 1000
+
+// RetryNoPv solves problem again at most retries times while the engine fails to detect the PV
+// ("Failed to detect PV"), doubling the hash size and changing PvInterval for each retry. It
+// returns the last result and the number of retries.
+func (ep *EngineProcess) RetryNoPv(logger *slog.Logger, res Result, problem Problem, time_limit_ms int, retries int) (Result, int) {
+	retried := 0
+	for ; retried < retries && errors.Is(res.Err, errNoPv); retried++ {
+		hash_size := ep.hash_size * 2
+		pv_interval := (retried + 1) * retryPvInterval
+		if hang_ms := int(ep.hang_timeout.Milliseconds()); hang_ms > 0 {
+			// the hang detector needs info lines within the timeout
+			pv_interval = hang_ms / (retried + 2)
+		}
+		logger.Warn("retrying after failing to detect the pv", "retry", retried+1, "hash", hash_size, "pv_interval", pv_interval)
+
+		fmt.Fprintf(ep.stdin, "setoption name USI_Hash value %d\n", hash_size)
+		fmt.Fprintf(ep.stdin, "setoption name PvInterval value %d\n", pv_interval)
+		ep.hash_size = hash_size
+		if err := ep.Ready(); err != nil {
+			res.Err = err
+			break
+		}
+		res = ep.Solve(problem, time_limit_ms)
+	}
+	if retried > 0 {
+		ep.RestoreOption("PvInterval")
+	}
+
+	return res, retried
+}
+
+// RetryEscalating solves problem again at most retries times while the search runs out of the
+// hash, the time or the nodes, multiplying all of them by factor for each attempt. It returns the last
+// result and the attempt which gave it, counting the first solve as attempt 1.
+func (ep *EngineProcess) RetryEscalating(logger *slog.Logger, res Result, problem Problem, time_limit_ms int, retries int, factor float64) (Result, int) {
+	attempt := 1
+	nodes_limit := ep.nodes_limit
+	for ; attempt <= retries && survives(res) && !ep.killed; attempt++ {
+		hash_size := int(float64(ep.hash_size) * factor)
+		time_limit_ms = int(float64(time_limit_ms) * factor)
+		ep.nodes_limit = int64(float64(ep.nodes_limit) * factor)
+		logger.Info("retrying with more resources", "attempt", attempt+1, "category", res.Category(), "hash", hash_size, "time_limit", time_limit_ms, "nodes_limit", ep.nodes_limit)
+
+		fmt.Fprintf(ep.stdin, "setoption name USI_Hash value %d\n", hash_size)
+		if _, ok := ep.options["NodesLimit"]; ok {
+			fmt.Fprintf(ep.stdin, "setoption name NodesLimit value %d\n", ep.nodes_limit)
+		}
+		ep.hash_size = hash_size
+		if err := ep.Ready(); err != nil {
+			res.Err = err
+			break
+		}
+		res = ep.Solve(problem, time_limit_ms)
+	}
+	if ep.nodes_limit != nodes_limit {
+		ep.nodes_limit = nodes_limit
+		ep.RestoreOption("NodesLimit")
+	}
+
+	return res, attempt
+}
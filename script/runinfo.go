@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RunInfo is the metadata of a run recorded into the results, so that results produced by
+// different engine builds or machines can be told apart later.
+type RunInfo struct {
+	// EngineName and EngineAuthor are reported by the engine in the "usi" handshake.
+	EngineName   string
+	EngineAuthor string
+	// EngineHash is the SHA-256 of the engine binary.
+	EngineHash string
+	// EngineOptions are the names of the options declared in the "usi" handshake.
+	EngineOptions []string
+	// Labels are arbitrary key-value pairs given by --label.
+	Labels map[string]string
+}
+
+// identifyEngine starts command to ask its name and author, and hashes its binary.
+func identifyEngine(command string) (RunInfo, error) {
+	process, err := newEngineProcess(command)
+	if err != nil {
+		return RunInfo{}, err
+	}
+	name, author, err := process.Usi()
+	process.Quit()
+	if err != nil {
+		return RunInfo{}, fmt.Errorf("%s: %v", command, err)
+	}
+
+	return RunInfo{EngineName: name, EngineAuthor: author, EngineHash: engineID(command), EngineOptions: process.declared}, nil
+}
+
+func (info RunInfo) LabelKeys() []string {
+	keys := make([]string, 0, len(info.Labels))
+	for key := range info.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Properties returns the engine identity followed by the labels as name-value pairs.
+func (info RunInfo) Properties() [][2]string {
+	properties := [][2]string{
+		{"engine_name", info.EngineName},
+		{"engine_author", info.EngineAuthor},
+		{"engine_hash", info.EngineHash},
+	}
+	for _, key := range info.LabelKeys() {
+		properties = append(properties, [2]string{key, info.Labels[key]})
+	}
+	return properties
+}
+
+func (info RunInfo) String() string {
+	str := fmt.Sprintf("engine: %s", info.EngineName)
+	if info.EngineAuthor != "" {
+		str += fmt.Sprintf(" by %s", info.EngineAuthor)
+	}
+	str += fmt.Sprintf(" (%s)", info.EngineHash)
+
+	if len(info.Labels) > 0 {
+		pairs := make([]string, 0, len(info.Labels))
+		for _, key := range info.LabelKeys() {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", key, info.Labels[key]))
+		}
+		str += "  labels: " + strings.Join(pairs, " ")
+	}
+	return str
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+)
+
+// scaleWorkers adds a worker with spawn or retires one through retire for each signal to
+// scale the workers (see notifyScale) until done is closed. The last worker is never retired.
+func scaleWorkers(workers int, done <-chan struct{}, spawn func(), retire chan<- struct{}) {
+	signals := make(chan os.Signal, 1)
+	if !notifyScale(signals) {
+		return
+	}
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case sig := <-signals:
+			if isScaleUp(sig) {
+				workers += 1
+				spawn()
+				slog.Info("added a worker", "workers", workers)
+			} else if workers > 1 {
+				workers -= 1
+				slog.Info("retiring a worker after its current position", "workers", workers)
+				go func() {
+					select {
+					case retire <- struct{}{}:
+					case <-done:
+					}
+				}()
+			} else {
+				slog.Warn("the last worker cannot be retired")
+			}
+		case <-done:
+			return
+		}
+	}
+}
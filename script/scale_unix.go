@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyScale relays SIGUSR1, which adds a worker, and SIGUSR2, which retires one, to c.
+func notifyScale(c chan<- os.Signal) bool {
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	return true
+}
+
+func isScaleUp(sig os.Signal) bool {
+	return sig == syscall.SIGUSR1
+}
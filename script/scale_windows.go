@@ -0,0 +1,12 @@
+package main
+
+import "os"
+
+// notifyScale returns false since Windows has no signals to scale the workers.
+func notifyScale(c chan<- os.Signal) bool {
+	return false
+}
+
+func isScaleUp(sig os.Signal) bool {
+	return false
+}
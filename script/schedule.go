@@ -0,0 +1,206 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Orders of the positions given by --order.
+const (
+	orderInput        = "input"
+	orderHardestFirst = "hardest-first"
+)
+
+func isOrder(order string) bool {
+	return order == orderInput || order == orderHardestFirst
+}
+
+// estimateDifficulties returns the estimated solve time of each problem: the time of the
+// previous run in baseline if known, or the difficulty of the problem itself. Problems with
+// neither are estimated at the median of the others.
+func estimateDifficulties(problems []Problem, baseline map[string]baselineEntry) []time.Duration {
+	estimates := make([]time.Duration, len(problems))
+	var known []time.Duration
+	for i, problem := range problems {
+		if entry, ok := baseline[positionKey(problem)]; ok {
+			estimates[i] = entry.time
+		} else if problem.Difficulty > 0 {
+			estimates[i] = time.Duration(problem.Difficulty * float64(time.Second))
+		} else {
+			estimates[i] = -1
+			continue
+		}
+		known = append(known, estimates[i])
+	}
+
+	median := time.Duration(0)
+	if len(known) > 0 {
+		sort.Slice(known, func(i, j int) bool { return known[i] < known[j] })
+		median = known[len(known)/2]
+	}
+	for i := range estimates {
+		if estimates[i] < 0 {
+			estimates[i] = median
+		}
+	}
+	return estimates
+}
+
+// orderHardestFirstProblems sorts problems in the descending order of their estimated solve times.
+// Since idle workers take the next position, this is the longest-processing-time-first
+// schedule, which keeps a hard position from being left alone at the end of the run.
+func orderHardestFirstProblems(problems []Problem, estimates []time.Duration) []Problem {
+	indices := make([]int, len(problems))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(a, b int) bool { return estimates[indices[a]] > estimates[indices[b]] })
+
+	ordered := make([]Problem, len(problems))
+	for i, index := range indices {
+		ordered[i] = problems[index]
+	}
+	return ordered
+}
+
+// queueDepth is the number of positions queued for each worker ahead of its engine.
+const queueDepth = 2
+
+// Scheduler hands positions to the workers. Each worker has a deque of its own, to the shortest
+// of which positions are pushed, and takes the positions from the front of it. A worker whose
+// deque is empty steals the front of the longest deque of the others, so that no position waits
+// behind a hard one while some workers are idle. The deques of the workers which have left are
+// left to be stolen.
+type Scheduler struct {
+	mu     sync.Mutex
+	deques map[int][]Problem
+	// live is the workers which have joined and not left
+	live   map[int]bool
+	queued int
+	closed bool
+	// wake is closed and replaced whenever the deques change
+	wake chan struct{}
+}
+
+func newScheduler() *Scheduler {
+	return &Scheduler{deques: make(map[int][]Problem), live: make(map[int]bool), wake: make(chan struct{})}
+}
+
+func (s *Scheduler) notify() {
+	close(s.wake)
+	s.wake = make(chan struct{})
+}
+
+// Join adds worker to the workers taking positions.
+func (s *Scheduler) Join(worker int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.live[worker] = true
+	s.notify()
+}
+
+// Leave removes worker from the workers taking positions. The positions left in its deque are
+// stolen by the others.
+func (s *Scheduler) Leave(worker int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.live, worker)
+	s.notify()
+}
+
+// Push queues problem into the shortest deque of the live workers and returns true if there
+// is room. Otherwise it returns false and a channel closed when room may be made.
+func (s *Scheduler) Push(problem Problem) (bool, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.queued >= queueDepth*len(s.live) {
+		return false, s.wake
+	}
+
+	shortest := -1
+	for worker := range s.live {
+		if shortest < 0 || len(s.deques[worker]) < len(s.deques[shortest]) ||
+			(len(s.deques[worker]) == len(s.deques[shortest]) && worker < shortest) {
+			shortest = worker
+		}
+	}
+	s.deques[shortest] = append(s.deques[shortest], problem)
+	s.queued++
+	s.notify()
+	return true, nil
+}
+
+// Feed queues problem, waiting until there is room.
+func (s *Scheduler) Feed(problem Problem) {
+	for {
+		ok, wait := s.Push(problem)
+		if ok {
+			return
+		}
+		<-wait
+	}
+}
+
+// Take returns the next position of worker and true, stealing one from the others if its deque
+// is empty. If no position is queued, it returns false and a channel closed when a position may
+// be pushed, or nil if the scheduler is closed.
+func (s *Scheduler) Take(worker int) (Problem, bool, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	victim := worker
+	if len(s.deques[worker]) == 0 {
+		for other, deque := range s.deques {
+			if len(deque) > len(s.deques[victim]) || (len(deque) == len(s.deques[victim]) && len(deque) > 0 && other < victim) {
+				victim = other
+			}
+		}
+	}
+	deque := s.deques[victim]
+	if len(deque) == 0 {
+		if s.closed {
+			return Problem{}, false, nil
+		}
+		return Problem{}, false, s.wake
+	}
+
+	problem := deque[0]
+	if len(deque) == 1 {
+		delete(s.deques, victim)
+	} else {
+		s.deques[victim] = deque[1:]
+	}
+	s.queued--
+	s.notify()
+	return problem, true, nil
+}
+
+// Drain removes all the queued positions and returns them in the order of the workers.
+func (s *Scheduler) Drain() []Problem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	workers := make([]int, 0, len(s.deques))
+	for worker := range s.deques {
+		workers = append(workers, worker)
+	}
+	sort.Ints(workers)
+	var problems []Problem
+	for _, worker := range workers {
+		problems = append(problems, s.deques[worker]...)
+	}
+	s.deques = make(map[int][]Problem)
+	s.queued = 0
+	s.notify()
+	return problems
+}
+
+// Close tells the workers that no more positions are pushed. The queued positions are still
+// taken.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.notify()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// splitOptions make the engine search the positions after the first move of the attacker,
+// where the defender is to move.
+var splitOptions = []EngineOption{{Name: "RootIsAndNodeIfChecked", Value: "true"}}
+
+// splitProblem returns the position after each legal checking move of problem, whose mate
+// length limit is shortened by the move. If a check mates at once, it is returned instead.
+func splitProblem(problem Problem, depth_limit int) ([]Problem, string, error) {
+	pos, err := problemPosition(problem)
+	if err != nil {
+		return nil, "", err
+	}
+	if problem.DepthLimit != nil {
+		depth_limit = *problem.DepthLimit
+	}
+
+	var subproblems []Problem
+	for _, m := range pos.LegalMoves() {
+		next := *pos
+		next.Do(m)
+		if !next.InCheck() {
+			continue
+		}
+		if next.IsCheckmate() {
+			return nil, m.String(), nil
+		}
+		subproblem := Problem{
+			Sfen:     problem.Sfen,
+			Moves:    append(append([]string(nil), problem.Moves...), m.String()),
+			ID:       problem.ID,
+			HashSize: problem.HashSize,
+			Source:   problem.Source,
+		}
+		if depth_limit > 0 {
+			limit := depth_limit - 1
+			subproblem.DepthLimit = &limit
+		}
+		subproblems = append(subproblems, subproblem)
+	}
+	return subproblems, "", nil
+}
+
+// SplitResult is the result of a problem solved by splitting it at the first move.
+type SplitResult struct {
+	moves    []string
+	results  []Result
+	combined Result
+	// unresolved is the number of the first moves whose outcome is unknown
+	unresolved int
+}
+
+// solveSplit solves the position after each checking move of problem on all workers, and
+// combines the results: the shortest of the mates, or nomate if no check leads to a mate.
+func solveSplit(command string, op Options, problem Problem) (SplitResult, error) {
+	subproblems, mate, err := splitProblem(problem, op.DepthLimit)
+	if err != nil {
+		return SplitResult{}, err
+	}
+	if mate != "" {
+		return SplitResult{combined: verifyResult(Result{Problem: problem, Pv: []string{mate}})}, nil
+	}
+	slog.Info("splitting the problem at the first move", "position", problemLabel(problem), "checks", len(subproblems))
+
+	start := time.Now()
+	op.EngineOptions = append(append([]EngineOption(nil), op.EngineOptions...), splitOptions...)
+	s := SplitResult{results: solveAll(command, op, subproblems)}
+	s.combined = Result{Problem: problem, Err: errNoMate, Time: time.Since(start)}
+	var unresolved error
+	for i, res := range s.results {
+		move := subproblems[i].Moves[len(subproblems[i].Moves)-1]
+		s.moves = append(s.moves, move)
+		s.combined.Nodes += res.Nodes
+		switch {
+		case res.Err == nil:
+			if s.combined.Err != nil || 1+len(res.Pv) < len(s.combined.Pv) {
+				s.combined.Pv = append([]string{move}, res.Pv...)
+				s.combined.Err = nil
+			}
+		case errors.Is(res.Err, errNoMate):
+		default:
+			s.unresolved += 1
+			if unresolved == nil {
+				unresolved = res.Err
+			}
+		}
+	}
+
+	if s.combined.Err == nil {
+		if s.unresolved > 0 {
+			slog.Warn("the mate may not be the shortest since some first moves are unresolved", "unresolved", s.unresolved)
+		}
+		s.combined = verifyResult(s.combined)
+	} else if unresolved != nil {
+		s.combined.Err = unresolved
+	}
+	return s, nil
+}
+
+// String returns the outcome of each first move followed by the combined result.
+func (s SplitResult) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v\n", s.combined.Problem)
+	for i, move := range s.moves {
+		res := s.results[i]
+		fmt.Fprintf(&sb, "  %-8s  %18s  %8.2fs  %10d\n", move, compareOutcome(res), res.Time.Seconds(), res.Nodes)
+	}
+	if s.combined.Err == nil {
+		fmt.Fprintf(&sb, "checkmate %s", strings.Join(s.combined.Pv, " "))
+	} else {
+		fmt.Fprintf(&sb, "%v", s.combined.Err)
+	}
+	fmt.Fprintf(&sb, "  (checks: %d, unresolved: %d, %.2f sec)\n", len(s.moves), s.unresolved, s.combined.Time.Seconds())
+	return sb.String()
+}
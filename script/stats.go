@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+)
+
+// percentile returns the p-th percentile of sorted by the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// timeSampleSize is the number of solve times kept with --stream, where the percentiles are
+// estimated from a sample of the times instead of all of them.
+const timeSampleSize = 10000
+
+// TimeSample collects solve times, keeping all of them, or a uniform random sample of at most
+// size of them if size > 0 so that the memory does not grow with the number of positions. The
+// count, the total, the maximum and the histogram are exact either way.
+type TimeSample struct {
+	size    int
+	count   int
+	total   time.Duration
+	max     time.Duration
+	buckets []int
+	samples []time.Duration
+	// sorted is set while samples is in ascending order
+	sorted bool
+}
+
+func newTimeSample(size int) *TimeSample {
+	return &TimeSample{size: size, buckets: make([]int, len(histogramBounds)+1)}
+}
+
+func (s *TimeSample) Add(t time.Duration) {
+	s.count++
+	s.total += t
+	s.max = max(s.max, t)
+	s.buckets[histogramBucketIndex(t)]++
+
+	// reservoir sampling: the i-th time replaces a random one of the sample with the
+	// probability size/i
+	if s.size > 0 && len(s.samples) >= s.size {
+		if rand.Intn(s.count) >= s.size {
+			return
+		}
+		s.samples[rand.Intn(len(s.samples))] = t
+	} else {
+		s.samples = append(s.samples, t)
+	}
+	s.sorted = false
+}
+
+func (s *TimeSample) Len() int {
+	return s.count
+}
+
+func (s *TimeSample) Total() time.Duration {
+	return s.total
+}
+
+// Percentile returns the p-th percentile of the times, which is estimated from the sample if
+// the times are sampled.
+func (s *TimeSample) Percentile(p float64) time.Duration {
+	if !s.sorted {
+		slices.Sort(s.samples)
+		s.sorted = true
+	}
+	return percentile(s.samples, p)
+}
+
+// Histogram returns the times counted into buckets bounded by histogramBounds.
+func (s *TimeSample) Histogram() []histogramBucket {
+	buckets := histogramBuckets()
+	for i, count := range s.buckets {
+		buckets[i].Count = count
+	}
+	return buckets
+}
+
+// TimeStats returns the distribution of the solve times and the throughput of the run which
+// took elapsed. Cached and mirrored results are excluded as the engine did not solve them.
+func (s Summary) TimeStats(elapsed time.Duration) string {
+	if s.times == nil || s.times.Len() == 0 {
+		return ""
+	}
+
+	total := s.times.Total()
+	throughput := 0.0
+	if elapsed > 0 {
+		throughput = float64(s.times.Len()) / elapsed.Minutes()
+	}
+
+	str := fmt.Sprintf("p50: %.2fs  p90: %.2fs  p99: %.2fs  max: %.2fs  engine time: %.2fs  throughput: %.1f positions/min",
+		s.times.Percentile(50).Seconds(), s.times.Percentile(90).Seconds(), s.times.Percentile(99).Seconds(),
+		s.times.max.Seconds(), total.Seconds(), throughput)
+	if s.nodes > 0 && total > 0 {
+		str += fmt.Sprintf("\nnodes: %d  avg nps: %.0f", s.nodes, float64(s.nodes)/total.Seconds())
+	}
+
+	return str
+}
+
+var histogramBounds = []time.Duration{
+	10 * time.Millisecond, 30 * time.Millisecond, 100 * time.Millisecond, 300 * time.Millisecond,
+	time.Second, 3 * time.Second, 10 * time.Second, 30 * time.Second, time.Minute, 3 * time.Minute,
+}
+
+type histogramBucket struct {
+	Label string
+	Count int
+}
+
+func formatBound(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	default:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+}
+
+// histogramBuckets returns the empty buckets bounded by histogramBounds. The last bucket holds
+// times longer than every bound.
+func histogramBuckets() []histogramBucket {
+	buckets := make([]histogramBucket, len(histogramBounds)+1)
+	for i, bound := range histogramBounds {
+		buckets[i].Label = "<" + formatBound(bound)
+	}
+	buckets[len(histogramBounds)].Label = ">=" + formatBound(histogramBounds[len(histogramBounds)-1])
+	return buckets
+}
+
+// histogramBucketIndex returns the index of the bucket of t.
+func histogramBucketIndex(t time.Duration) int {
+	i := 0
+	for i < len(histogramBounds) && t >= histogramBounds[i] {
+		i++
+	}
+	return i
+}
+
+// timeHistogram counts times into buckets bounded by histogramBounds.
+func timeHistogram(times []time.Duration) []histogramBucket {
+	buckets := histogramBuckets()
+	for _, t := range times {
+		buckets[histogramBucketIndex(t)].Count++
+	}
+	return buckets
+}
+
+// TimeHistogram returns the histogram of the solve times as text.
+func (s Summary) TimeHistogram() string {
+	const width = 50
+
+	buckets := s.times.Histogram()
+	max_count := 1
+	for _, bucket := range buckets {
+		if bucket.Count > max_count {
+			max_count = bucket.Count
+		}
+	}
+
+	var sb strings.Builder
+	for _, bucket := range buckets {
+		fmt.Fprintf(&sb, "%8s %6d %s\n", bucket.Label, bucket.Count,
+			strings.Repeat("#", (bucket.Count*width+max_count-1)/max_count))
+	}
+
+	return sb.String()
+}
+
+// histogramSvg draws buckets as an SVG bar chart.
+func histogramSvg(buckets []histogramBucket) string {
+	const width, height, gap = 48, 160, 4
+
+	max_count := 1
+	for _, bucket := range buckets {
+		if bucket.Count > max_count {
+			max_count = bucket.Count
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11" text-anchor="middle">`+"\n",
+		len(buckets)*(width+gap), height+40)
+	for i, bucket := range buckets {
+		x := i * (width + gap)
+		h := bucket.Count * height / max_count
+		y := height - h + 20
+		label := html.EscapeString(bucket.Label)
+		fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="%d" height="%d" fill="#48c"><title>%s: %d</title></rect>`+"\n",
+			x, y, width, h, label, bucket.Count)
+		fmt.Fprintf(&sb, `<text x="%d" y="%d">%d</text>`+"\n", x+width/2, y-4, bucket.Count)
+		fmt.Fprintf(&sb, `<text x="%d" y="%d">%s</text>`+"\n", x+width/2, height+32, label)
+	}
+	sb.WriteString("</svg>\n")
+
+	return sb.String()
+}
+
+var mateLenBuckets = []struct {
+	max   int
+	label string
+}{
+	{9, "1-9"}, {29, "11-29"}, {99, "31-99"}, {math.MaxInt, "100+"},
+}
+
+const unknownMateLen = "unknown"
+
+// AddMateLen counts res into the bucket of its mate length, which is the expected length if
+// given, or the length of the solution otherwise.
+func (s *Summary) AddMateLen(res Result) {
+	mate_len := res.Problem.MateLen
+	if mate_len == 0 && res.Err == nil {
+		mate_len = len(res.Pv)
+	}
+
+	label := unknownMateLen
+	if mate_len > 0 {
+		for _, bucket := range mateLenBuckets {
+			if mate_len <= bucket.max {
+				label = bucket.label
+				break
+			}
+		}
+	}
+
+	if s.mate_lens == nil {
+		s.mate_lens = make(map[string]*TagSummary)
+	}
+	t, ok := s.mate_lens[label]
+	if !ok {
+		t = &TagSummary{}
+		s.mate_lens[label] = t
+	}
+	t.Add(res)
+}
+
+func (s Summary) MateLenTable() string {
+	var names []string
+	for _, bucket := range mateLenBuckets {
+		if _, ok := s.mate_lens[bucket.label]; ok {
+			names = append(names, bucket.label)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	if _, ok := s.mate_lens[unknownMateLen]; ok {
+		names = append(names, unknownMateLen)
+	}
+
+	return summaryTable("mate", names, s.mate_lens)
+}
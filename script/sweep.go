@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SweepPoint is the outcome of solving a position again with an engine option changed.
+type SweepPoint struct {
+	Option  string
+	Value   int
+	Outcome string
+}
+
+func (p SweepPoint) String() string {
+	return fmt.Sprintf("%s=%d:%s", p.Option, p.Value, p.Outcome)
+}
+
+func formatSweeps(points []SweepPoint) string {
+	strs := make([]string, len(points))
+	for i, point := range points {
+		strs[i] = point.String()
+	}
+	return strings.Join(strs, " ")
+}
+
+// outcome summarizes res for comparisons between sweeps, e.g. "mate7" or "timeout".
+func outcome(res Result) string {
+	if res.Err != nil {
+		return res.Category()
+	}
+	return fmt.Sprintf("mate%d", len(res.Pv))
+}
+
+// sweepChanged returns true if the outcome of any sweep of res differs from res itself.
+func sweepChanged(res Result) bool {
+	expected := outcome(res)
+	for _, point := range res.Sweeps {
+		if point.Outcome != expected {
+			return true
+		}
+	}
+	return false
+}
+
+// Sweep solves problem again with the engine option changed to each of values, and restores
+// the option to current afterwards.
+func (ep *EngineProcess) Sweep(problem Problem, time_limit_ms int, option string, values []int, current int) ([]SweepPoint, error) {
+	var points []SweepPoint
+	for _, value := range values {
+		fmt.Fprintf(ep.stdin, "setoption name %s value %d\n", option, value)
+		if err := ep.Ready(); err != nil {
+			return points, err
+		}
+
+		res := ep.Solve(problem, time_limit_ms)
+		res.Problem = problem
+		res = verifyResult(res)
+		if errors.Is(res.Err, errAborted) {
+			return points, res.Err
+		}
+		points = append(points, SweepPoint{Option: option, Value: value, Outcome: outcome(res)})
+	}
+
+	fmt.Fprintf(ep.stdin, "setoption name %s value %d\n", option, current)
+	return points, ep.Ready()
+}
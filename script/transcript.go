@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transcript records the USI conversation with an engine. Each line is prefixed with the
+// time and the direction: ">" for commands sent to the engine, "<" for its responses and "!"
+// for the lines it writes to stderr.
+//
+// A buffered transcript is kept in memory until it is closed, so that it is saved only if
+// the result is worth looking into.
+type Transcript struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	buf  *bytes.Buffer
+	w    *bufio.Writer
+}
+
+func openTranscript(dir string, problem Problem, buffered bool) (*Transcript, error) {
+	t := &Transcript{path: filepath.Join(dir, solutionFileName(problem, ".log"))}
+	if buffered {
+		t.buf = &bytes.Buffer{}
+		t.w = bufio.NewWriter(t.buf)
+	} else {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		file, err := os.Create(t.path)
+		if err != nil {
+			return nil, err
+		}
+		t.file = file
+		t.w = bufio.NewWriter(file)
+	}
+
+	fmt.Fprintf(t.w, "# %v\n", problem)
+	return t, nil
+}
+
+// Record writes a line of the conversation. It does nothing if t is nil.
+func (t *Transcript) Record(direction string, line string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.w, "%s %s %s\n", time.Now().Format("15:04:05.000"), direction, line)
+}
+
+// Close finishes the transcript. A buffered transcript is written into its file only if
+// keep is set.
+func (t *Transcript) Close(keep bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.w.Flush(); err != nil {
+		if t.file != nil {
+			t.file.Close()
+		}
+		return err
+	}
+	if t.file != nil {
+		return t.file.Close()
+	}
+	if !keep {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(t.path, t.buf.Bytes(), 0644)
+}
+
+// engineInput passes commands to the engine recording them into the transcript of ep.
+type engineInput struct {
+	io.WriteCloser
+	ep *EngineProcess
+}
+
+func (in *engineInput) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		in.ep.transcript.Record(">", line)
+	}
+	return in.WriteCloser.Write(p)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tuiInterval    = 500 * time.Millisecond
+	tuiRecentLines = 8
+	tuiSfenWidth   = 64
+)
+
+// tuiProgress draws a full-screen dashboard of the workers on the alternate screen of the
+// terminal. Lines printed while it is shown are written out again when it is closed.
+type tuiProgress struct {
+	mu      sync.Mutex
+	w       io.Writer
+	monitor *Monitor
+	total   int
+	done    int
+	failed  int
+	nodes   int64
+	start   time.Time
+	lines   []string
+	closed  bool
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// newTuiProgress starts drawing the dashboard. On an interrupt, the terminal is restored and
+// the process exits unless graceful is set, in which case the run is left to finish by itself.
+func newTuiProgress(total int, monitor *Monitor, w io.Writer, graceful bool) *tuiProgress {
+	t := &tuiProgress{
+		w:       w,
+		monitor: monitor,
+		total:   total,
+		start:   time.Now(),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	fmt.Fprint(w, "\x1b[?1049h\x1b[?25l")
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		defer close(t.stopped)
+		defer signal.Stop(interrupt)
+		ticker := time.NewTicker(tuiInterval)
+		defer ticker.Stop()
+		for {
+			t.draw()
+			select {
+			case <-ticker.C:
+			case <-interrupt:
+				t.close()
+				if !graceful {
+					exit(130)
+				}
+				return
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+func (t *tuiProgress) Add(res Result) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done++
+	if res.Unexpected() {
+		t.failed++
+	}
+	if !res.Cached && res.MirrorOf == nil {
+		t.nodes += res.Nodes
+	}
+}
+
+func (t *tuiProgress) SetTotal(total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total = total
+}
+
+func (t *tuiProgress) Print(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		fmt.Fprintln(t.w, line)
+		return
+	}
+	t.lines = append(t.lines, line)
+}
+
+func (t *tuiProgress) Finish(summary Summary) {
+	close(t.stop)
+	<-t.stopped
+	t.close()
+}
+
+// close leaves the alternate screen and writes out the lines printed so far.
+func (t *tuiProgress) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	t.closed = true
+	fmt.Fprint(t.w, "\x1b[?25h\x1b[?1049l")
+	for _, line := range t.lines {
+		fmt.Fprintln(t.w, line)
+	}
+	t.lines = nil
+}
+
+func (t *tuiProgress) draw() {
+	workers := t.monitor.Workers()
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+
+	elapsed := now.Sub(t.start)
+	nodes := t.nodes
+	for _, worker := range workers {
+		if worker.Busy {
+			nodes += worker.Nodes
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("\x1b[H\x1b[2J")
+	total := "?"
+	if t.total >= 0 {
+		total = fmt.Sprint(t.total)
+	}
+	fmt.Fprintf(&b, "positions: %d/%s  unexpected: %d  elapsed: %v\n",
+		t.done, total, t.failed, elapsed.Round(time.Second))
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		fmt.Fprintf(&b, "throughput: %.1f positions/min  %.0f nodes/sec\n",
+			float64(t.done)/seconds*60, float64(nodes)/seconds)
+	}
+	b.WriteString("\n")
+
+	fmt.Fprintf(&b, "%6s  %9s  %12s  %-10s  %s\n", "worker", "time", "nodes", "score", "position")
+	for i, worker := range workers {
+		if !worker.Busy {
+			fmt.Fprintf(&b, "%6d  %9s\n", i, "idle")
+			continue
+		}
+		position := problemKey(worker.Problem.Sfen, worker.Problem.Moves)
+		if worker.Problem.ID != "" {
+			position = worker.Problem.ID + " " + position
+		}
+		if len(position) > tuiSfenWidth {
+			position = position[:tuiSfenWidth-3] + "..."
+		}
+		fmt.Fprintf(&b, "%6d  %8.1fs  %12d  %-10s  %s\n",
+			i, now.Sub(worker.Start).Seconds(), worker.Nodes, worker.Score, position)
+	}
+
+	if len(t.lines) > 0 {
+		b.WriteString("\n")
+		recent := t.lines
+		if len(recent) > tuiRecentLines {
+			recent = recent[len(recent)-tuiRecentLines:]
+		}
+		b.WriteString(strings.Join(recent, "\n"))
+		b.WriteString("\n")
+	}
+	t.w.Write(b.Bytes())
+}
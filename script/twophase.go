@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// Phase is one pass of a two-phase run: the positions given to the pass, the settings and
+// the results.
+type Phase struct {
+	name       string
+	hash_size  int
+	time_limit int
+	problems   []Problem
+	results    []Result
+	elapsed    time.Duration
+}
+
+// survives returns true if res may be solved with more hash or time, i.e. the search ran out
+// of either of them.
+func survives(res Result) bool {
+	switch res.Category() {
+	case "timeout", "time_limit", "nodes_limit", "no_pv":
+		return true
+	}
+	return false
+}
+
+// solveTwoPhase solves problems quickly with the hash size and the time limit of op, and then
+// solves the survivors of the first pass again with second_hash and second_time_limit.
+func solveTwoPhase(command string, op Options, problems []Problem, second_hash int, second_time_limit int) []Phase {
+	settings := []struct {
+		name       string
+		hash_size  int
+		time_limit int
+	}{
+		{"quick", op.HashSize, op.TimeLimit},
+		{"deep", second_hash, second_time_limit},
+	}
+
+	var phases []Phase
+	for _, setting := range settings {
+		if len(problems) == 0 {
+			break
+		}
+		phase_op := op
+		phase_op.HashSize = setting.hash_size
+		phase_op.TimeLimit = setting.time_limit
+		slog.Info("starting the phase", "phase", setting.name, "positions", len(problems), "hash", setting.hash_size, "time_limit", setting.time_limit)
+		start := time.Now()
+		phase := Phase{
+			name:       setting.name,
+			hash_size:  setting.hash_size,
+			time_limit: setting.time_limit,
+			problems:   problems,
+			results:    solveAll(command, phase_op, problems),
+			elapsed:    time.Since(start),
+		}
+		phases = append(phases, phase)
+
+		problems = nil
+		for _, res := range phase.results {
+			if survives(res) {
+				problems = append(problems, res.Problem)
+			}
+		}
+	}
+
+	return phases
+}
+
+// finalResults returns the result of every position of the first phase, replaced with the
+// result of the later phase which solved it again.
+func finalResults(phases []Phase) []Result {
+	if len(phases) == 0 {
+		return nil
+	}
+	results := append([]Result(nil), phases[0].results...)
+	index := make(map[string][]int)
+	for i, res := range results {
+		key := problemLabel(res.Problem)
+		index[key] = append(index[key], i)
+	}
+	for _, phase := range phases[1:] {
+		for _, res := range phase.results {
+			for _, i := range index[problemLabel(res.Problem)] {
+				if survives(results[i]) {
+					results[i] = res
+					break
+				}
+			}
+		}
+	}
+	return results
+}
+
+// TwoPhaseReport returns the summary of each phase followed by the positions left unsolved
+// after all phases.
+func TwoPhaseReport(phases []Phase) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%-6s  %9s  %10s  %9s  %6s  %7s  %9s  %9s\n", "phase", "hash", "time limit", "positions", "solved", "nomate", "survivors", "time")
+	for _, phase := range phases {
+		solved, nomate, survivors := 0, 0, 0
+		for _, res := range phase.results {
+			switch {
+			case res.Err == nil:
+				solved += 1
+			case res.Category() == "nomate":
+				nomate += 1
+			case survives(res):
+				survivors += 1
+			}
+		}
+		time_limit := "-"
+		if phase.time_limit > 0 {
+			time_limit = fmt.Sprintf("%.1fs", float64(phase.time_limit)/1000)
+		}
+		fmt.Fprintf(&sb, "%-6s  %7dMB  %10s  %9d  %6d  %7d  %9d  %8.2fs\n", phase.name, phase.hash_size, time_limit,
+			len(phase.problems), solved, nomate, survivors, phase.elapsed.Seconds())
+	}
+
+	results := finalResults(phases)
+	solved := 0
+	var unsolved []string
+	for _, res := range results {
+		if res.Err == nil {
+			solved += 1
+		} else if survives(res) {
+			unsolved = append(unsolved, fmt.Sprintf("%s: %v", res.Category(), res.Problem))
+		}
+	}
+	if len(unsolved) > 0 {
+		sb.WriteString("\nunsolved:\n")
+		for _, line := range unsolved {
+			fmt.Fprintf(&sb, "  %s\n", line)
+		}
+	}
+	fmt.Fprintf(&sb, "\nsolved/total: %d/%d\n", solved, len(results))
+
+	return sb.String()
+}
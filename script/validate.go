@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+)
+
+var usiMovePattern = regexp.MustCompile(`^([1-9][a-i][1-9][a-i]\+?|[RBGSNLP]\*[1-9][a-i])$`)
+
+var maxPieces = map[shogi.PieceType]int{
+	shogi.King: 2, shogi.Rook: 2, shogi.Bishop: 2, shogi.Gold: 4, shogi.Silver: 4, shogi.Knight: 4, shogi.Lance: 4, shogi.Pawn: 18,
+}
+
+// validateProblem checks that the problem is a sane mate problem: a well-formed SFEN with the
+// defender's king on the board, no more pieces than the game has, and no dead pieces or
+// doubled pawns.
+func validateProblem(problem Problem) error {
+	for _, move := range problem.Moves {
+		if !usiMovePattern.MatchString(move) {
+			return fmt.Errorf("invalid move: %s", move)
+		}
+	}
+	if problem.Sfen == "startpos" {
+		return nil
+	}
+
+	fields := strings.Fields(problem.Sfen)
+	if len(fields) < 3 || len(fields) > 4 {
+		return fmt.Errorf("an sfen must have 3 or 4 fields")
+	}
+	pos, err := shogi.ParseSfen(problem.Sfen)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[shogi.PieceType]int)
+	kings := [2]int{}
+	pawn_files := [2][10]bool{}
+	for sq := shogi.Square(0); sq < 81; sq++ {
+		piece := pos.Piece(sq)
+		if piece.IsEmpty() {
+			continue
+		}
+		t := piece.Type.Unpromoted()
+		counts[t]++
+
+		// the distance to the last rank from the owner's point of view
+		depth := sq.Rank() - 1
+		if piece.Color == shogi.White {
+			depth = 9 - sq.Rank()
+		}
+		switch {
+		case t == shogi.King:
+			kings[piece.Color]++
+		case piece.Type.IsPromoted():
+		case (t == shogi.Pawn || t == shogi.Lance) && depth == 0:
+			return fmt.Errorf("%v on the last rank at %v", piece, sq)
+		case t == shogi.Knight && depth <= 1:
+			return fmt.Errorf("%v on the last two ranks at %v", piece, sq)
+		case t == shogi.Pawn:
+			if pawn_files[piece.Color][sq.File()] {
+				return fmt.Errorf("two pawns on file %d", sq.File())
+			}
+			pawn_files[piece.Color][sq.File()] = true
+		}
+	}
+	for _, color := range []shogi.Color{shogi.Black, shogi.White} {
+		for _, t := range shogi.HandTypes {
+			counts[t] += pos.Hand(color, t)
+		}
+	}
+	for t, count := range counts {
+		if count > maxPieces[t] {
+			return fmt.Errorf("too many pieces: %d %v", count, t)
+		}
+	}
+
+	attacker := pos.SideToMove()
+	if kings[attacker.Opponent()] != 1 {
+		return fmt.Errorf("the defender must have exactly one king")
+	}
+	if kings[attacker] > 1 {
+		return fmt.Errorf("the attacker has %d kings", kings[attacker])
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/komori-n/KomoringHeights/script/pkg/shogi"
+)
+
+var (
+	errIllegalPv  = errors.New("illegal pv")
+	errNotMate    = errors.New("the pv does not end in checkmate")
+	errPawnDrop   = errors.New("pawn drop violation")
+	errRepetition = errors.New("repetition in the pv")
+)
+
+// isPawnDropViolation returns true if err is the reason why a pawn drop m is illegal under the
+// rules specific to pawn drops, i.e. checkmate by a pawn drop, two pawns on a file and a drop
+// on the last rank.
+func isPawnDropViolation(m shogi.Move, err error) bool {
+	if !m.IsDrop() || m.Drop != shogi.Pawn {
+		return false
+	}
+
+	return errors.Is(err, shogi.ErrPawnDropMate) || errors.Is(err, shogi.ErrTwoPawns) || errors.Is(err, shogi.ErrDeadPiece)
+}
+
+// sfenWithoutPly returns the SFEN of pos without the move number, which identifies the
+// position for repetitions.
+func sfenWithoutPly(pos *shogi.Position) string {
+	sfen := pos.Sfen()
+	return sfen[:strings.LastIndexByte(sfen, ' ')]
+}
+
+// findRepetition returns errRepetition if a position appears twice while pv is played from
+// start. A cycle in which every move of the attacker is a check is reported as a perpetual
+// check, which loses for the attacker when repeated (連続王手の千日手).
+func findRepetition(start shogi.Position, pv []shogi.Move) error {
+	pos := start
+	seen := map[string]int{sfenWithoutPly(&pos): 0}
+	checks := make([]bool, 0, len(pv))
+	for i, m := range pv {
+		pos.Do(m)
+		checks = append(checks, pos.InCheck())
+
+		key := sfenWithoutPly(&pos)
+		prev, ok := seen[key]
+		if !ok {
+			seen[key] = i + 1
+			continue
+		}
+
+		// the moves of the attacker are the even ones
+		perpetual := true
+		for j := prev; j <= i; j++ {
+			perpetual = perpetual && (j%2 == 1 || checks[j])
+		}
+		repeated := fmt.Sprintf("the position after move %d", prev)
+		if prev == 0 {
+			repeated = "the initial position"
+		}
+		if perpetual {
+			return fmt.Errorf("%w: perpetual check: move %d repeats %s", errRepetition, i+1, repeated)
+		}
+		return fmt.Errorf("%w: move %d repeats %s", errRepetition, i+1, repeated)
+	}
+	return nil
+}
+
+// problemPosition returns the position of problem after its moves are played.
+func problemPosition(problem Problem) (*shogi.Position, error) {
+	pos, err := shogi.ParseSfen(problem.Sfen)
+	if err != nil {
+		return nil, err
+	}
+	if err := pos.DoUsi(problem.Moves); err != nil {
+		return nil, err
+	}
+
+	return pos, nil
+}
+
+// verifyResult replays the PV of res from the position of its problem, and turns res into a
+// failure if any move of the PV is illegal, a position repeats in the PV or the final position
+// is not checkmate. Violations of the pawn drop rules are reported as errPawnDrop instead of
+// errIllegalPv. The pieces left in the hand of the attacker at the mate are stored into
+// res.Surplus. Positions which cannot be set up are not verified.
+func verifyResult(res Result) Result {
+	if res.Err != nil {
+		return res
+	}
+	pos, err := problemPosition(res.Problem)
+	if err != nil {
+		slog.Debug("skipped verifying the pv", "position", problemLabel(res.Problem), "error", err)
+		return res
+	}
+
+	start := *pos
+	attacker := pos.SideToMove()
+	moves := make([]shogi.Move, 0, len(res.Pv))
+	for i, usi := range res.Pv {
+		m, err := pos.ParseMove(usi)
+		if err != nil {
+			res.Err = fmt.Errorf("%w: move %d: %v", errIllegalPv, i+1, err)
+			return res
+		}
+		if err := pos.CheckLegal(m); err != nil {
+			reason := errIllegalPv
+			if isPawnDropViolation(m, err) {
+				reason = errPawnDrop
+			}
+			res.Err = fmt.Errorf("%w: move %d: %v: %s", reason, i+1, err, usi)
+			return res
+		}
+		pos.Do(m)
+		moves = append(moves, m)
+	}
+	if err := findRepetition(start, moves); err != nil {
+		res.Err = err
+		return res
+	}
+	if !pos.IsCheckmate() {
+		res.Err = fmt.Errorf("%w: %s", errNotMate, pos.Sfen())
+		return res
+	}
+	res.Surplus = strings.ToUpper(pos.HandSfen(attacker))
+
+	return res
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"time"
+)
+
+type watchedFile struct {
+	size    int64
+	modtime time.Time
+	done    bool
+}
+
+// watchDirectory polls dir and passes problems in new or modified problem files to emit
+// until stop is closed. A file is read once its size and modification time stay unchanged
+// for a polling interval so that partially copied files are not read.
+func watchDirectory(dir string, interval time.Duration, stop <-chan struct{}, emit func(Problem)) error {
+	files := make(map[string]*watchedFile)
+	for {
+		var ready []string
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() || !(isProblemFile(path) || isArchiveFile(path)) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+
+			file, ok := files[path]
+			if !ok {
+				files[path] = &watchedFile{size: info.Size(), modtime: info.ModTime()}
+				return nil
+			}
+			if file.size != info.Size() || !file.modtime.Equal(info.ModTime()) {
+				*file = watchedFile{size: info.Size(), modtime: info.ModTime()}
+				return nil
+			}
+			if !file.done {
+				file.done = true
+				ready = append(ready, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, path := range ready {
+			err := scanFile(path, nil, func(problem Problem) {
+				problem.Source = path
+				emit(problem)
+			})
+			if err != nil {
+				slog.Error("failed to read the problem file", "path", path, "error", err)
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}